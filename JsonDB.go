@@ -1,24 +1,119 @@
 package JsonDB
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"github.com/StephenChristianW/JsonDB/config"
+	"github.com/StephenChristianW/JsonDB/fileIO"
 	"github.com/StephenChristianW/JsonDB/services"
+	"iter"
+	"sync"
+	"time"
 )
 
 // ---------------- 高层服务 ----------------
 
 type DBManager struct {
-	Ctx *services.DBContext
+	Ctx   *services.DBContext
+	store services.Storage
+
+	// 后台 TTL 清理
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewDBManager 创建新实例
-func NewDBManager(dbName, collectionName string) *DBManager {
-	ctx := &services.DBContext{
-		CurrentDB:         dbName,
-		CurrentCollection: collectionName,
+//
+// storage 可选，指定存储后端（如 services.NewMemoryStorage()）；不传时使用默认的 JSON 目录存储，
+// 根目录取环境变量 JSONDB_ROOT，未设置时为 <工作目录>/JsonDataBase。
+// 创建前会调用存储后端的 Recover，恢复上次异常退出前已提交的写入；恢复失败时返回错误，不创建实例。
+// 后台 TTL 清理按 config.DefaultTTLInterval 运行，需要调整间隔或关闭时使用 NewDBManagerWithOptions；
+// 不再使用实例时应调用 Close，停止后台清理并写入延迟落盘的集合、推迟的文档数量与索引。
+func NewDBManager(dbName, collectionName string, storage ...services.Storage) (*DBManager, error) {
+	var store services.Storage
+	if len(storage) > 0 && storage[0] != nil {
+		store = storage[0]
+	} else {
+		store = services.DefaultStorage()
+	}
+	// 集合文件落后于 WAL 中已提交的写入，继续使用会读到旧数据
+	if err := store.Recover(); err != nil {
+		return nil, err
+	}
+	m := &DBManager{Ctx: services.NewDBContext(dbName, collectionName, store), store: store}
+	m.startSweeper(store, config.DefaultTTLInterval)
+	return m, nil
+}
+
+// Options 数据根目录及文件权限配置，见 config.Options
+type Options = config.Options
+
+// FlushPolicy 数据库的落盘策略，见 config.FlushPolicy
+type FlushPolicy = config.FlushPolicy
+
+// NewDBManagerWithOptions 在指定数据根目录上创建实例
+//
+// 根目录不存在时按 opts.DirMode 创建；不同 RootDir 的实例互不影响，可在同一进程中并存。
+// 同一 RootDir 可以由多个进程同时打开，读写通过文件锁协调，等待超过 opts.LockTimeout 时返回 *fileIO.LockedError。
+// 后台 TTL 清理间隔由 opts.TTLInterval 指定，不再使用时应调用 Close。
+func NewDBManagerWithOptions(dbName, collectionName string, opts Options) (*DBManager, error) {
+	opts = opts.Normalize()
+	if err := fileIO.CreateDirectoryMode(opts.RootDir, opts.DirMode); err != nil {
+		return nil, err
+	}
+	store := services.NewFileStorage(opts)
+	if err := store.Recover(); err != nil {
+		return nil, err
+	}
+	m := &DBManager{Ctx: services.NewDBContext(dbName, collectionName, store), store: store}
+	m.startSweeper(store, opts.TTLInterval)
+	return m, nil
+}
+
+// startSweeper 启动后台 TTL 清理，interval 小于等于 0 时不启动
+func (m *DBManager) startSweeper(store services.Storage, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				// 错误已由 SweepExpired 记录到错误日志
+				_, _ = services.SweepExpired(store)
+			}
+		}
+	}()
+}
+
+// Close 停止后台 TTL 清理并等待正在进行的清理结束，然后把延迟落盘的写入写入集合文件、推迟的文档数量与索引写入存储，可重复调用
+func (m *DBManager) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		if m.stop != nil {
+			close(m.stop)
+			<-m.done
+		}
+		err = services.FlushAll(m.store)
+	})
+	return err
+}
+
+// Open 打开 path 作为数据根目录，其余配置使用默认值
+func Open(path string) (*DBManager, error) {
+	if path == "" {
+		return nil, errors.New("数据根目录不能为空")
 	}
-	return &DBManager{Ctx: ctx}
+	return NewDBManagerWithOptions("", "", Options{RootDir: path})
 }
 
 // ---------------- Doc操作封装 ----------------
@@ -27,6 +122,16 @@ func (m *DBManager) Find(filter map[string]interface{}, opts *services.FindOptio
 	return m.Ctx.Find(filter, opts)
 }
 
+// FindCursor 查询当前集合，结果通过游标逐批读取，见 services.DBContext.FindCursor
+func (m *DBManager) FindCursor(filter map[string]interface{}, opts *services.FindOptions) (*services.Cursor, error) {
+	return m.Ctx.FindCursor(filter, opts)
+}
+
+// FindSeq FindCursor 的迭代器形式，可直接用于 for range
+func (m *DBManager) FindSeq(ctx context.Context, filter map[string]interface{}, opts *services.FindOptions) iter.Seq2[services.Document, error] {
+	return m.Ctx.FindSeq(ctx, filter, opts)
+}
+
 func (m *DBManager) FindOne(filter map[string]interface{}) (services.Document, error) {
 	return m.Ctx.FindOne(filter)
 }
@@ -35,30 +140,59 @@ func (m *DBManager) Insert(doc services.Document) (services.Document, error) {
 	return m.Ctx.InsertOne(doc)
 }
 
-func (m *DBManager) InsertMany(docs []services.Document) ([]services.Document, error) {
-	return m.Ctx.InsertMany(docs)
+func (m *DBManager) InsertMany(docs []services.Document, opts ...services.InsertManyOptions) ([]services.Document, error) {
+	return m.Ctx.InsertMany(docs, opts...)
+}
+
+func (m *DBManager) Update(filter map[string]interface{}, update services.Document, opts ...services.UpdateOptions) (*services.WriteResult, error) {
+	return m.Ctx.UpdateOne(filter, update, opts...)
 }
 
-func (m *DBManager) Update(filter map[string]interface{}, update services.Document) (services.Document, error) {
-	return m.Ctx.UpdateOne(filter, update)
+func (m *DBManager) UpdateMany(filter map[string]interface{}, update services.Document, opts ...services.UpdateOptions) (*services.WriteResult, error) {
+	return m.Ctx.UpdateMany(filter, update, opts...)
 }
 
-func (m *DBManager) UpdateMany(filter map[string]interface{}, update services.Document) ([]services.Document, error) {
-	return m.Ctx.UpdateMany(filter, update)
+func (m *DBManager) ReplaceOne(filter map[string]interface{}, replacement services.Document, opts ...services.UpdateOptions) (*services.WriteResult, error) {
+	return m.Ctx.ReplaceOne(filter, replacement, opts...)
 }
 
-func (m *DBManager) Delete(filter map[string]interface{}) (int, error) {
+func (m *DBManager) DeleteOne(filter map[string]interface{}, opts ...services.DeleteOptions) (*services.WriteResult, error) {
+	return m.Ctx.DeleteOne(filter, opts...)
+}
+
+func (m *DBManager) Delete(filter map[string]interface{}) (*services.WriteResult, error) {
 	return m.Ctx.Delete(filter)
 }
 
+func (m *DBManager) BulkWrite(models []services.WriteModel, opts ...services.BulkWriteOptions) (*services.BulkWriteResult, error) {
+	return m.Ctx.BulkWrite(models, opts...)
+}
+
+func (m *DBManager) FindOneAndUpdate(filter map[string]interface{}, update services.Document, opts ...services.FindOneAndOptions) (services.Document, error) {
+	return m.Ctx.FindOneAndUpdate(filter, update, opts...)
+}
+
+func (m *DBManager) FindOneAndReplace(filter map[string]interface{}, replacement services.Document, opts ...services.FindOneAndOptions) (services.Document, error) {
+	return m.Ctx.FindOneAndReplace(filter, replacement, opts...)
+}
+
+func (m *DBManager) FindOneAndDelete(filter map[string]interface{}, opts ...services.FindOneAndOptions) (services.Document, error) {
+	return m.Ctx.FindOneAndDelete(filter, opts...)
+}
+
+// WithTransaction 在当前数据库上执行事务，fn 返回 nil 时一次性提交，返回错误或 panic 时回滚，见 services.DBContext.WithTransaction
+func (m *DBManager) WithTransaction(fn func(tx *services.Tx) error) error {
+	return m.Ctx.WithTransaction(fn)
+}
+
 // ---------------- Collection操作封装 ----------------
 
 func (m *DBManager) SwitchCollection(name string) {
 	m.Ctx.CurrentCollection = name
 }
 
-func (m *DBManager) CreateCollection(name string) error {
-	return m.Ctx.CollectionCreate(name)
+func (m *DBManager) CreateCollection(name string, opts ...services.CollectionOptions) error {
+	return m.Ctx.CollectionCreate(name, opts...)
 }
 
 func (m *DBManager) DeleteCollection(name string) error {
@@ -73,6 +207,21 @@ func (m *DBManager) RenameCollection(oldName, newName string) error {
 	return m.Ctx.CollectionRename(oldName, newName)
 }
 
+// CollectionFormat 当前集合的文件格式
+func (m *DBManager) CollectionFormat() (services.CollectionFormat, error) {
+	return m.Ctx.GetCollectionFormat(m.Ctx.CurrentCollection)
+}
+
+// SetCollectionFormat 把当前集合转换为指定的文件格式
+func (m *DBManager) SetCollectionFormat(format services.CollectionFormat) error {
+	return m.Ctx.SetCollectionFormat(m.Ctx.CurrentCollection, format)
+}
+
+// Compact 压缩当前集合的追加日志
+func (m *DBManager) Compact() error {
+	return m.Ctx.CompactCollection(m.Ctx.CurrentCollection)
+}
+
 // ---------------- Database操作封装 ----------------
 
 func (m *DBManager) SwitchDB(name string) {
@@ -95,6 +244,16 @@ func (m *DBManager) ListDBs() ([]string, error) {
 	return m.Ctx.DBList()
 }
 
+// SetFlushPolicy 设置当前数据库的落盘策略，见 services.DBContext.SetFlushPolicy
+func (m *DBManager) SetFlushPolicy(policy FlushPolicy) error {
+	return m.Ctx.SetFlushPolicy(policy)
+}
+
+// Flush 立即把当前数据库中延迟落盘的写入写入集合文件
+func (m *DBManager) Flush() error {
+	return m.Ctx.Flush()
+}
+
 // ---------------- Field操作封装 ----------------
 
 func (m *DBManager) SetUniqueField(field string) error {
@@ -105,30 +264,50 @@ func (m *DBManager) UnSetUniqueField(field string) error {
 	return m.Ctx.UnSetUniqueField(m.Ctx.CurrentCollection, field)
 }
 
-func (m *DBManager) SetUniqueFields(fields []string) error {
-	return m.Ctx.SetUniqueFields(m.Ctx.CurrentCollection, fields)
+func (m *DBManager) SetUniqueFields(fields []string, opts ...services.UniqueOptions) error {
+	return m.Ctx.SetUniqueFields(m.Ctx.CurrentCollection, fields, opts...)
 }
 
-func (m *DBManager) UnSetUniqueFields(fields []string) error {
-	return m.Ctx.UnSetUniqueFields(m.Ctx.CurrentCollection, fields)
+func (m *DBManager) UnSetUniqueFields(fields []string, opts ...services.UniqueOptions) error {
+	return m.Ctx.UnSetUniqueFields(m.Ctx.CurrentCollection, fields, opts...)
 }
 
-func (m *DBManager) CreateIndex(field string) error {
-	return m.Ctx.CreateIndex(m.Ctx.CurrentCollection, field)
+func (m *DBManager) CreateIndex(field string, opts ...services.IndexOptions) error {
+	return m.Ctx.CreateIndex(m.Ctx.CurrentCollection, field, opts...)
 }
 
 func (m *DBManager) DropIndex(field string) error {
 	return m.Ctx.DropIndex(m.Ctx.CurrentCollection, field)
 }
 
-func (m *DBManager) CreateIndexes(fields []string) error {
-	return m.Ctx.CreateIndexes(m.Ctx.CurrentCollection, fields)
+func (m *DBManager) CreateIndexes(fields []string, opts ...services.IndexOptions) error {
+	return m.Ctx.CreateIndexes(m.Ctx.CurrentCollection, fields, opts...)
 }
 
 func (m *DBManager) DropIndexes(fields []string) error {
 	return m.Ctx.DropIndexes(m.Ctx.CurrentCollection, fields)
 }
 
+func (m *DBManager) CreateCompoundIndex(keys []services.IndexKey, opts ...services.IndexOptions) error {
+	return m.Ctx.CreateCompoundIndex(m.Ctx.CurrentCollection, keys, opts...)
+}
+
+func (m *DBManager) ListIndexes() ([]services.IndexInfo, error) {
+	return m.Ctx.ListIndexes(m.Ctx.CurrentCollection)
+}
+
+func (m *DBManager) RebuildIndex(name string) error {
+	return m.Ctx.RebuildIndex(m.Ctx.CurrentCollection, name)
+}
+
+func (m *DBManager) VerifyIndexes(fix bool) ([]services.IndexReport, error) {
+	return m.Ctx.VerifyIndexes(m.Ctx.CurrentCollection, fix)
+}
+
+func (m *DBManager) SetVersioning(enabled bool) error {
+	return m.Ctx.SetVersioning(m.Ctx.CurrentCollection, enabled)
+}
+
 // ParseJSON 将字符串解析为 map[string]interface{}
 func ParseJSON(input string) (map[string]interface{}, error) {
 	if input == "" {
@@ -3,47 +3,145 @@ package config
 import (
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
 )
 
-func GetErrorFilePath() string {
-	return filepath.Join(GetRootDir(), ".errors")
+// RootEnv 指定默认数据根目录的环境变量
+const RootEnv = "JSONDB_ROOT"
+
+// defaultDirName 未指定根目录时，在当前工作目录下使用的目录名
+const defaultDirName = "JsonDataBase"
+
+const (
+	DefaultFileMode         os.FileMode = 0644             // 默认数据文件权限
+	DefaultDirMode          os.FileMode = 0755             // 默认目录权限
+	DefaultTTLInterval                  = time.Minute      // 默认 TTL 过期清理间隔
+	DefaultLockTimeout                  = 10 * time.Second // 默认等待其他进程释放文件锁的时间
+	DefaultCompactThreshold             = 1000             // 默认自动压缩日志格式集合的失效记录数
+	DefaultCacheSize                    = 64 << 20         // 默认集合缓存上限（字节，按集合文件大小估算）
+	DefaultFlushDelay                   = time.Second      // 只设置 FlushPolicy.Writes 时未落盘写入的最长等待时间
+)
+
+// FlushPolicy 集合写入落盘到集合文件的时机，零值表示每次写入立即落盘
+//
+// 延迟落盘时写入仍先 fsync 到 WAL，异常退出后由 Recover 重放，不会丢失已提交的写入；
+// 未落盘期间本进程持有集合的文件锁，其他进程访问该集合时等待到落盘为止。
+// Interval 与 Writes 同时设置时先满足者触发落盘；只设置 Writes 时未满的写入最多等待 DefaultFlushDelay 落盘，
+// 否则写入停止后其他进程（包括打开同一根目录时的 Recover）会一直等待到超时。
+type FlushPolicy struct {
+	Interval time.Duration // 大于 0 时，集合出现未落盘的写入后最多等待 Interval 落盘
+	Writes   int           // 大于 0 时，集合累计 Writes 次未落盘的写入后立即落盘
 }
-func GetConfigFilePath() string {
-	return filepath.Join(GetRootDir(), ".config")
+
+// Immediate 是否每次写入立即落盘
+func (p FlushPolicy) Immediate() bool {
+	return p.Interval <= 0 && p.Writes <= 0
 }
 
-func GetRootDir() string {
-	// 获取可执行文件绝对路径
-	exePath, err := os.Executable()
-	if err != nil {
-		panic(err)
+// Delay 集合出现未落盘的写入后最多等待多久落盘，立即落盘时为 0
+func (p FlushPolicy) Delay() time.Duration {
+	switch {
+	case p.Interval > 0:
+		return p.Interval
+	case p.Writes > 0:
+		return DefaultFlushDelay
 	}
+	return 0
+}
 
-	exeDir := filepath.Dir(exePath)
-	//fmt.Println("程序目录:", exeDir)
+// Options 数据根目录、文件权限及后台任务配置
+//
+// 零值字段使用默认值：RootDir 依次取 JSONDB_ROOT 环境变量、<工作目录>/JsonDataBase。
+// 同一进程中可以用不同的 RootDir 打开多个相互独立的数据根目录。
+type Options struct {
+	RootDir  string      // 数据根目录
+	FileMode os.FileMode // 集合、索引、配置等数据文件的权限
+	DirMode  os.FileMode // 数据库、索引等目录的权限
 
-	var baseDir string
-	lowerDir := strings.ToLower(exeDir)
+	// TTLInterval TTL 索引过期清理的间隔，默认 DefaultTTLInterval；小于 0 时不启动后台清理
+	TTLInterval time.Duration
 
-	if strings.Contains(lowerDir, "tmp") || strings.Contains(lowerDir, "goland") {
-		// 在 Goland 临时目录 → 使用当前工作目录
-		wd, err := os.Getwd()
-		if err != nil {
-			panic(err)
-		}
-		baseDir = wd
-		//fmt.Println("程序在临时目录运行，使用工作目录:", baseDir)
-	} else {
-		baseDir = exeDir
+	// LockTimeout 数据根目录被其他进程锁定时的最长等待时间，默认 DefaultLockTimeout；小于 0 时不等待
+	LockTimeout time.Duration
+
+	// CompactThreshold 日志格式集合自动压缩的失效记录数下限，默认 DefaultCompactThreshold；
+	// 失效记录同时不少于有效文档数时才压缩，小于 0 时只手动压缩
+	CompactThreshold int
+
+	// CacheSize 内存中缓存的集合文件总大小上限（字节），默认 DefaultCacheSize；超出时淘汰最久未使用的集合，
+	// 小于 0 时不缓存。同一根目录在进程内共用一份缓存，大小取第一次打开时的配置
+	CacheSize int64
+
+	// Flush 各数据库默认的落盘策略，零值为每次写入立即落盘；单个数据库可用 DBContext.SetFlushPolicy 修改
+	Flush FlushPolicy
+}
+
+// DefaultOptions 返回全部使用默认值的配置
+func DefaultOptions() Options {
+	return Options{}.Normalize()
+}
+
+// Normalize 填充默认值并把 RootDir 转为绝对路径，不会创建目录
+func (o Options) Normalize() Options {
+	if o.RootDir == "" {
+		o.RootDir = DefaultRootDir()
+	}
+	if abs, err := filepath.Abs(o.RootDir); err == nil {
+		o.RootDir = abs
+	}
+	if o.FileMode == 0 {
+		o.FileMode = DefaultFileMode
 	}
+	if o.DirMode == 0 {
+		o.DirMode = DefaultDirMode
+	}
+	if o.TTLInterval == 0 {
+		o.TTLInterval = DefaultTTLInterval
+	}
+	if o.LockTimeout == 0 {
+		o.LockTimeout = DefaultLockTimeout
+	}
+	if o.CompactThreshold == 0 {
+		o.CompactThreshold = DefaultCompactThreshold
+	}
+	if o.CacheSize == 0 {
+		o.CacheSize = DefaultCacheSize
+	}
+	return o
+}
 
-	// 拼接 JsonDataBase
-	dir := filepath.Join(baseDir, "JsonDataBase")
+// ErrorFilePath 错误日志文件路径
+func (o Options) ErrorFilePath() string {
+	return filepath.Join(o.RootDir, ".errors")
+}
 
-	// 确保目录存在
-	if err = os.MkdirAll(dir, 0777); err != nil {
-		panic(err)
+// ConfigFilePath 元数据目录文件路径
+func (o Options) ConfigFilePath() string {
+	return filepath.Join(o.RootDir, ".config")
+}
+
+// DefaultRootDir 默认数据根目录
+//
+// 优先使用环境变量 JSONDB_ROOT；未设置时使用 <当前工作目录>/JsonDataBase。
+func DefaultRootDir() string {
+	if root := os.Getenv(RootEnv); root != "" {
+		return root
 	}
-	return dir
+	wd, err := os.Getwd()
+	if err != nil {
+		return defaultDirName
+	}
+	return filepath.Join(wd, defaultDirName)
+}
+
+func GetErrorFilePath() string {
+	return DefaultOptions().ErrorFilePath()
+}
+func GetConfigFilePath() string {
+	return DefaultOptions().ConfigFilePath()
+}
+
+// GetRootDir 默认数据根目录的绝对路径，见 DefaultRootDir
+func GetRootDir() string {
+	return DefaultOptions().RootDir
 }
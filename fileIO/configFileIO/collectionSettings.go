@@ -1,45 +1,117 @@
 package configFileIO
 
+import (
+	"errors"
+	UtilsTime "github.com/StephenChristianW/JsonDB/utils/time"
+)
+
 // ==================== collection 设置对外接口 ====================
 
 // SetUniqueField 设置 UniqueField
-func SetUniqueField(dbName, collectionName, uniqueField string) error {
-	return updateFieldMap(dbName, collectionName, []string{uniqueField}, "UniqueField", true)
+func (c *Catalog) SetUniqueField(dbName, collectionName, uniqueField string) error {
+	return c.updateFieldMap(dbName, collectionName, []string{uniqueField}, "UniqueField", true)
 }
 
 // UnSetUniqueField 取消 UniqueField
-func UnSetUniqueField(dbName, collectionName, uniqueField string) error {
-	return updateFieldMap(dbName, collectionName, []string{uniqueField}, "UniqueField", false)
+func (c *Catalog) UnSetUniqueField(dbName, collectionName, uniqueField string) error {
+	return c.updateFieldMap(dbName, collectionName, []string{uniqueField}, "UniqueField", false)
+}
+
+// CreateIndexConfig 设置 Index，同名索引已存在时返回错误
+func (c *Catalog) CreateIndexConfig(dbName, collectionName, name string, def IndexDefinition) error {
+	return c.updateCollection(dbName, collectionName, func(col *collectionConfig) error {
+		if col.Settings.Index == nil {
+			col.Settings.Index = make(map[string]IndexDefinition)
+		}
+		if _, exists := col.Settings.Index[name]; exists {
+			return errors.New("Index 已存在: " + name)
+		}
+		if def.CreateAt == "" {
+			def.CreateAt = UtilsTime.TimeNow()
+		}
+		col.Settings.Index[name] = def
+		return nil
+	})
+}
+
+// DropIndexConfig 取消 Index，索引不存在时返回错误
+func (c *Catalog) DropIndexConfig(dbName, collectionName, name string) error {
+	return c.updateCollection(dbName, collectionName, func(col *collectionConfig) error {
+		if _, exists := col.Settings.Index[name]; !exists {
+			return errors.New("Index 不存在: " + name)
+		}
+		delete(col.Settings.Index, name)
+		return nil
+	})
 }
 
-// CreateIndexConfig 设置 Index
-func CreateIndexConfig(dbName, collectionName, index string) error {
-	return updateFieldMap(dbName, collectionName, []string{index}, "Index", true)
+// GetIndexes 获取集合的全部索引定义，Fields 为空的旧配置补全为索引名
+func (c *Catalog) GetIndexes(dbName, collectionName string) (map[string]IndexDefinition, error) {
+	col, err := c.getCollectionConfig(dbName, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make(map[string]IndexDefinition, len(col.Settings.Index))
+	for name, def := range col.Settings.Index {
+		if len(def.Fields) == 0 {
+			def.Fields = []string{name}
+		}
+		indexes[name] = def
+	}
+	return indexes, nil
 }
 
-// DropIndexConfig 取消 Index
-func DropIndexConfig(dbName, collectionName, index string) error {
-	return updateFieldMap(dbName, collectionName, []string{index}, "Index", false)
+// SetVersioned 设置集合是否维护文档版本
+func (c *Catalog) SetVersioned(dbName, collectionName string, versioned bool) error {
+	return c.updateCollection(dbName, collectionName, func(col *collectionConfig) error {
+		col.Settings.Versioned = versioned
+		return nil
+	})
+}
+
+// GetVersioned 集合是否维护文档版本
+func (c *Catalog) GetVersioned(dbName, collectionName string) (bool, error) {
+	col, err := c.getCollectionConfig(dbName, collectionName)
+	if err != nil {
+		return false, err
+	}
+	return col.Settings.Versioned, nil
 }
 
 // ==================== collection 设置批量操作接口 ====================
 
 // SetUniqueFields 批量设置集合的唯一字段
-func SetUniqueFields(dbName, collectionName string, fields []string) error {
-	return updateFieldMap(dbName, collectionName, fields, "UniqueField", true)
+func (c *Catalog) SetUniqueFields(dbName, collectionName string, fields []string) error {
+	return c.updateFieldMap(dbName, collectionName, fields, "UniqueField", true)
 }
 
-// UnSetUniqueFields 批量取消集合的唯一字段
-func UnSetUniqueFields(dbName, collectionName string, fields []string) error {
-	return updateFieldMap(dbName, collectionName, fields, "UniqueField", false)
+// SetUniqueConstraints 批量设置集合的唯一约束，已存在的同名约束按新定义覆盖
+func (c *Catalog) SetUniqueConstraints(dbName, collectionName string, defs map[string]UniqueDefinition) error {
+	return c.updateCollection(dbName, collectionName, func(col *collectionConfig) error {
+		if col.Settings.UniqueField == nil {
+			col.Settings.UniqueField = make(map[string]UniqueDefinition)
+		}
+		for name, def := range defs {
+			col.Settings.UniqueField[name] = def
+		}
+		return nil
+	})
 }
 
-// CreateIndexes 批量创建索引
-func CreateIndexes(dbName, collectionName string, indexes []string) error {
-	return updateFieldMap(dbName, collectionName, indexes, "Index", true)
+// GetUniqueConstraints 获取集合的全部唯一约束定义
+func (c *Catalog) GetUniqueConstraints(dbName, collectionName string) (map[string]UniqueDefinition, error) {
+	col, err := c.getCollectionConfig(dbName, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	defs := make(map[string]UniqueDefinition, len(col.Settings.UniqueField))
+	for name, def := range col.Settings.UniqueField {
+		defs[name] = def
+	}
+	return defs, nil
 }
 
-// DropIndexes 批量删除索引
-func DropIndexes(dbName, collectionName string, indexes []string) error {
-	return updateFieldMap(dbName, collectionName, indexes, "Index", false)
+// UnSetUniqueFields 批量取消集合的唯一字段
+func (c *Catalog) UnSetUniqueFields(dbName, collectionName string, fields []string) error {
+	return c.updateFieldMap(dbName, collectionName, fields, "UniqueField", false)
 }
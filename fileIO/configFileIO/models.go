@@ -1,6 +1,7 @@
 package configFileIO
 
 import (
+	"github.com/StephenChristianW/JsonDB/fileIO"
 	"sync"
 )
 
@@ -10,7 +11,31 @@ const (
 	dbIOError               = "JsonDB/fileIO/configFileIO/dbIO.go"
 )
 
-var configMu sync.RWMutex // 配置文件读写锁，保证并发安全
+var configMu sync.RWMutex // 配置文件读写锁：读取持有读锁，读取-修改-保存整个过程持有写锁，见 updateConfig
+
+// ==================== 存储后端 ====================
+
+// CatalogStore 配置目录（.config）的读写后端，由存储层实现
+type CatalogStore interface {
+	ReadCatalog() ([]byte, error) // 读取配置内容，尚未创建时返回 nil, nil
+	WriteCatalog(data []byte) error
+}
+
+// CatalogLocker 可选，由支持跨进程文件锁的存储后端实现：读取-修改-保存期间持有排他锁，
+// 使多个进程修改配置时不会互相覆盖
+type CatalogLocker interface {
+	LockCatalog() (*fileIO.FileLock, error)
+}
+
+// Catalog 数据库与集合的元数据目录
+type Catalog struct {
+	store CatalogStore
+}
+
+// NewCatalog 基于指定存储后端创建元数据目录
+func NewCatalog(store CatalogStore) *Catalog {
+	return &Catalog{store: store}
+}
 
 // ==================== 配置结构体 ====================
 
@@ -35,8 +60,32 @@ type collectionConfig struct {
 	DocsCount int                `json:"fields_count"`
 }
 
-// collectionSettings 集合的自定义约束，包括唯一字段和索引
+// collectionSettings 集合的自定义约束，包括唯一字段、索引和文档版本
 type collectionSettings struct {
-	UniqueField map[string]struct{} `json:"unique_field"`
-	Index       map[string]struct{} `json:"index"`
+	UniqueField map[string]UniqueDefinition `json:"unique_field"`
+	Index       map[string]IndexDefinition  `json:"index"`
+	Versioned   bool                        `json:"versioned,omitempty"` // 每篇文档维护 _version，每次写入加 1
+}
+
+// IndexDefinition 索引定义，索引数据本身由存储层单独保存
+type IndexDefinition struct {
+	Fields   []string `json:"fields,omitempty"`    // 索引字段（支持点号路径），为空时即索引名；多个字段为复合索引
+	Orders   []int    `json:"orders,omitempty"`    // 与 Fields 一一对应的方向：1 升序，-1 降序，缺省为升序
+	Kind     string   `json:"kind,omitempty"`      // 索引类型：hash（默认，等值查询）/ ordered（有序，范围查询与排序）
+	CreateAt string   `json:"create_at,omitempty"` // 创建时间
+
+	Sparse        bool                   `json:"sparse,omitempty"`         // 跳过缺少索引字段的文档（复合索引为全部字段都缺少）
+	PartialFilter map[string]interface{} `json:"partial_filter,omitempty"` // 只索引满足该过滤条件的文档，语法与查询过滤条件相同
+
+	// ExpireAfterSeconds 非空时为 TTL 索引：字段时间加上该秒数早于当前时间的文档会被后台删除，
+	// 为 0 时字段本身即过期时间（expireAt）
+	ExpireAfterSeconds *int64 `json:"expire_after_seconds,omitempty"`
+}
+
+// UniqueDefinition 唯一约束定义，约束名为字段名或 "email+org_id" 形式的复合字段
+//
+// 设置了 Sparse 或 PartialFilter 时，约束只作用于被索引的文档。
+type UniqueDefinition struct {
+	Sparse        bool                   `json:"sparse,omitempty"`
+	PartialFilter map[string]interface{} `json:"partial_filter,omitempty"`
 }
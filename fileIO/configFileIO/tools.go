@@ -2,11 +2,7 @@ package configFileIO
 
 import (
 	"encoding/json"
-	"github.com/StephenChristianW/JsonDB/config"
 	"github.com/StephenChristianW/JsonDB/fileIO"
-	UtilsFile "github.com/StephenChristianW/JsonDB/utils/file"
-	"os"
-	"path/filepath"
 )
 
 // ==================== 工具函数 ====================
@@ -23,88 +19,89 @@ func FileErrorCollectionSettingsIO(msg string, funcName string) {
 	fileIO.WriteErrorInfo(msg, collectionSettingsError, funcName)
 }
 
-// docCount 返回指定数据库和集合的文档数量
-func docCount(dbName, collectionName string) (int, error) {
-	colPath := filepath.Join(config.GetRootDir(), dbName, collectionName+".json")
-	var documents map[string]interface{}
-	if err := fileIO.ReadJsonFile(colPath, &documents); err != nil {
-		return 0, err
-	}
-	if documents == nil {
-		return 0, nil
-	}
-	return len(documents), nil
-} // ==================== 配置文件操作 ====================
+// ==================== 配置文件操作 ====================
 
-// initConfig 初始化配置文件路径和默认内容，返回配置文件路径
-func initConfig() (string, error) {
-	rootDir := config.GetRootDir()
+// getConfig 读取并解析配置，尚未创建时返回空配置
+func (c *Catalog) getConfig() (*configuration, error) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	// 配置总是整体原子替换，读取时不需要文件锁
+	return c.readConfig()
+}
 
-	// 确保根目录存在
-	if !UtilsFile.IsPathExist(rootDir) {
-		if err := os.MkdirAll(rootDir, 0755); err != nil {
-			return "", err
-		}
+// updateConfig 在配置写锁内读取配置、调用 fn 修改并保存，fn 返回错误时不保存
+//
+// 读取与保存在同一把写锁内完成，并发修改不同集合的配置时不会互相覆盖。
+func (c *Catalog) updateConfig(fn func(conf *configuration) error) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+	unlock, err := c.lockFile()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	// 确保配置文件存在，若不存在则创建默认空配置
-	configPath := config.GetConfigFilePath()
-	if !UtilsFile.IsPathExist(configPath) {
-		err := os.WriteFile(configPath, []byte(`{"databases":{}}`), 0644)
-		if err != nil {
-			return "", err
-		}
+	conf, err := c.readConfig()
+	if err != nil {
+		return err
 	}
-	return configPath, nil
+	if err := fn(conf); err != nil {
+		return err
+	}
+	return c.saveConfig(*conf)
 }
 
-// getConfig 读取并解析配置文件，如果没有配置文件则初始化
-func getConfig() *configuration {
-	configPath, err := initConfig()
-	configMu.RLock()
-	defer configMu.RUnlock()
-
+// lockFile 存储后端实现 CatalogLocker 时获得配置的排他文件锁，返回释放函数
+func (c *Catalog) lockFile() (func(), error) {
+	locker, ok := c.store.(CatalogLocker)
+	if !ok {
+		return func() {}, nil
+	}
+	l, err := locker.LockCatalog()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return func() { _ = l.Unlock() }, nil
+}
 
-	fileObj, err := os.ReadFile(configPath)
+// readConfig 读取并解析配置，调用方需持有 configMu
+func (c *Catalog) readConfig() (*configuration, error) {
+	fileObj, err := c.store.ReadCatalog()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var conf configuration
-	if err := json.Unmarshal(fileObj, &conf); err != nil {
-		panic(err)
+	if len(fileObj) > 0 {
+		if err := json.Unmarshal(fileObj, &conf); err != nil {
+			return nil, err
+		}
 	}
 
 	if conf.Databases == nil {
 		conf.Databases = make(map[string]dbConfig)
 	}
 
-	return &conf
+	return &conf, nil
 }
 
-// saveConfig 保存配置到文件
-func saveConfig(conf configuration) error {
-	configMu.RLock()
-	defer configMu.RUnlock()
-	configPath, err := initConfig()
-	if err != nil {
-		return err
-	}
-
+// saveConfig 保存配置，调用方需持有 configMu 写锁
+func (c *Catalog) saveConfig(conf configuration) error {
 	jsonObj, err := json.MarshalIndent(conf, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(configPath, jsonObj, 0644)
+	return c.store.WriteCatalog(jsonObj)
 }
 
-func GetUniqueFields(dbName string, collectionName string) ([]string, error) {
+// GetUniqueFields 获取指定集合的唯一字段
+func (c *Catalog) GetUniqueFields(dbName string, collectionName string) ([]string, error) {
 	// 读取当前配置
-	conf := getConfig()
+	conf, err := c.getConfig()
+	if err != nil {
+		return []string{}, err
+	}
 
 	// 获取指定数据库对象
 	db, err := getDB(conf, dbName)
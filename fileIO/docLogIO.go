@@ -0,0 +1,285 @@
+package fileIO
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ==================== 文档日志（JSON Lines 集合格式） ====================
+//
+// 日志格式的集合保存为 <collection>.jsonl，一行一条记录：
+//
+//	{"op":"ins","id":"<_id>","doc":{...}}
+//	{"op":"upd","id":"<_id>","doc":{...}}
+//	{"op":"del","id":"<_id>"}
+//
+// 写入只在文件末尾追加，代价与变更的文档数成正比，与集合大小无关。同一 _id 以最后一条记录为准，
+// 被覆盖或删除的记录成为失效记录，由压缩（Rewrite）清理。
+//
+// 内存中维护 _id -> 最新记录偏移 的映射：首次访问时扫描整个文件，此后只扫描其他进程追加的部分；
+// 文件被替换（其他进程压缩）或变短时重新扫描。读取时只解析仍然有效的记录。
+// 调用方需用集合锁保证同一时刻只有一个写入者。
+
+const (
+	DocLogInsert = "ins" // 插入文档
+	DocLogUpdate = "upd" // 覆盖已有文档
+	DocLogDelete = "del" // 删除文档
+)
+
+// DocLogRecord 文档日志中的一条记录
+type DocLogRecord struct {
+	Op  string                 `json:"op"`
+	ID  string                 `json:"id"`
+	Doc map[string]interface{} `json:"doc,omitempty"`
+}
+
+// docLogHeader 扫描时只解析操作与 _id
+type docLogHeader struct {
+	Op string `json:"op"`
+	ID string `json:"id"`
+}
+
+// DocLog 单个日志格式集合文件的内存状态
+type DocLog struct {
+	mu      sync.Mutex
+	path    string
+	perm    os.FileMode
+	info    os.FileInfo      // 已扫描的文件，用于发现文件被替换
+	size    int64            // 已扫描部分的长度，即最后一条完整记录的结尾
+	offsets map[string]int64 // _id -> 该文档最新一条记录的偏移
+	records int              // 已扫描的记录数，含失效记录
+}
+
+var (
+	docLogMu  sync.Mutex
+	docLogMap = make(map[string]*DocLog)
+)
+
+// GetDocLog 获取日志文件对应的 DocLog，同一路径在进程内共享同一个实例
+//
+// perm 为日志文件的创建权限
+func GetDocLog(path string, perm os.FileMode) *DocLog {
+	docLogMu.Lock()
+	defer docLogMu.Unlock()
+	l, ok := docLogMap[path]
+	if !ok {
+		l = &DocLog{path: path, perm: perm, offsets: make(map[string]int64)}
+		docLogMap[path] = l
+	}
+	return l
+}
+
+// Load 读取全部有效文档
+func (l *DocLog) Load() (map[string]map[string]interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.refresh(); err != nil {
+		return nil, err
+	}
+	docs := make(map[string]map[string]interface{}, len(l.offsets))
+	if len(l.offsets) == 0 {
+		return docs, nil
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return nil, err
+	}
+	for id, off := range l.offsets {
+		line := data[off:]
+		if end := bytes.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end]
+		}
+		var rec DocLogRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, err
+		}
+		docs[id] = rec.Doc
+	}
+	return docs, nil
+}
+
+// Append 追加一批变更并 fsync
+// - ops: 文档变更，Op 为 WALOpPut 或 WALOpDelete，Collection 字段不使用
+//
+// 写入的文档在日志中记为 ins 或 upd（已有该 _id 时）。写入失败时截断本次追加的内容，不留下部分记录。
+func (l *DocLog) Append(ops []WALOp) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, err := l.refresh()
+	if err != nil {
+		return err
+	}
+	// 上次崩溃留下的不完整尾部
+	if info != nil && info.Size() > l.size {
+		if err := os.Truncate(l.path, l.size); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	offsets := make(map[string]int64, len(ops))
+	exists := func(id string) bool {
+		if off, ok := offsets[id]; ok {
+			return off >= 0
+		}
+		_, ok := l.offsets[id]
+		return ok
+	}
+	for _, op := range ops {
+		rec := DocLogRecord{Op: DocLogDelete, ID: op.ID}
+		if op.Op == WALOpPut {
+			rec.Op, rec.Doc = DocLogInsert, op.Doc
+			if exists(op.ID) {
+				rec.Op = DocLogUpdate
+			}
+		}
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if rec.Op == DocLogDelete {
+			offsets[op.ID] = -1
+		} else {
+			offsets[op.ID] = l.size + int64(buf.Len())
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, l.perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		_ = f.Truncate(l.size)
+		_ = f.Close()
+		return err
+	}
+	if l.info == nil {
+		if l.info, err = f.Stat(); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	for id, off := range offsets {
+		if off < 0 {
+			delete(l.offsets, id)
+		} else {
+			l.offsets[id] = off
+		}
+	}
+	l.records += len(ops)
+	l.size += int64(buf.Len())
+	return nil
+}
+
+// Rewrite 用 docs 原子替换整个日志，每篇文档一条 ins 记录，即压缩
+func (l *DocLog) Rewrite(docs map[string]map[string]interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	offsets := make(map[string]int64, len(docs))
+	for _, id := range ids {
+		line, err := json.Marshal(DocLogRecord{Op: DocLogInsert, ID: id, Doc: docs[id]})
+		if err != nil {
+			return err
+		}
+		offsets[id] = int64(buf.Len())
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := WriteFileAtomic(l.path, buf.Bytes(), l.perm); err != nil {
+		return err
+	}
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+	l.info, l.size, l.offsets, l.records = info, int64(buf.Len()), offsets, len(ids)
+	return nil
+}
+
+// Counts 有效文档数与日志中的记录总数，两者之差为失效记录数
+func (l *DocLog) Counts() (live, records int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.refresh(); err != nil {
+		return 0, 0, err
+	}
+	return len(l.offsets), l.records, nil
+}
+
+// refresh 使内存中的偏移与文件一致，返回文件信息；文件不存在时返回 nil, nil
+func (l *DocLog) refresh() (os.FileInfo, error) {
+	info, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		l.reset(nil)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l.info == nil || !os.SameFile(l.info, info) || info.Size() < l.size {
+		l.reset(info)
+	}
+	if info.Size() > l.size {
+		f, err := os.Open(l.path)
+		if err != nil {
+			return nil, err
+		}
+		tail := make([]byte, info.Size()-l.size)
+		_, err = f.ReadAt(tail, l.size)
+		_ = f.Close()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		l.scan(tail)
+	}
+	return info, nil
+}
+
+// scan 解析 l.size 之后的内容，遇到不完整或无法解析的行即停止
+func (l *DocLog) scan(data []byte) {
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return
+		}
+		var h docLogHeader
+		if err := json.Unmarshal(data[:end], &h); err != nil || h.ID == "" {
+			return
+		}
+		if h.Op == DocLogDelete {
+			delete(l.offsets, h.ID)
+		} else {
+			l.offsets[h.ID] = l.size
+		}
+		l.records++
+		l.size += int64(end + 1)
+		data = data[end+1:]
+	}
+}
+
+func (l *DocLog) reset(info os.FileInfo) {
+	l.info, l.size, l.offsets, l.records = info, 0, make(map[string]int64), 0
+}
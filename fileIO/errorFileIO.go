@@ -27,11 +27,16 @@ func initErrorInfo(errMsg string, funcName string, errorLoc string) errorInfo {
 	return errInfo
 }
 
+// WriteErrorInfo 记录错误信息到默认数据根目录的 .errors 文件
 func WriteErrorInfo(errMsg string, funcName string, errorLoc string) {
+	WriteErrorInfoTo(config.GetErrorFilePath(), errMsg, funcName, errorLoc)
+}
+
+// WriteErrorInfoTo 记录错误信息到指定的错误日志文件
+func WriteErrorInfoTo(errorsPath string, errMsg string, funcName string, errorLoc string) {
 	errorMu.Lock()
 	defer errorMu.Unlock()
 	errInfo := initErrorInfo(errMsg, funcName, errorLoc)
-	errorsPath := config.GetErrorFilePath()
 	var jsonObj []errorInfo
 	if UtilsFile.IsPathExist(errorsPath) {
 		err := ReadJsonFile(errorsPath, &jsonObj)
@@ -0,0 +1,111 @@
+package fileIO
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== 跨进程文件锁 ====================
+//
+// 基于操作系统的建议锁（Linux 等类 Unix 系统上为 flock）：共享锁之间相容，用于读；
+// 排他锁与其他任何锁互斥，用于写。锁随文件描述符释放，进程退出时由操作系统自动释放。
+//
+// 获得锁后把当前进程号写入锁文件开头，等待超时时据此报告持有锁的进程。
+// 同一进程内的多个文件描述符之间同样互斥，调用方需先用进程内的锁排除冲突的持有者。
+// 不支持建议锁的平台上加锁总是成功，只有进程内的锁生效。
+
+// LockMode 文件锁模式
+type LockMode int
+
+const (
+	LockShared    LockMode = iota // 共享锁，可与其他共享锁同时持有
+	LockExclusive                 // 排他锁
+)
+
+// errWouldBlock 锁已被其他文件描述符以冲突的模式持有
+var errWouldBlock = errors.New("文件锁已被占用")
+
+// LockedError 在等待时间内没能获得文件锁
+type LockedError struct {
+	Path string // 锁文件路径
+	PID  int    // 最近一次获得该锁的进程，未知时为 0
+}
+
+func (e *LockedError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("数据库已被进程 %d 锁定: %s", e.PID, e.Path)
+	}
+	return "数据库已被其他进程锁定: " + e.Path
+}
+
+// FileLock 已获得的文件锁
+type FileLock struct {
+	f *os.File
+}
+
+// pidWidth 锁文件中进程号的固定宽度，多个共享锁持有者覆盖写入时不会留下残余字符
+const pidWidth = 10
+
+// LockFile 获得 path 上的文件锁，锁文件不存在时创建（所在目录需已存在）
+// - mode: LockShared 或 LockExclusive
+// - timeout: 锁被其他进程持有时的最长等待时间，小于等于 0 时不等待
+// - perm: 创建锁文件时的权限
+//
+// 超时返回 *LockedError。
+func LockFile(path string, mode LockMode, timeout time.Duration, perm os.FileMode) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	wait := time.Millisecond
+	for {
+		err = tryLock(f, mode)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errWouldBlock) {
+			_ = f.Close()
+			return nil, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			pid := lockHolder(f)
+			_ = f.Close()
+			return nil, &LockedError{Path: path, PID: pid}
+		}
+		time.Sleep(min(wait, remaining))
+		wait = min(wait*2, 10*time.Millisecond)
+	}
+
+	// 记录持有者，写入失败不影响加锁结果
+	_, _ = f.WriteAt([]byte(fmt.Sprintf("%-*d\n", pidWidth, os.Getpid())), 0)
+	return &FileLock{f: f}, nil
+}
+
+// Unlock 释放文件锁，锁文件保留供下次使用
+func (l *FileLock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	_ = unlock(l.f)
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// lockHolder 读取锁文件中记录的进程号，读取失败时为 0
+func lockHolder(f *os.File) int {
+	buf := make([]byte, pidWidth)
+	n, _ := f.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package fileIO
+
+import "os"
+
+// tryLock 当前平台不支持 flock，加锁总是成功，只有进程内的锁生效
+func tryLock(*os.File, LockMode) error {
+	return nil
+}
+
+func unlock(*os.File) error {
+	return nil
+}
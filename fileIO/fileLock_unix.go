@@ -0,0 +1,31 @@
+//go:build unix
+
+package fileIO
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock 以非阻塞方式对 f 加 flock，被占用时返回 errWouldBlock
+func tryLock(f *os.File, mode LockMode) error {
+	how := syscall.LOCK_SH
+	if mode == LockExclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return errWouldBlock
+		}
+		return err
+	}
+}
+
+func unlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
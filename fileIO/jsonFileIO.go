@@ -5,6 +5,7 @@ import (
 	"errors"
 	UtilsFile "github.com/StephenChristianW/JsonDB/utils/file"
 	"os"
+	"path/filepath"
 )
 
 func ReadJsonFile(filePath string, v interface{}) error {
@@ -27,12 +28,61 @@ func WriteJsonFile(filePath string, v interface{}) error {
 	if err != nil {
 		return err
 	}
-	// 不管文件是否存在，都写入
-	err = os.WriteFile(filePath, data, 0666)
-	return err
+	// 不管文件是否存在，都写入（原子替换，避免写到一半留下截断文件）
+	return WriteFileAtomic(filePath, data, 0666)
+}
+
+// WriteFileAtomic 原子写文件
+//
+// 先写入同目录下的临时文件并 fsync，再 rename 覆盖目标文件，最后 fsync 所在目录。
+// 任意时刻崩溃，目标文件要么是旧内容，要么是完整的新内容。
+func WriteFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filePath)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	// 出错时清理临时文件；rename 成功后该路径已不存在，Remove 无副作用
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir 尽力 fsync 目录，使 rename 持久化；部分平台不支持对目录 fsync，忽略错误
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
 }
 
 func CreateDirectory(directoryName string) error {
+	return CreateDirectoryMode(directoryName, 0755)
+}
+
+// CreateDirectoryMode 按指定权限创建目录（含父目录），已存在时直接返回
+func CreateDirectoryMode(directoryName string, perm os.FileMode) error {
 	if directoryName == "" {
 		return errors.New("目录错误")
 	}
@@ -43,7 +93,7 @@ func CreateDirectory(directoryName string) error {
 	}
 
 	// 创建目录
-	if err := os.MkdirAll(directoryName, 0755); err != nil {
+	if err := os.MkdirAll(directoryName, perm); err != nil {
 		return err
 	}
 	return nil
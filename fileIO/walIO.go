@@ -0,0 +1,329 @@
+package fileIO
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ==================== 预写日志 WAL ====================
+//
+// 每个数据库目录下有一个 .wal 文件，一行一条记录：
+//
+//	<crc32 十六进制> <记录 JSON>\n
+//
+// 写操作先把变更追加到 WAL 并 fsync，再原子写集合文件，最后做检查点。
+// 启动时重放 WAL 中残留的记录；记录内容是文档的最终状态，重复重放结果相同。
+//
+// 多个进程可以同时向同一个 WAL 追加记录（O_APPEND 单次写入）。有记录尚未检查点期间进程持有
+// .wal.lock 的共享锁；检查点时只有拿到排他锁（即任何进程都没有进行中的记录）、并且日志中也没有
+// 异常退出的进程留下的未完成记录时才清空日志，否则追加一条完成标记，重放时跳过已完成或已作废的记录。
+// 异常退出的进程留下的记录由下次 Recover 在持有排他锁时重放，见 LockExclusive。
+
+// WALFileName 数据库目录下的 WAL 文件名
+const WALFileName = ".wal"
+
+// walLockSuffix WAL 锁文件名后缀，见上方说明
+const walLockSuffix = ".lock"
+
+const (
+	WALOpPut    = "put" // 写入（插入或覆盖）文档
+	WALOpDelete = "del" // 删除文档
+)
+
+// WALOp 单个文档变更
+type WALOp struct {
+	Op         string                 `json:"op"`
+	Collection string                 `json:"collection"`
+	ID         string                 `json:"id"`
+	Doc        map[string]interface{} `json:"doc,omitempty"`
+}
+
+// WALRecord 一次写操作对应的一条日志记录，内部所有变更一起生效
+type WALRecord struct {
+	Seq    uint64  `json:"seq"`
+	Writer string  `json:"writer,omitempty"` // 写入记录的 WAL 实例，序号只在同一 Writer 内唯一
+	Ops    []WALOp `json:"ops,omitempty"`
+	Abort  uint64  `json:"abort,omitempty"` // 非 0 表示作废同一 Writer 中序号为 Abort 的记录
+	Done   uint64  `json:"done,omitempty"`  // 非 0 表示同一 Writer 中序号为 Done 的记录已落盘
+}
+
+// walKey 记录在日志中的唯一标识
+type walKey struct {
+	writer string
+	seq    uint64
+}
+
+// WAL 单个数据库的预写日志
+type WAL struct {
+	mu          sync.Mutex
+	path        string
+	perm        os.FileMode
+	lockTimeout time.Duration
+	writer      string
+	seq         uint64
+	pending     int       // 已写入日志但尚未检查点的记录数
+	active      *FileLock // pending 大于 0 期间持有的共享锁
+}
+
+var (
+	walMu  sync.Mutex
+	walMap = make(map[string]*WAL)
+)
+
+// GetWAL 获取数据库目录对应的 WAL，同一路径在进程内共享同一个实例
+//
+// perm 为日志文件的创建权限，lockTimeout 为等待其他进程清空日志的最长时间
+func GetWAL(dbDir string, perm os.FileMode, lockTimeout time.Duration) *WAL {
+	path := filepath.Join(dbDir, WALFileName)
+	walMu.Lock()
+	defer walMu.Unlock()
+	w, ok := walMap[path]
+	if !ok {
+		w = &WAL{
+			path:        path,
+			perm:        perm,
+			lockTimeout: lockTimeout,
+			writer:      fmt.Sprintf("%d-%x", os.Getpid(), time.Now().UnixNano()),
+		}
+		walMap[path] = w
+	}
+	return w
+}
+
+// Append 追加一条记录并 fsync，返回记录序号
+func (w *WAL) Append(ops []WALOp) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending == 0 {
+		l, err := LockFile(w.path+walLockSuffix, LockShared, w.lockTimeout, w.perm)
+		if err != nil {
+			return 0, err
+		}
+		w.active = l
+	}
+	w.seq++
+	if err := w.write(WALRecord{Seq: w.seq, Writer: w.writer, Ops: ops}, true); err != nil {
+		if w.pending == 0 {
+			w.releaseActive()
+		}
+		return 0, err
+	}
+	w.pending++
+	return w.seq, nil
+}
+
+// Abort 作废一条已追加的记录，用于集合文件写入失败时，避免重放出调用方已收到失败的变更
+func (w *WAL) Abort(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.finish(WALRecord{Writer: w.writer, Abort: seq}, true)
+}
+
+// Checkpoint 标记一条记录已落盘到集合文件；任何进程都没有待处理记录时清空日志
+func (w *WAL) Checkpoint(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// 完成标记丢失时只会重放一条已落盘的记录，结果相同，因此不需要 fsync
+	return w.finish(WALRecord{Writer: w.writer, Done: seq}, false)
+}
+
+// finish 结束一条记录：能清空日志时清空，否则追加 marker 使重放跳过该记录
+func (w *WAL) finish(marker WALRecord, sync bool) error {
+	if w.pending > 0 {
+		w.pending--
+	}
+	if w.pending == 0 {
+		w.releaseActive()
+		truncated, err := w.tryTruncate(marker)
+		if err != nil || truncated {
+			return err
+		}
+	}
+	w.seq++
+	marker.Seq = w.seq
+	return w.write(marker, sync)
+}
+
+// tryTruncate 其他进程都没有待处理记录、日志中除 marker 结束的记录外也没有未完成的记录时清空日志，返回是否已清空
+func (w *WAL) tryTruncate(marker WALRecord) (bool, error) {
+	if _, err := os.Stat(w.path); os.IsNotExist(err) {
+		return true, nil
+	}
+	l, err := LockFile(w.path+walLockSuffix, LockExclusive, 0, w.perm)
+	var locked *LockedError
+	if errors.As(err, &locked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = l.Unlock() }()
+	// 没有进程持有共享锁时仍未完成的记录来自异常退出的进程，保留给 Recover 重放
+	records, err := w.records()
+	if err != nil {
+		return false, err
+	}
+	for _, rec := range records {
+		if rec.Writer != marker.Writer || (rec.Seq != marker.Done && rec.Seq != marker.Abort) {
+			return false, nil
+		}
+	}
+	return true, w.truncate()
+}
+
+// releaseActive 释放 pending 期间持有的共享锁
+func (w *WAL) releaseActive() {
+	if w.active != nil {
+		_ = w.active.Unlock()
+		w.active = nil
+	}
+}
+
+func (w *WAL) write(rec WALRecord, sync bool) error {
+	line, err := encodeWALRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, w.perm)
+	if err != nil {
+		return err
+	}
+	// 整行一次写入，多个进程同时追加时各行不会交错
+	if _, err = f.Write(line); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if sync {
+		if err = f.Sync(); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// Reset 丢弃全部日志内容，用于重放完成后；调用方需保证没有其他进程在写入该数据库
+func (w *WAL) Reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = 0
+	w.releaseActive()
+	return w.truncate()
+}
+
+// LockExclusive 获取 WAL 的排他锁，等待其他进程的待处理记录全部检查点，最长等待 timeout
+//
+// 持有期间日志中未完成的记录都来自异常退出的进程，可以安全地重放后 Reset。
+// 调用方需保证本进程没有待处理记录（共享锁与排他锁即使在同一进程内也互斥）。
+func (w *WAL) LockExclusive(timeout time.Duration) (*FileLock, error) {
+	return LockFile(w.path+walLockSuffix, LockExclusive, timeout, w.perm)
+}
+
+// Records 按顺序读取日志中仍然有效的记录
+//
+// 遇到不完整或校验失败的行即停止（崩溃时未写完的尾部）；被作废或已标记完成的记录不会返回。
+func (w *WAL) Records() ([]WALRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.records()
+}
+
+// records 见 Records，调用方需持有 w.mu
+func (w *WAL) records() ([]WALRecord, error) {
+	f, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var records []WALRecord
+	finished := make(map[walKey]struct{})
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// 没有换行结尾的行视为未写完，丢弃
+			break
+		}
+		rec, ok := decodeWALRecord(line)
+		if !ok {
+			break
+		}
+		if rec.Seq > w.seq {
+			w.seq = rec.Seq
+		}
+		if rec.Abort != 0 {
+			finished[walKey{rec.Writer, rec.Abort}] = struct{}{}
+			continue
+		}
+		if rec.Done != 0 {
+			finished[walKey{rec.Writer, rec.Done}] = struct{}{}
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	valid := records[:0]
+	for _, rec := range records {
+		if _, ok := finished[walKey{rec.Writer, rec.Seq}]; !ok {
+			valid = append(valid, rec)
+		}
+	}
+	return valid, nil
+}
+
+func (w *WAL) truncate() error {
+	if _, err := os.Stat(w.path); os.IsNotExist(err) {
+		return nil
+	}
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_TRUNC, w.perm)
+	if err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func encodeWALRecord(rec WALRecord) ([]byte, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Appendf(nil, "%08x ", crc32.ChecksumIEEE(body))
+	line = append(line, body...)
+	return append(line, '\n'), nil
+}
+
+func decodeWALRecord(line []byte) (WALRecord, bool) {
+	var rec WALRecord
+	line = bytes.TrimRight(line, "\r\n")
+	sumPart, body, found := bytes.Cut(line, []byte{' '})
+	if !found {
+		return rec, false
+	}
+	want, err := strconv.ParseUint(string(sumPart), 16, 32)
+	if err != nil || crc32.ChecksumIEEE(body) != uint32(want) {
+		return rec, false
+	}
+	if err = json.Unmarshal(body, &rec); err != nil {
+		return rec, false
+	}
+	return rec, true
+}
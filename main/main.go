@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/StephenChristianW/JsonDB"
+	"github.com/StephenChristianW/JsonDB/services"
 	"github.com/fatih/color"
 	"os"
 	"os/exec"
@@ -13,6 +16,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -201,7 +205,7 @@ func collectionMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 		clearScreen()
 		printStatus(manager)
 		_, _ = ColorCyan.Println("---- 集合操作 ----")
-		_, _ = ColorCyan.Println("1. 列出集合\n2. 创建集合\n3. 删除集合\n4. 切换集合\n5. 索引管理\n0. 返回主菜单")
+		_, _ = ColorCyan.Println("1. 列出集合\n2. 创建集合\n3. 删除集合\n4. 切换集合\n5. 索引管理\n6. 转换集合格式（json / jsonl）\n7. 压缩集合日志\n0. 返回主菜单")
 		_, _ = ColorCyan.Print("请选择: ")
 		choice := readChoice(reader)
 
@@ -249,6 +253,23 @@ func collectionMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 			pause(reader)
 		case 5: // 索引管理二级菜单
 			indexMenu(manager, reader)
+		case 6:
+			format, _ := manager.CollectionFormat()
+			fmt.Printf("当前格式: %s，请输入目标格式（json / jsonl）: ", format)
+			target := services.CollectionFormat(strings.TrimSpace(readLine(reader)))
+			if err := manager.SetCollectionFormat(target); err != nil {
+				_, _ = ColorRed.Println("❌ 转换失败:", err.Error())
+			} else {
+				_, _ = ColorGreen.Println("✅ 集合格式:", target)
+			}
+			pause(reader)
+		case 7:
+			if err := manager.Compact(); err != nil {
+				_, _ = ColorRed.Println("❌ 压缩失败:", err.Error())
+			} else {
+				_, _ = ColorGreen.Println("✅ 压缩完成")
+			}
+			pause(reader)
 		default:
 			_, _ = ColorRed.Println("无效选项，请重新选择")
 			pause(reader)
@@ -262,15 +283,18 @@ func indexMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 		clearScreen()
 		printStatus(manager)
 		_, _ = ColorCyan.Println("---- 索引管理 ----")
-		_, _ = ColorCyan.Println("1. 创建唯一索引\n2. 删除唯一索引\n3. 创建普通索引\n4. 删除普通索引\n0. 返回上级菜单")
+		_, _ = ColorCyan.Println("1. 创建唯一索引\n2. 删除唯一索引\n3. 创建普通索引\n4. 删除普通索引\n5. 创建有序索引（范围查询/排序）\n6. 创建复合索引（字段:-1 表示降序）\n7. 创建复合唯一约束\n8. 删除复合唯一约束\n9. 创建 TTL 索引（过期自动删除）\n10. 查看索引信息\n11. 重建索引\n12. 校验索引\n0. 返回上级菜单")
 		_, _ = ColorCyan.Print("请选择: ")
 		choice := readChoice(reader)
 
-		fmt.Print("请输入字段名（多个用逗号分隔）: ")
-		input := readLine(reader)
-		fields := strings.Split(input, ",")
-		for i := range fields {
-			fields[i] = strings.TrimSpace(fields[i])
+		var fields []string
+		if choice >= 1 && choice <= 9 {
+			fmt.Print("请输入字段名（多个用逗号分隔）: ")
+			input := readLine(reader)
+			fields = strings.Split(input, ",")
+			for i := range fields {
+				fields[i] = strings.TrimSpace(fields[i])
+			}
 		}
 
 		switch choice {
@@ -300,6 +324,68 @@ func indexMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 			} else {
 				_, _ = ColorGreen.Println("✅ 普通索引删除成功:", fields)
 			}
+		case 5:
+			if err := manager.CreateIndexes(fields, services.IndexOptions{Kind: services.IndexOrdered}); err != nil {
+				_, _ = ColorRed.Println("❌ 创建有序索引失败:", err.Error())
+			} else {
+				_, _ = ColorGreen.Println("✅ 有序索引创建成功:", fields)
+			}
+		case 6:
+			keys := parseIndexKeys(fields)
+			if err := manager.CreateCompoundIndex(keys); err != nil {
+				_, _ = ColorRed.Println("❌ 创建复合索引失败:", err.Error())
+			} else {
+				_, _ = ColorGreen.Println("✅ 复合索引创建成功:", keys)
+			}
+		case 7:
+			if err := manager.SetUniqueFields(fields, services.UniqueOptions{Compound: true}); err != nil {
+				_, _ = ColorRed.Println("❌ 创建复合唯一约束失败:", err.Error())
+			} else {
+				_, _ = ColorGreen.Println("✅ 复合唯一约束创建成功:", fields)
+			}
+		case 8:
+			if err := manager.UnSetUniqueFields(fields, services.UniqueOptions{Compound: true}); err != nil {
+				_, _ = ColorRed.Println("❌ 删除复合唯一约束失败:", err.Error())
+			} else {
+				_, _ = ColorGreen.Println("✅ 复合唯一约束删除成功:", fields)
+			}
+		case 9:
+			fmt.Print("请输入过期秒数（0 表示字段值即过期时间）: ")
+			seconds, err := strconv.Atoi(readLine(reader))
+			if err != nil || seconds < 0 {
+				_, _ = ColorRed.Println("❌ 过期秒数无效")
+				break
+			}
+			opts := services.IndexOptions{TTL: true, ExpireAfter: time.Duration(seconds) * time.Second}
+			if err := manager.CreateIndexes(fields, opts); err != nil {
+				_, _ = ColorRed.Println("❌ 创建 TTL 索引失败:", err.Error())
+			} else {
+				_, _ = ColorGreen.Println("✅ TTL 索引创建成功:", fields)
+			}
+		case 10:
+			infos, err := manager.ListIndexes()
+			if err != nil {
+				_, _ = ColorRed.Println("❌ 获取索引信息失败:", err.Error())
+				break
+			}
+			printIndexes(infos)
+		case 11:
+			fmt.Print("请输入索引名或唯一约束名（留空重建全部）: ")
+			name := readLine(reader)
+			if err := manager.RebuildIndex(name); err != nil {
+				_, _ = ColorRed.Println("❌ 重建索引失败:", err.Error())
+			} else {
+				_, _ = ColorGreen.Println("✅ 索引重建成功")
+			}
+		case 12:
+			fmt.Print("发现不一致时是否重建？(y/N): ")
+			fix := strings.EqualFold(readLine(reader), "y")
+			reports, err := manager.VerifyIndexes(fix)
+			if err != nil {
+				_, _ = ColorRed.Println("❌ 校验索引失败:", err.Error())
+				break
+			}
+			printIndexReports(reports)
 		default:
 			_, _ = ColorRed.Println("无效选项，请重新选择")
 		}
@@ -307,6 +393,110 @@ func indexMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 	}
 }
 
+// pageSize 查询结果每页显示的文档数
+const pageSize = 20
+
+// printCursor 分页显示游标中的文档，每页之后询问是否继续
+func printCursor(cursor *services.Cursor, reader *bufio.Reader) {
+	defer func() { _ = cursor.Close() }()
+	ctx := context.Background()
+	shown := 0
+	for cursor.Next(ctx) {
+		jsonBytes, _ := json.MarshalIndent(cursor.Current(), "", "  ")
+		fmt.Println(string(jsonBytes))
+		shown++
+		if shown%pageSize == 0 {
+			_, _ = ColorCyan.Printf("已显示 %d 条，按 Enter 显示下一页，输入 q 结束: ", shown)
+			if strings.EqualFold(readLine(reader), "q") {
+				return
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		_, _ = ColorRed.Println("❌ 查询失败:", err.Error())
+		return
+	}
+	if shown == 0 {
+		fmt.Println("  （空）")
+	} else {
+		_, _ = ColorGreen.Printf("共 %d 条\n", shown)
+	}
+}
+
+// printIndexes 打印索引定义与统计信息
+func printIndexes(infos []services.IndexInfo) {
+	_, _ = ColorBlue.Println("==== 索引信息 ====")
+	if len(infos) == 0 {
+		fmt.Println("（空）")
+		return
+	}
+	for _, info := range infos {
+		kind := info.Definition.Kind
+		if info.Unique {
+			kind = "unique"
+		}
+		var extras []string
+		if info.Definition.Sparse {
+			extras = append(extras, "sparse")
+		}
+		if len(info.Definition.PartialFilter) > 0 {
+			extras = append(extras, fmt.Sprintf("partial=%v", info.Definition.PartialFilter))
+		}
+		if info.Definition.ExpireAfterSeconds != nil {
+			extras = append(extras, fmt.Sprintf("ttl=%ds", *info.Definition.ExpireAfterSeconds))
+		}
+		fmt.Printf("- %s [%s] 字段: %v 方向: %v %s\n", info.Name, kind, info.Definition.Fields, info.Definition.Orders, strings.Join(extras, " "))
+		fmt.Printf("  键: %d  文档: %d  大小: %d 字节  构建时间: %s\n", info.Keys, info.Entries, info.Size, info.BuiltAt)
+	}
+}
+
+// printIndexReports 打印索引校验结果
+func printIndexReports(reports []services.IndexReport) {
+	_, _ = ColorBlue.Println("==== 索引校验 ====")
+	if len(reports) == 0 {
+		fmt.Println("（空）")
+		return
+	}
+	for _, r := range reports {
+		if r.Healthy() {
+			_, _ = ColorGreen.Println("✅", r.Name, "一致")
+			continue
+		}
+		_, _ = ColorRed.Println("❌", r.Name, "不一致")
+		if r.Corrupt {
+			fmt.Println("  索引数据缺失或损坏")
+		}
+		if len(r.Missing) > 0 {
+			fmt.Println("  缺失文档:", r.Missing)
+		}
+		if len(r.Stale) > 0 {
+			fmt.Println("  多余或过期条目:", r.Stale)
+		}
+		if r.Duplicate != nil {
+			fmt.Println("  存在重复值:", r.Duplicate.Error())
+		}
+		if r.Fixed {
+			_, _ = ColorGreen.Println("  已重建")
+		}
+	}
+}
+
+// parseIndexKeys 解析复合索引字段，"created_at:-1" 表示降序，未指定方向时为升序
+func parseIndexKeys(fields []string) []services.IndexKey {
+	keys := make([]services.IndexKey, 0, len(fields))
+	for _, field := range fields {
+		key := services.IndexKey{Field: field, Order: 1}
+		if name, order, found := strings.Cut(field, ":"); found {
+			key.Field = strings.TrimSpace(name)
+			if strings.TrimSpace(order) == "-1" {
+				key.Order = -1
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 // -------------------- 文档菜单 --------------------
 func documentMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 	for {
@@ -347,17 +537,27 @@ func documentMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 				pause(reader)
 				continue
 			}
-			docs, err := manager.Find(filter, nil)
+			fmt.Print("请输入投影 (JSON 格式，如 {\"name\": 1}，留空返回全部字段): ")
+			var opts *services.FindOptions
+			if projStr := readLine(reader); projStr != "" {
+				projection, err := JsonDB.ParseJSON(projStr)
+				if err != nil {
+					_, _ = ColorRed.Println("❌ JSON 解析错误:", err.Error())
+					pause(reader)
+					continue
+				}
+				opts = &services.FindOptions{Projection: projection}
+			}
+			if opts == nil {
+				opts = &services.FindOptions{}
+			}
+			opts.BatchSize = pageSize
+			cursor, err := manager.FindCursor(filter, opts)
 			if err != nil {
 				_, _ = ColorRed.Println("❌ 查询失败:", err.Error())
 			} else {
 				_, _ = ColorBlue.Println("\n查询结果:")
-				if len(docs) == 0 {
-					fmt.Println("  （空）")
-				} else {
-					jsonBytes, _ := json.MarshalIndent(docs, "", "  ")
-					fmt.Println(string(jsonBytes))
-				}
+				printCursor(cursor, reader)
 			}
 			pause(reader)
 		case 3:
@@ -369,11 +569,11 @@ func documentMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 				pause(reader)
 				continue
 			}
-			count, err := manager.Delete(filter)
+			res, err := manager.Delete(filter)
 			if err != nil {
 				_, _ = ColorRed.Println("❌ 删除失败:", err.Error())
 			} else {
-				_, _ = ColorGreen.Printf("✅ 已删除 %d 条文档\n", count)
+				_, _ = ColorGreen.Printf("✅ 已删除 %d 条文档\n", res.DeletedCount)
 			}
 			pause(reader)
 		case 4:
@@ -393,11 +593,11 @@ func documentMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 				pause(reader)
 				continue
 			}
-			count, err := manager.Update(filter, update)
+			res, err := manager.UpdateMany(filter, update)
 			if err != nil {
 				_, _ = ColorRed.Println("❌ 更新失败:", err.Error())
 			} else {
-				_, _ = ColorGreen.Printf("✅ 已更新 %d 条文档\n", count)
+				_, _ = ColorGreen.Printf("✅ 匹配 %d 条文档，更新 %d 条\n", res.MatchedCount, res.ModifiedCount)
 			}
 			pause(reader)
 		default:
@@ -409,7 +609,15 @@ func documentMenu(manager *JsonDB.DBManager, reader *bufio.Reader) {
 
 // -------------------- 主函数 --------------------
 func main() {
-	manager := JsonDB.NewDBManager("", "")
+	rootDir := flag.String("root", "", "数据根目录（默认取环境变量 JSONDB_ROOT，未设置时为 ./JsonDataBase）")
+	flag.Parse()
+
+	manager, err := JsonDB.NewDBManagerWithOptions("", "", JsonDB.Options{RootDir: *rootDir})
+	if err != nil {
+		_, _ = ColorRed.Println("❌ 打开数据根目录失败:", err.Error())
+		os.Exit(1)
+	}
+	defer manager.Close()
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ==================== 批量写入 BulkWrite ====================
+//
+// BulkWrite 在一次写锁内依次执行一批写操作：集合与索引只加载一次，全部操作在内存中完成后只提交一次。
+// 每个操作单独成败，失败的操作不留下任何修改；整批的处理方式由 WriteMode 决定。
+
+// WriteMode 批量写入遇到错误时的处理方式
+type WriteMode int
+
+const (
+	WriteOrdered   WriteMode = iota // 按顺序执行，遇到第一个错误即停止并回滚整批（默认）
+	WriteUnordered                  // 执行全部操作，失败的操作逐个报告，其余照常提交
+)
+
+// WriteError 批量写入中单个操作的错误
+type WriteError struct {
+	Index int   // 操作（或文档）在输入中的下标
+	Err   error // 失败原因，违反唯一约束时为 *DuplicateKeyError
+}
+
+// BulkWriteError 批量写入的错误报告，按输入顺序列出失败的操作
+type BulkWriteError struct {
+	Errors []WriteError
+}
+
+func (e *BulkWriteError) Error() string {
+	first := e.Errors[0]
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("第 %d 个写入失败: %v", first.Index, first.Err)
+	}
+	return fmt.Sprintf("%d 个写入失败，第一个错误在第 %d 个: %v", len(e.Errors), first.Index, first.Err)
+}
+
+// Unwrap 支持用 errors.As 取出单个操作的错误，如 *DuplicateKeyError
+func (e *BulkWriteError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, item := range e.Errors {
+		errs[i] = item.Err
+	}
+	return errs
+}
+
+// WriteModel BulkWrite 中的一个写操作：InsertOneModel、UpdateOneModel、UpdateManyModel、
+// ReplaceOneModel、DeleteOneModel 或 DeleteManyModel
+type WriteModel interface {
+	writeOp() (writeOp, error)
+}
+
+// InsertOneModel 插入一篇文档，与 InsertOne 相同会生成新的 _id
+type InsertOneModel struct {
+	Document Document
+}
+
+// UpdateOneModel 更新一个满足过滤条件的文档，见 UpdateOne
+type UpdateOneModel struct {
+	Filter          map[string]interface{}
+	Update          Document
+	Upsert          bool
+	Sort            []SortField
+	ExpectedVersion int // 见 UpdateOptions.ExpectedVersion
+}
+
+// UpdateManyModel 更新全部满足过滤条件的文档，见 UpdateMany
+type UpdateManyModel struct {
+	Filter map[string]interface{}
+	Update Document
+	Upsert bool
+}
+
+// ReplaceOneModel 整篇替换一个满足过滤条件的文档，见 ReplaceOne
+type ReplaceOneModel struct {
+	Filter          map[string]interface{}
+	Replacement     Document
+	Upsert          bool
+	Sort            []SortField
+	ExpectedVersion int // 见 UpdateOptions.ExpectedVersion
+}
+
+// DeleteOneModel 删除一个满足过滤条件的文档，见 DeleteOne
+type DeleteOneModel struct {
+	Filter          map[string]interface{}
+	Sort            []SortField
+	ExpectedVersion int // 见 DeleteOptions.ExpectedVersion
+}
+
+// DeleteManyModel 删除全部满足过滤条件的文档，见 Delete
+type DeleteManyModel struct {
+	Filter map[string]interface{}
+}
+
+func (m InsertOneModel) writeOp() (writeOp, error) {
+	if m.Document == nil {
+		return writeOp{}, errors.New("文档不能为空")
+	}
+	return writeOp{insertOnly: true, insert: func(time.Time) (Document, error) {
+		doc := cloneDocument(m.Document)
+		doc["_id"] = generateObjectID()
+		return doc, nil
+	}}, nil
+}
+
+func (m UpdateOneModel) writeOp() (writeOp, error) {
+	opt := UpdateOptions{Upsert: m.Upsert, Sort: m.Sort, ExpectedVersion: m.ExpectedVersion}
+	return updateWrite(m.Filter, m.Update, opt, true)
+}
+
+func (m UpdateManyModel) writeOp() (writeOp, error) {
+	return updateWrite(m.Filter, m.Update, UpdateOptions{Upsert: m.Upsert}, false)
+}
+
+func (m ReplaceOneModel) writeOp() (writeOp, error) {
+	opt := UpdateOptions{Upsert: m.Upsert, Sort: m.Sort, ExpectedVersion: m.ExpectedVersion}
+	return replaceWrite(m.Filter, m.Replacement, opt)
+}
+
+func (m DeleteOneModel) writeOp() (writeOp, error) {
+	return deleteOneWrite(m.Filter, DeleteOptions{Sort: m.Sort, ExpectedVersion: m.ExpectedVersion}), nil
+}
+
+func (m DeleteManyModel) writeOp() (writeOp, error) {
+	return writeOp{filter: m.Filter}, nil
+}
+
+// BulkWriteOptions BulkWrite 的可选参数
+type BulkWriteOptions struct {
+	Mode WriteMode // 遇到错误时的处理方式，默认 WriteOrdered
+}
+
+// BulkWriteResult 批量写入的汇总结果
+type BulkWriteResult struct {
+	InsertedCount int
+	MatchedCount  int
+	ModifiedCount int
+	DeletedCount  int
+	UpsertedCount int
+	InsertedIDs   map[int]string // 操作下标 -> 插入文档的 _id
+	UpsertedIDs   map[int]string // 操作下标 -> upsert 插入文档的 _id
+}
+
+// BulkWrite 在当前集合上依次执行一批写操作，集合与索引只加载、提交一次
+// - models: 写操作，后面的操作能看到前面操作的结果
+// - opts: 可选，Mode 指定遇到错误时的处理方式，默认 WriteOrdered
+//
+// WriteOrdered 遇到第一个错误即停止，整批都不写入，返回 nil 和只含该操作的 *BulkWriteError；
+// WriteUnordered 跳过失败的操作并提交其余操作，有操作失败时同时返回结果和 *BulkWriteError。
+func (db *DBContext) BulkWrite(models []WriteModel, opts ...BulkWriteOptions) (*BulkWriteResult, error) {
+	mode := WriteOrdered
+	if len(opts) > 0 {
+		mode = opts[0].Mode
+	}
+
+	unlock, err := db.writeLock(db.CurrentCollection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	data, err := loadCollection(db)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := db.loadIndexes(db.CurrentCollection, data)
+	if err != nil {
+		return nil, err
+	}
+	versioned, err := db.versioned(db.CurrentCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := &BulkWriteResult{InsertedIDs: make(map[int]string), UpsertedIDs: make(map[int]string)}
+	var changes []Change
+	var undo undoLog
+	var failed []WriteError
+	for i, model := range models {
+		w, err := modelOp(model)
+		if err == nil {
+			w, err = w.withVersion(versioned)
+		}
+		var out *writeOutcome
+		if err == nil {
+			out, err = applyWrite(data, indexes, w, now)
+		}
+		if err != nil {
+			failed = append(failed, WriteError{Index: i, Err: err})
+			if mode == WriteOrdered {
+				// 撤销之前的操作，整批都不写入
+				undo.revert(data, indexes)
+				return nil, &BulkWriteError{Errors: failed}
+			}
+			continue
+		}
+		changes = append(changes, out.changes...)
+		undo = append(undo, out.undo...)
+
+		res := out.res
+		if w.insertOnly {
+			result.InsertedCount++
+			result.InsertedIDs[i] = res.UpsertedID
+			continue
+		}
+		result.MatchedCount += res.MatchedCount
+		result.ModifiedCount += res.ModifiedCount
+		result.DeletedCount += res.DeletedCount
+		if res.UpsertedID != "" {
+			result.UpsertedCount++
+			result.UpsertedIDs[i] = res.UpsertedID
+		}
+	}
+
+	if len(changes) > 0 {
+		if err := commitCollection(db, data, changes, indexes); err != nil {
+			undo.revert(data, indexes)
+			return nil, err
+		}
+		_ = db.setDocCount(db.CurrentCollection, len(data))
+	}
+
+	if len(failed) > 0 {
+		return result, &BulkWriteError{Errors: failed}
+	}
+	return result, nil
+}
+
+// modelOp 把写操作转换为 writeOp，参数不合法时返回错误
+func modelOp(model WriteModel) (writeOp, error) {
+	if model == nil {
+		return writeOp{}, errors.New("写操作不能为空")
+	}
+	return model.writeOp()
+}
@@ -0,0 +1,647 @@
+package services
+
+import (
+	"container/list"
+	"errors"
+	"github.com/StephenChristianW/JsonDB/config"
+	"github.com/StephenChristianW/JsonDB/fileIO"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ==================== 集合缓存与延迟落盘 ====================
+//
+// FileStorage 在内存中缓存已解析的集合，同一根目录在进程内共用一份缓存（rootCache）：
+//
+//   - 读取时比较集合文件的 inode、大小与修改时间，文件被其他进程改写或替换后重新加载
+//   - 缓存总大小按集合文件大小估算，超过 Options.CacheSize 时淘汰最久未使用的已落盘集合
+//   - 缓存中的文档只读：提交时存入变更文档的副本，Find 等返回给调用方的都是副本
+//   - 集合的已解析索引随集合一起缓存，查询不再读取索引文件
+//   - 写操作持有集合写锁，直接修改缓存中的文档映射与索引，失败时用 undoLog 恢复，不复制整个集合
+//
+// 写入按数据库的落盘策略（config.FlushPolicy）处理：立即落盘时与不缓存时相同；延迟落盘时只追加 WAL
+// 并更新缓存，集合记为未落盘，到时间（见 FlushPolicy.Delay）或累计写入次数后再写集合文件并做检查点。
+// 延迟落盘以及日志格式的集合每次写入只追加少量数据，文档数量与有变化的索引也推迟到落盘时写入（见 pendingMeta）。
+// 未落盘期间本进程一直持有集合的排他文件锁，其他进程不会读到过期的集合文件；
+// 进程内的操作通过 collectionLock 复用这把锁。
+
+// cacheKey 根目录中的一个集合
+type cacheKey struct {
+	db, collection string
+}
+
+// cacheEntry 已缓存的集合
+type cacheEntry struct {
+	data    map[string]Document // 集合全部文档，只有持有集合写锁的写操作可以修改
+	indexes indexSet            // 与 data 对应的已解析索引，同样只有写操作可以修改；nil 表示尚未读取
+	info    os.FileInfo         // 加载或落盘时的集合文件
+	size    int64               // 估算的内存占用，即集合文件大小
+	elem    *list.Element       // 在 LRU 链表中的位置
+
+	// 以下字段只在有未落盘的写入时使用
+	dirty   bool
+	changed map[string]struct{} // 变更过的 _id，日志格式集合落盘时只追加这些文档
+	seqs    []uint64            // 对应的 WAL 记录
+	writes  int                 // 未落盘的写入次数
+	timer   *time.Timer         // 按 FlushPolicy.Delay 落盘的定时器
+}
+
+// collectionLock 进程内共用的集合文件锁
+//
+// 同一进程的多个文件描述符之间同样互斥，因此每个集合最多占用一个描述符，兼容的请求只增加引用计数。
+type collectionLock struct {
+	lock *fileIO.FileLock
+	mode fileIO.LockMode
+	refs int // 正在使用该锁的操作数，为 0 且集合没有未落盘的写入时释放
+}
+
+// pendingMeta 推迟写入的元数据，落盘时保存
+type pendingMeta struct {
+	count   int                 // 最近一次写入后的文档数量
+	counted bool                // count 有效
+	indexes map[string]struct{} // 有变化的索引，已保存的旧数据已删除，落盘时从缓存的索引保存
+	info    os.FileInfo         // 记录 count 时的集合文件，有未落盘的写入时为 nil
+}
+
+// walSeq 数据库 WAL 中的一条记录
+type walSeq struct {
+	db  string
+	seq uint64
+}
+
+// rootCache 一个数据根目录的集合缓存
+type rootCache struct {
+	mu       sync.Mutex
+	store    *FileStorage // 第一次打开该根目录的存储，定时落盘时用于加锁
+	budget   int64
+	used     int64
+	entries  map[cacheKey]*cacheEntry
+	lru      *list.List // 元素为 cacheKey，最近使用的在前
+	locks    map[cacheKey]*collectionLock
+	policies map[string]config.FlushPolicy
+	fallback config.FlushPolicy // 未单独设置的数据库使用的策略
+	seqRefs  map[walSeq]int     // 一条 WAL 记录可以覆盖多个集合（事务），全部落盘后才做检查点
+	pending  map[cacheKey]*pendingMeta
+}
+
+var (
+	rootCacheMu sync.Mutex
+	rootCaches  = make(map[string]*rootCache)
+)
+
+// getRootCache 获取根目录对应的缓存，同一根目录在进程内共享同一个实例
+func getRootCache(s *FileStorage) *rootCache {
+	rootCacheMu.Lock()
+	defer rootCacheMu.Unlock()
+	c, ok := rootCaches[s.root]
+	if !ok {
+		c = &rootCache{
+			store:    s,
+			budget:   s.opts.CacheSize,
+			entries:  make(map[cacheKey]*cacheEntry),
+			lru:      list.New(),
+			locks:    make(map[cacheKey]*collectionLock),
+			policies: make(map[string]config.FlushPolicy),
+			fallback: s.opts.Flush,
+			seqRefs:  make(map[walSeq]int),
+			pending:  make(map[cacheKey]*pendingMeta),
+		}
+		rootCaches[s.root] = c
+	}
+	return c
+}
+
+// sameFile 两次取得的文件信息是否对应同一份内容
+func sameFile(a, b os.FileInfo) bool {
+	return a != nil && b != nil && os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// copyCollection 复制集合的文档映射，文档本身共用
+func copyCollection(data map[string]Document) map[string]Document {
+	out := make(map[string]Document, len(data))
+	for id, doc := range data {
+		out[id] = doc
+	}
+	return out
+}
+
+// cached 集合是否在缓存中
+func (c *rootCache) cached(key cacheKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+// ---------------- 缓存 ----------------
+
+// get 读取缓存的集合，info 为当前的集合文件（不存在时为 nil）
+// 有未落盘的写入时缓存总是最新的；否则文件发生变化时丢弃缓存
+func (c *rootCache) get(key cacheKey, info os.FileInfo) (map[string]Document, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.dirty && !sameFile(e.info, info) {
+		c.remove(key)
+		return nil, false
+	}
+	c.lru.MoveToFront(e.elem)
+	return e.data, true
+}
+
+// indexes 缓存中与集合文档对应的已解析索引，集合不在缓存中或索引尚未读取时返回 nil
+func (c *rootCache) indexes(key cacheKey) indexSet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		return e.indexes
+	}
+	return nil
+}
+
+// setIndexes 缓存集合的已解析索引，集合不在缓存中时不做任何操作
+func (c *rootCache) setIndexes(key cacheKey, set indexSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.indexes = set
+	}
+}
+
+// put 缓存从集合文件加载或刚写入集合文件的全部文档
+func (c *rootCache) put(key cacheKey, data map[string]Document, info os.FileInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && e.dirty {
+		return
+	}
+	c.remove(key)
+	if c.budget < 0 || info.Size() > c.budget {
+		return
+	}
+	e := &cacheEntry{data: data, info: info, size: info.Size()}
+	e.elem = c.lru.PushFront(key)
+	c.entries[key] = e
+	c.used += e.size
+	c.evict()
+}
+
+// stored 提交成功后缓存写入的集合与索引，变更的文档换成副本，调用方之后修改这些文档不影响缓存
+func (s *FileStorage) stored(dbName string, writes []CollectionWrite) {
+	for _, w := range writes {
+		if w.Data == nil {
+			continue
+		}
+		cacheChanges(w)
+		key := cacheKey{dbName, w.Collection}
+		path, _ := s.collectionFile(dbName, w.Collection)
+		info, err := os.Stat(path)
+		if err != nil {
+			s.cache.drop(key)
+			continue
+		}
+		s.cache.put(key, w.Data, info)
+		s.cache.setIndexes(key, w.parsed)
+	}
+}
+
+// cacheChanges 把 w.Data 中变更过的文档换成副本
+func cacheChanges(w CollectionWrite) {
+	for _, change := range w.Changes {
+		if doc, ok := w.Data[change.ID]; ok {
+			w.Data[change.ID] = cloneDocument(doc)
+		}
+	}
+}
+
+// drop 丢弃集合的缓存，未落盘的写入也一并丢弃，调用方需先落盘
+func (c *rootCache) drop(keys ...cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		c.remove(key)
+		c.releaseIdle(key)
+	}
+}
+
+// dropDB 丢弃数据库中全部集合的缓存
+func (c *rootCache) dropDB(dbName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.db == dbName {
+			c.remove(key)
+			c.releaseIdle(key)
+		}
+	}
+}
+
+// remove 删除缓存项，调用方需持有 c.mu
+func (c *rootCache) remove(key cacheKey) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	c.lru.Remove(e.elem)
+	c.used -= e.size
+	delete(c.entries, key)
+}
+
+// evict 淘汰最久未使用的已落盘集合，直到总大小不超过上限，调用方需持有 c.mu
+func (c *rootCache) evict() {
+	for elem := c.lru.Back(); elem != nil && c.used > c.budget; {
+		prev := elem.Prev()
+		key := elem.Value.(cacheKey)
+		if !c.entries[key].dirty {
+			c.remove(key)
+		}
+		elem = prev
+	}
+}
+
+// ---------------- 落盘策略 ----------------
+
+func (c *rootCache) policy(dbName string) config.FlushPolicy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.policies[dbName]; ok {
+		return p
+	}
+	return c.fallback
+}
+
+func (c *rootCache) setPolicy(dbName string, policy config.FlushPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policies[dbName] = policy
+}
+
+// anyDirty writes 中是否有集合存在未落盘的写入
+func (c *rootCache) anyDirty(dbName string, writes []CollectionWrite) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range writes {
+		if e, ok := c.entries[cacheKey{dbName, w.Collection}]; ok && e.dirty {
+			return true
+		}
+	}
+	return false
+}
+
+// unflushed 数据库中有未落盘写入或推迟写入的元数据的集合，按名称升序
+func (c *rootCache) unflushed(dbName string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var names []string
+	for key, e := range c.entries {
+		if key.db == dbName && e.dirty {
+			names = append(names, key.collection)
+		}
+	}
+	for key := range c.pending {
+		if key.db == dbName {
+			if e, ok := c.entries[key]; !ok || !e.dirty {
+				names = append(names, key.collection)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// markDirty 记录一次尚未落盘的写入，返回该集合累计未落盘的写入次数
+// - seq: 写入对应的 WAL 记录，0 表示没有
+func (c *rootCache) markDirty(key cacheKey, w CollectionWrite, seq uint64, policy config.FlushPolicy) int {
+	cacheChanges(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &cacheEntry{}
+		e.elem = c.lru.PushFront(key)
+		c.entries[key] = e
+	} else {
+		c.lru.MoveToFront(e.elem)
+	}
+	e.data, e.indexes = w.Data, w.parsed
+	if !e.dirty {
+		e.dirty, e.changed = true, make(map[string]struct{})
+	}
+	for _, change := range w.Changes {
+		e.changed[change.ID] = struct{}{}
+	}
+	if seq != 0 {
+		e.seqs = append(e.seqs, seq)
+		c.seqRefs[walSeq{key.db, seq}]++
+	}
+	e.writes++
+	if delay := policy.Delay(); e.timer == nil && delay > 0 {
+		e.timer = time.AfterFunc(delay, func() { c.flushLater(key, delay) })
+	}
+	return e.writes
+}
+
+// dirtyState 未落盘集合的全部文档与变更过的 _id（升序），没有未落盘的写入时 ok 为 false
+func (c *rootCache) dirtyState(key cacheKey) (data map[string]Document, changed []string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, exists := c.entries[key]
+	if !exists || !e.dirty {
+		return nil, nil, false
+	}
+	for id := range e.changed {
+		changed = append(changed, id)
+	}
+	sort.Strings(changed)
+	return e.data, changed, true
+}
+
+// markClean 集合已写入集合文件，返回可以做检查点的 WAL 记录
+func (c *rootCache) markClean(key cacheKey, info os.FileInfo) []uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	var done []uint64
+	for _, seq := range e.seqs {
+		ref := walSeq{key.db, seq}
+		if c.seqRefs[ref]--; c.seqRefs[ref] <= 0 {
+			delete(c.seqRefs, ref)
+			done = append(done, seq)
+		}
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.dirty, e.changed, e.seqs, e.writes, e.timer = false, nil, nil, 0, nil
+
+	c.used -= e.size
+	e.info, e.size = info, 0
+	if info != nil {
+		e.size = info.Size()
+	}
+	c.used += e.size
+	if info == nil || c.budget < 0 {
+		c.remove(key)
+	}
+	c.releaseIdle(key)
+	c.evict()
+	return done
+}
+
+// ---------------- 推迟写入的元数据 ----------------
+
+// pendingIndex 索引是否已推迟保存
+func (c *rootCache) pendingIndex(key cacheKey, name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[key]
+	if !ok {
+		return false
+	}
+	_, ok = p.indexes[name]
+	return ok
+}
+
+// pendIndex 推迟保存索引，调用方已删除已保存的旧数据
+func (c *rootCache) pendIndex(key cacheKey, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.pendingOf(key)
+	if p.indexes == nil {
+		p.indexes = make(map[string]struct{})
+	}
+	p.indexes[name] = struct{}{}
+}
+
+// pendCount 推迟写入文档数量，集合不在缓存中时返回 false，同时丢弃之前推迟的数量
+func (c *rootCache) pendCount(key cacheKey, count int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		if p, exists := c.pending[key]; exists {
+			p.counted = false
+		}
+		return false
+	}
+	p := c.pendingOf(key)
+	p.count, p.counted, p.info = count, true, nil
+	if !e.dirty {
+		p.info = e.info
+	}
+	return true
+}
+
+// pendingOf 集合推迟写入的元数据，没有时新建，调用方需持有 c.mu
+func (c *rootCache) pendingOf(key cacheKey) *pendingMeta {
+	p, ok := c.pending[key]
+	if !ok {
+		p = &pendingMeta{}
+		c.pending[key] = p
+	}
+	return p
+}
+
+// takePending 取出集合推迟写入的元数据，没有时返回 nil
+func (c *rootCache) takePending(key cacheKey) *pendingMeta {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.pending[key]
+	delete(c.pending, key)
+	return p
+}
+
+// flushLater 定时落盘：获得集合写锁后写入，失败时记录错误并在下一个间隔重试
+func (c *rootCache) flushLater(key cacheKey, interval time.Duration) {
+	s := c.store
+	err := NewDBContext(key.db, "", s).flushCollection(s, key.collection)
+	if err == nil {
+		return
+	}
+	s.LogError(err.Error()+" | msg: "+key.db+"."+key.collection, "flushLater", fileStoragePath)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && e.dirty {
+		e.timer = time.AfterFunc(interval, func() { c.flushLater(key, interval) })
+	}
+}
+
+// ---------------- 集合文件锁 ----------------
+
+// lockCollection 获得集合的文件锁，返回释放函数
+func (c *rootCache) lockCollection(s *FileStorage, key cacheKey, mode fileIO.LockMode) (func(), error) {
+	release := func() { c.unlockCollection(key) }
+	if c.join(key, mode) {
+		return release, nil
+	}
+	l, err := s.lockFile(mode, key.db, key.collection+".lock")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok := c.locks[key]; ok {
+		// 其他读操作同时获得了共享锁
+		_ = l.Unlock()
+		h.refs++
+	} else {
+		c.locks[key] = &collectionLock{lock: l, mode: mode, refs: 1}
+	}
+	return release, nil
+}
+
+// join 进程内已持有兼容的文件锁时增加引用计数
+func (c *rootCache) join(key cacheKey, mode fileIO.LockMode) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.locks[key]
+	if !ok || (h.mode == fileIO.LockShared && mode == fileIO.LockExclusive) {
+		return false
+	}
+	h.refs++
+	return true
+}
+
+func (c *rootCache) unlockCollection(key cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok := c.locks[key]; ok {
+		h.refs--
+		c.releaseIdle(key)
+	}
+}
+
+// retain 未落盘期间保留集合的排他文件锁；当前操作没有持有该锁时重新获得
+func (c *rootCache) retain(s *FileStorage, key cacheKey) error {
+	c.mu.Lock()
+	h, ok := c.locks[key]
+	c.mu.Unlock()
+	if ok {
+		if h.mode != fileIO.LockExclusive {
+			return errors.New("集合文件锁不是排他锁: " + key.db + "." + key.collection)
+		}
+		return nil
+	}
+	l, err := s.lockFile(fileIO.LockExclusive, key.db, key.collection+".lock")
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.locks[key] = &collectionLock{lock: l, mode: fileIO.LockExclusive}
+	return nil
+}
+
+// releaseIdle 没有操作使用且没有未落盘写入时释放文件锁，调用方需持有 c.mu
+func (c *rootCache) releaseIdle(key cacheKey) {
+	h, ok := c.locks[key]
+	if !ok || h.refs > 0 {
+		return
+	}
+	if e, cached := c.entries[key]; cached && e.dirty {
+		return
+	}
+	_ = h.lock.Unlock()
+	delete(c.locks, key)
+}
+
+// ==================== 落盘 ====================
+
+// FlushStorage 支持延迟落盘的存储后端，FileStorage 实现了该接口
+type FlushStorage interface {
+	FlushPolicy(dbName string) config.FlushPolicy            // 数据库当前的落盘策略
+	SetFlushPolicy(dbName string, policy config.FlushPolicy) // 修改数据库的落盘策略，只影响之后的写入
+	Unflushed(dbName string) []string                        // 有未落盘写入或推迟写入的元数据的集合
+	FlushCollection(dbName, collectionName string) error     // 把集合未落盘的写入写入集合文件，调用方需持有集合写锁
+}
+
+// metaDeferrer 能推迟写入文档数量与索引数据的存储后端，FileStorage 实现了该接口
+//
+// 日志格式或延迟落盘的集合每次写入只追加变更，若同时重写元数据目录与有变化的索引文件，
+// 写入的代价仍与集合大小成正比；这些元数据改为在落盘（Flush / Close）时写入。
+type metaDeferrer interface {
+	defersMeta(dbName, collectionName string) bool            // 提交时是否只给出有变化的索引名，见 CollectionWrite.Indexes
+	deferCount(dbName, collectionName string, count int) bool // 推迟写入文档数量，返回 false 时调用方直接写入
+}
+
+// flushStorage 当前存储后端的落盘接口，不支持时返回错误
+func (db *DBContext) flushStorage() (FlushStorage, error) {
+	fs, ok := db.store().(FlushStorage)
+	if !ok {
+		return nil, errors.New("存储后端不支持延迟落盘")
+	}
+	return fs, nil
+}
+
+// SetFlushPolicy 设置当前数据库的落盘策略，改为立即落盘时先写入尚未落盘的变更
+// - policy: 见 config.FlushPolicy，零值为每次写入立即落盘
+//
+// 策略只保存在当前进程中，同一根目录上的各个 DBManager 共用。
+func (db *DBContext) SetFlushPolicy(policy config.FlushPolicy) error {
+	if db.CurrentDB == "" {
+		return errors.New("数据库未选择")
+	}
+	fs, err := db.flushStorage()
+	if err != nil {
+		return err
+	}
+	fs.SetFlushPolicy(db.CurrentDB, policy)
+	if policy.Immediate() {
+		return db.Flush()
+	}
+	return nil
+}
+
+// Flush 把当前数据库中尚未落盘的写入写入集合文件并做检查点；存储后端不支持延迟落盘时不做任何操作
+func (db *DBContext) Flush() error {
+	fs, ok := db.store().(FlushStorage)
+	if !ok || db.CurrentDB == "" {
+		return nil
+	}
+	for _, name := range fs.Unflushed(db.CurrentDB) {
+		if err := db.flushCollection(fs, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushCollection 获得集合写锁后落盘，文件锁见 flushLock
+func (db *DBContext) flushCollection(fs FlushStorage, collectionName string) error {
+	unlock, err := db.flushLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fs.FlushCollection(db.CurrentDB, collectionName)
+}
+
+// FlushAll 把存储后端中所有数据库尚未落盘的写入写入集合文件，DBManager.Close 时调用
+// 存储后端不支持延迟落盘时不做任何操作；某个数据库失败时继续处理其余数据库，返回第一个错误
+func FlushAll(store Storage) error {
+	if _, ok := store.(FlushStorage); !ok {
+		return nil
+	}
+	dbNames, err := store.ListDBs()
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, dbName := range dbNames {
+		if err := NewDBContext(dbName, "", store).Flush(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
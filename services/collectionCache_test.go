@@ -0,0 +1,96 @@
+package services
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/StephenChristianW/JsonDB/config"
+)
+
+// 跨进程测试：子进程是重新运行的测试程序，由 deferredWriterEnv 指定数据根目录。
+
+const (
+	deferredWriterEnv = "JSONDB_TEST_DEFERRED_WRITER"
+	deferredTestDB    = "deferred"
+)
+
+// TestWritesOnlyPolicyReleasesRoot 另一个进程只设置 FlushPolicy.Writes 且未写满时，
+// 打开同一根目录的进程不会因为等待其落盘而超时
+func TestWritesOnlyPolicyReleasesRoot(t *testing.T) {
+	if root := os.Getenv(deferredWriterEnv); root != "" {
+		runDeferredWriter(root)
+		return
+	}
+
+	root := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWritesOnlyPolicyReleasesRoot$")
+	cmd.Env = append(os.Environ(), deferredWriterEnv+"="+root)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// 关闭 stdin 后子进程退出
+	defer func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}()
+
+	// 子进程的其他输出（如创建集合的提示）之后是 ready
+	lines := bufio.NewScanner(stdout)
+	for lines.Scan() && lines.Text() != "ready" {
+	}
+	if lines.Text() != "ready" {
+		t.Fatalf("子进程未就绪: %v", lines.Err())
+	}
+
+	// 子进程仍在运行并持有未落盘的写入；等待时间远小于 LockTimeout 的默认值
+	store := NewFileStorage(config.Options{RootDir: root, LockTimeout: 3 * config.DefaultFlushDelay}.Normalize())
+	start := time.Now()
+	if err := store.Recover(); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	t.Logf("Recover 等待了 %v", time.Since(start))
+
+	docs, err := NewDBContext(deferredTestDB, "c", store).Find(nil, nil)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(docs) != 1 {
+		t.Errorf("%d documents, want 1", len(docs))
+	}
+}
+
+// runDeferredWriter 子进程：写入一次但不满 Writes，通知父进程后等待 stdin 关闭，期间不调用 Flush
+func runDeferredWriter(root string) {
+	fail := func(err error) {
+		_, _ = os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(1)
+	}
+	store := NewFileStorage(config.Options{RootDir: root, Flush: config.FlushPolicy{Writes: 1000}}.Normalize())
+	db := NewDBContext("", "", store)
+	if err := db.DBCreate(deferredTestDB); err != nil {
+		fail(err)
+	}
+	db.CurrentDB = deferredTestDB
+	if err := db.CollectionCreate("c"); err != nil {
+		fail(err)
+	}
+	db.CurrentCollection = "c"
+	if _, err := db.InsertOne(Document{"n": 1}); err != nil {
+		fail(err)
+	}
+	_, _ = os.Stdout.WriteString("ready\n")
+	_, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+	os.Exit(0)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+)
+
+// ==================== 集合文件格式 ====================
+//
+// FileStorage 支持两种集合文件格式：
+//
+//   - FormatJSON：<collection>.json，整个集合一个 JSON 对象，每次写入重写整个文件（默认）
+//   - FormatJSONL：<collection>.jsonl，JSON Lines 追加日志，写入只追加变更的文档，
+//     失效记录达到 Options.CompactThreshold 且不少于有效文档数时自动压缩，也可以手动压缩
+//
+// 两种格式可以随时互相转换，转换与压缩都先完整写入新文件再替换，异常退出时不会丢失数据。
+
+// CollectionFormat 集合文件格式
+type CollectionFormat string
+
+const (
+	FormatJSON  CollectionFormat = "json"  // 整个集合一个 JSON 对象
+	FormatJSONL CollectionFormat = "jsonl" // JSON Lines 追加日志，见 fileIO/docLogIO.go
+)
+
+func (f CollectionFormat) check() error {
+	if f != FormatJSON && f != FormatJSONL {
+		return errors.New("未知的集合格式: " + string(f))
+	}
+	return nil
+}
+
+// FormatStorage 支持多种集合文件格式的存储后端，FileStorage 实现了该接口
+type FormatStorage interface {
+	CollectionFormat(dbName, collectionName string) (CollectionFormat, error)
+	ConvertCollection(dbName, collectionName string, format CollectionFormat) error // 转换集合格式
+	CompactCollection(dbName, collectionName string) error                          // 压缩日志格式集合
+}
+
+// CollectionOptions CollectionCreate 的可选参数
+type CollectionOptions struct {
+	Format CollectionFormat // 集合文件格式，默认 FormatJSON
+}
+
+// formatStorage 当前存储后端的格式接口，不支持时返回错误
+func (db *DBContext) formatStorage() (FormatStorage, error) {
+	fs, ok := db.store().(FormatStorage)
+	if !ok {
+		return nil, errors.New("存储后端不支持集合文件格式")
+	}
+	return fs, nil
+}
+
+// GetCollectionFormat 集合当前的文件格式
+// - collectionName: 集合名
+func (db *DBContext) GetCollectionFormat(collectionName string) (CollectionFormat, error) {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return "", err
+	}
+	fs, err := db.formatStorage()
+	if err != nil {
+		return "", err
+	}
+	unlock, err := db.readLock(collectionName)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	return fs.CollectionFormat(db.CurrentDB, collectionName)
+}
+
+// SetCollectionFormat 把集合转换为指定的文件格式，已是该格式时不做任何操作
+// - collectionName: 集合名
+// - format: FormatJSON 或 FormatJSONL
+func (db *DBContext) SetCollectionFormat(collectionName string, format CollectionFormat) error {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return err
+	}
+	if err := format.check(); err != nil {
+		return err
+	}
+	fs, err := db.formatStorage()
+	if err != nil {
+		return err
+	}
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := fs.ConvertCollection(db.CurrentDB, collectionName, format); err != nil {
+		return db.writeCollectionError(err, "SetCollectionFormat", collectionName)
+	}
+	return nil
+}
+
+// CompactCollection 压缩日志格式集合，只保留每篇文档的最新内容；JSON 格式的集合不做任何操作
+// - collectionName: 集合名
+func (db *DBContext) CompactCollection(collectionName string) error {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return err
+	}
+	fs, err := db.formatStorage()
+	if err != nil {
+		return err
+	}
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := fs.CompactCollection(db.CurrentDB, collectionName); err != nil {
+		return db.writeCollectionError(err, "CompactCollection", collectionName)
+	}
+	return nil
+}
@@ -3,19 +3,15 @@ package services
 import (
 	"errors"
 	"fmt"
-	"github.com/StephenChristianW/JsonDB/fileIO"
-	ConfigFile "github.com/StephenChristianW/JsonDB/fileIO/configFileIO"
-	UtilsFile "github.com/StephenChristianW/JsonDB/utils/file"
-	"os"
 )
 
 // CollectionService 集合操作接口
 type CollectionService interface {
-	CollectionSwitch(collectionName string) error                       // 切换当前集合
-	CollectionList(dbName string) ([]string, error)                     // 列出指定数据库下的所有集合
-	CollectionCreate(collectionName string) error                       // 在当前数据库中创建集合
-	CollectionDelete(collectionName string) error                       // 删除指定集合
-	CollectionRename(oldCollectionName, newCollectionName string) error // 重命名集合
+	CollectionSwitch(collectionName string) error                            // 切换当前集合
+	CollectionList(dbName string) ([]string, error)                          // 列出指定数据库下的所有集合
+	CollectionCreate(collectionName string, opts ...CollectionOptions) error // 在当前数据库中创建集合
+	CollectionDelete(collectionName string) error                            // 删除指定集合
+	CollectionRename(oldCollectionName, newCollectionName string) error      // 重命名集合
 }
 
 // 常量定义
@@ -28,25 +24,37 @@ const (
 // flashDocCount 更新指定集合的文档数量
 // - collectionName: 集合名
 func (db *DBContext) flashDocCount(collectionName string) error {
-	err := ConfigFile.UpdateCollectionStats(db.CurrentDB, collectionName)
+	data, err := db.store().LoadCollection(db.CurrentDB, collectionName)
 	if err != nil {
 		return err
 	}
-	return nil
+	return db.setDocCount(collectionName, len(data))
+}
+
+// setDocCount 写入已知的文档数量，写操作完成后调用，避免重新读取集合
+// - collectionName: 集合名
+// - count: 文档数量
+//
+// 存储后端推迟写入元数据时（见 metaDeferrer）只记录数量，落盘时再写入元数据目录。
+func (db *DBContext) setDocCount(collectionName string, count int) error {
+	if d, ok := db.store().(metaDeferrer); ok && d.deferCount(db.CurrentDB, collectionName, count) {
+		return nil
+	}
+	return db.catalog().UpdateCollectionStats(db.CurrentDB, collectionName, count)
 }
 
 // writeCollectionError 统一记录集合操作错误
 // - err: 错误对象
 // - funcName: 出错的函数名
 // - msg: 额外错误信息
-func writeCollectionError(err error, funcName string, msg string) error {
+func (db *DBContext) writeCollectionError(err error, funcName string, msg string) error {
 	var errInfo string
 	if msg != "" {
 		errInfo = err.Error() + " | msg: " + msg
 	} else {
 		errInfo = err.Error()
 	}
-	fileIO.WriteErrorInfo(errInfo, funcName, collectionServicePath)
+	db.store().LogError(errInfo, funcName, collectionServicePath)
 	return err
 }
 
@@ -58,12 +66,17 @@ func (db *DBContext) CollectionSwitch(collectionName string) error {
 	// 切换集合
 	err := db.switchCollection(collectionName)
 	if err != nil {
-		return writeCollectionError(err, "CollectionSwitch", collectionName)
+		return db.writeCollectionError(err, "CollectionSwitch", collectionName)
 	}
 
 	// 更新文档数量
+	unlock, err := db.readLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	if err := db.flashDocCount(collectionName); err != nil {
-		return writeCollectionError(err, "CollectionSwitch", collectionName)
+		return db.writeCollectionError(err, "CollectionSwitch", collectionName)
 	}
 	return nil
 }
@@ -74,21 +87,21 @@ func (db *DBContext) CollectionList(dbName string) ([]string, error) {
 	funcName := "CollectionList"
 
 	if dbName == "" {
-		return nil, writeCollectionError(errors.New("请输入正确的数据库名称"), funcName, dbName)
+		return nil, db.writeCollectionError(errors.New("请输入正确的数据库名称"), funcName, dbName)
 	}
 
-	dbs, _ := getDBs()
+	dbs, _ := db.getDBs()
 	if _, ok := dbs[dbName]; !ok {
-		return nil, writeCollectionError(errors.New("数据库不存在"), funcName, dbName)
+		return nil, db.writeCollectionError(errors.New("数据库不存在"), funcName, dbName)
 	}
 
 	collections, err := db.getCollectionNames(dbName)
 	if collections == nil || len(collections) == 0 {
-		return nil, writeCollectionError(errors.New("数据库: "+dbName+" 内无集合"), funcName, dbName)
+		return nil, db.writeCollectionError(errors.New("数据库: "+dbName+" 内无集合"), funcName, dbName)
 	}
 
 	if err != nil {
-		return nil, writeCollectionError(err, funcName, dbName)
+		return nil, db.writeCollectionError(err, funcName, dbName)
 	}
 
 	return collections, nil
@@ -96,7 +109,8 @@ func (db *DBContext) CollectionList(dbName string) ([]string, error) {
 
 // CollectionCreate 在当前数据库中创建新的集合
 // - collectionName: 新集合名
-func (db *DBContext) CollectionCreate(collectionName string) error {
+// - opts: 可选，Format 指定集合文件格式，默认 FormatJSON
+func (db *DBContext) CollectionCreate(collectionName string, opts ...CollectionOptions) error {
 
 	name, err := sanitizeName(collectionName)
 	if err != nil {
@@ -115,34 +129,49 @@ func (db *DBContext) CollectionCreate(collectionName string) error {
 		return errors.New("请输入正确集合名称")
 	}
 
-	// 获取集合文件路径
-	colPath, err := db.getCollectionFilePath(collectionName)
+	// 校验集合名
+	if err = db.checkCollectionName(collectionName); err != nil {
+		return db.writeCollectionError(err, funcName, collectionName)
+	}
+
+	unlock, err := db.writeLock(collectionName)
 	if err != nil {
-		return writeCollectionError(err, funcName, collectionName)
+		return err
 	}
+	defer unlock()
 
 	// 检查是否已存在
-	if UtilsFile.IsPathExist(colPath) {
-		return writeCollectionError(errors.New("集合: "+collectionName+" 已存在于: "+db.CurrentDB+" 中"), funcName, collectionName)
+	if db.store().CollectionExists(db.CurrentDB, collectionName) {
+		return db.writeCollectionError(errors.New("集合: "+collectionName+" 已存在于: "+db.CurrentDB+" 中"), funcName, collectionName)
 	}
 
-	// 创建空 JSON 文件作为集合
-	if err = os.WriteFile(colPath, []byte("{}"), 0666); err != nil {
-		return writeCollectionError(err, funcName, collectionName)
+	// 创建空集合
+	if err = db.store().CreateCollection(db.CurrentDB, collectionName); err != nil {
+		return db.writeCollectionError(err, funcName, collectionName)
+	}
+	if len(opts) > 0 && opts[0].Format != "" && opts[0].Format != FormatJSON {
+		fs, err := db.formatStorage()
+		if err == nil {
+			err = fs.ConvertCollection(db.CurrentDB, collectionName, opts[0].Format)
+		}
+		if err != nil {
+			_ = db.store().DeleteCollection(db.CurrentDB, collectionName)
+			return db.writeCollectionError(err, funcName, collectionName)
+		}
 	}
 	fmt.Printf("集合: %s.%s 已创建 \n", db.CurrentDB, collectionName)
 
 	// 更新配置文件
-	if err = ConfigFile.CollectionCreateConfig(db.CurrentDB, collectionName); err != nil {
-		return writeCollectionError(err, funcName, collectionName)
+	if err = db.catalog().CollectionCreateConfig(db.CurrentDB, collectionName); err != nil {
+		return db.writeCollectionError(err, funcName, collectionName)
 	}
-	if err = ConfigFile.DBUpdateConfig(db.CurrentDB); err != nil {
-		return writeCollectionError(err, funcName, collectionName)
+	if err = db.catalog().DBUpdateConfig(db.CurrentDB); err != nil {
+		return db.writeCollectionError(err, funcName, collectionName)
 	}
 
 	// 更新文档数量
 	if err := db.flashDocCount(collectionName); err != nil {
-		return writeCollectionError(err, funcName, collectionName)
+		return db.writeCollectionError(err, funcName, collectionName)
 	}
 
 	return nil
@@ -158,34 +187,34 @@ func (db *DBContext) CollectionDelete(collectionName string) error {
 		return errors.New("未选择数据库")
 	}
 
-	// 获取集合路径
-	colPath, err := db.getCollectionFilePath(collectionName)
+	// 校验集合名
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return db.writeCollectionError(err, funcName, collectionName)
+	}
+
+	unlock, err := db.writeLock(collectionName)
 	if err != nil {
-		return writeCollectionError(err, funcName, collectionName)
+		return err
 	}
+	defer unlock()
 
 	// 如果集合存在则删除
-	if UtilsFile.IsPathExist(colPath) {
-		// 删除集合文件
-		if err := os.Remove(colPath); err != nil {
-			return writeCollectionError(err, funcName, collectionName)
+	if db.store().CollectionExists(db.CurrentDB, collectionName) {
+		// 删除集合及其索引
+		if err := db.store().DeleteCollection(db.CurrentDB, collectionName); err != nil {
+			return db.writeCollectionError(err, funcName, collectionName)
 		}
 		// 更新配置文件
-		if err = ConfigFile.CollectionDeleteConfig(collectionName, colPath); err != nil {
-			return writeCollectionError(err, funcName, collectionName)
+		if err := db.catalog().CollectionDeleteConfig(db.CurrentDB, collectionName); err != nil {
+			return db.writeCollectionError(err, funcName, collectionName)
 		}
-		if err = ConfigFile.DBUpdateConfig(db.CurrentDB); err != nil {
-			return writeCollectionError(err, funcName, collectionName)
+		if err := db.catalog().DBUpdateConfig(db.CurrentDB); err != nil {
+			return db.writeCollectionError(err, funcName, collectionName)
 		}
 		fmt.Printf("集合: %s.%s 已删除 \n", db.CurrentDB, collectionName)
 	} else {
 		fmt.Printf("未找到: %s.%s 集合 \n", db.CurrentDB, collectionName)
 	}
-
-	// 更新文档数量
-	if err = db.flashDocCount(collectionName); err != nil {
-		return writeCollectionError(err, funcName, collectionName)
-	}
 	return nil
 }
 
@@ -200,34 +229,39 @@ func (db *DBContext) CollectionRename(oldCollectionName, newCollectionName strin
 		return errors.New("未选择数据库")
 	}
 
-	// 获取集合路径
-	oldColPath, err := db.getCollectionFilePath(oldCollectionName)
-	if err != nil {
-		return writeCollectionError(err, funcName, oldCollectionName)
+	// 校验集合名
+	if err := db.checkCollectionName(oldCollectionName); err != nil {
+		return db.writeCollectionError(err, funcName, oldCollectionName)
+	}
+	if err := db.checkCollectionName(newCollectionName); err != nil {
+		return db.writeCollectionError(err, funcName, newCollectionName)
 	}
-	newColPath, err := db.getCollectionFilePath(newCollectionName)
+
+	unlock, err := db.writeLock(oldCollectionName, newCollectionName)
 	if err != nil {
-		return writeCollectionError(err, funcName, newCollectionName)
+		return err
 	}
+	defer unlock()
 
 	// 执行重命名
-	if err = os.Rename(oldColPath, newColPath); err != nil {
-		return writeCollectionError(err, funcName, oldCollectionName+"->"+newCollectionName)
+	err = db.store().RenameCollection(db.CurrentDB, oldCollectionName, newCollectionName)
+	if err != nil {
+		return db.writeCollectionError(err, funcName, oldCollectionName+"->"+newCollectionName)
 	}
 
 	// 更新配置文件
-	if err = ConfigFile.CollectionRenameConfig(db.CurrentDB, oldCollectionName, newCollectionName); err != nil {
-		return writeCollectionError(err, funcName, oldCollectionName+"->"+newCollectionName)
+	if err = db.catalog().CollectionRenameConfig(db.CurrentDB, oldCollectionName, newCollectionName); err != nil {
+		return db.writeCollectionError(err, funcName, oldCollectionName+"->"+newCollectionName)
 	}
-	if err = ConfigFile.DBUpdateConfig(db.CurrentDB); err != nil {
-		return writeCollectionError(err, funcName, oldCollectionName+"->"+newCollectionName)
+	if err = db.catalog().DBUpdateConfig(db.CurrentDB); err != nil {
+		return db.writeCollectionError(err, funcName, oldCollectionName+"->"+newCollectionName)
 	}
 
 	fmt.Printf("集合: %s.%s 已改名为: %s.%s \n", db.CurrentDB, oldCollectionName, db.CurrentDB, newCollectionName)
 
 	// 更新文档数量
 	if err := db.flashDocCount(newCollectionName); err != nil {
-		return writeCollectionError(err, funcName, newCollectionName)
+		return db.writeCollectionError(err, funcName, newCollectionName)
 	}
 
 	return nil
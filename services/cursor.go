@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+)
+
+// ==================== 游标 ====================
+//
+// FindCursor 在集合读锁内确定候选文档并保存对它们的引用，随后释放锁；过滤、投影与复制文档在 Next 时
+// 按批进行，内存中只保留当前一批结果。未指定排序时按候选顺序逐批匹配，不需要先找出全部结果；
+// 指定排序时需要先找出全部匹配的文档再排序，同样只保存引用，不复制文档。
+//
+// 集合中的文档只读，写操作替换而不修改文档（见 collectionCache.go），游标打开之后的写入不影响游标的结果。
+
+// DefaultBatchSize FindOptions.BatchSize 未指定时每批返回的文档数
+const DefaultBatchSize = 100
+
+// errNoCurrent 游标没有当前文档
+var errNoCurrent = errors.New("游标没有当前文档，需先调用 Next")
+
+// Cursor 查询结果的游标，不能被多个 goroutine 同时使用
+type Cursor struct {
+	filter map[string]interface{}
+	proj   *projection
+
+	docs    []Document // 未排序时的候选文档，逐个匹配
+	sorted  []Document // 已排序的匹配结果，已应用 Skip 与 Limit
+	pos     int        // 下一个待处理的候选（docs 或 sorted 中的下标）
+	skip    int        // 未排序时还需跳过的匹配文档数
+	remain  int        // 未排序时还能返回的文档数，-1 表示不限
+	batch   DocumentList
+	current Document
+	size    int
+	err     error
+	closed  bool
+}
+
+// FindCursor 与 Find 相同的查询，结果通过游标逐批读取
+// - filter: 过滤条件
+// - opts: 可选，与 Find 相同；BatchSize 指定每批返回的文档数，默认 DefaultBatchSize
+//
+// 返回的文档都是副本，调用方可以修改。游标不持有锁，用完后应调用 Close 释放快照。
+func (db *DBContext) FindCursor(filter map[string]interface{}, opts *FindOptions) (*Cursor, error) {
+	unlock, err := db.readLock(db.CurrentCollection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	proj, err := findProjection(opts)
+	if err != nil {
+		return nil, err
+	}
+	data, err := loadCollection(db)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := db.loadIndexes(db.CurrentCollection, data)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cursor{filter: filter, proj: proj, size: DefaultBatchSize, remain: -1}
+	if opts == nil {
+		opts = &FindOptions{}
+	}
+	if opts.BatchSize > 0 {
+		c.size = opts.BatchSize
+	}
+	if len(opts.Sort) > 0 {
+		// 排序需要全部匹配结果，分页与 find 相同
+		c.sorted = find(data, indexes, filter, &FindOptions{Sort: opts.Sort, Skip: opts.Skip, Limit: opts.Limit}, nil)
+		return c, nil
+	}
+
+	c.skip = max(opts.Skip, 0)
+	if opts.Limit > 0 {
+		c.remain = opts.Limit
+	}
+	// 锁释放后集合与索引可能被写操作修改，这里复制出候选文档的引用
+	if plan := indexes.plan(filter, nil); !plan.scan {
+		c.docs = make([]Document, 0, len(plan.ids))
+		for _, id := range plan.ids {
+			if doc, ok := data[id]; ok {
+				c.docs = append(c.docs, doc)
+			}
+		}
+	} else {
+		c.docs = make([]Document, 0, len(data))
+		for _, doc := range data {
+			c.docs = append(c.docs, doc)
+		}
+	}
+	return c, nil
+}
+
+// Next 移动到下一篇文档，没有更多文档、ctx 已取消或游标已关闭时返回 false，原因见 Err
+func (c *Cursor) Next(ctx context.Context) bool {
+	c.current = nil
+	if c.closed || c.err != nil {
+		return false
+	}
+	if len(c.batch) == 0 {
+		if err := ctx.Err(); err != nil {
+			c.err = err
+			return false
+		}
+		c.fill()
+		if len(c.batch) == 0 {
+			return false
+		}
+	}
+	c.current, c.batch = c.batch[0], c.batch[1:]
+	return true
+}
+
+// fill 读取下一批文档：匹配、跳过、投影并复制
+func (c *Cursor) fill() {
+	c.batch = make(DocumentList, 0, c.size)
+	if c.sorted != nil {
+		for ; c.pos < len(c.sorted) && len(c.batch) < c.size; c.pos++ {
+			c.batch = append(c.batch, c.output(c.sorted[c.pos]))
+		}
+		return
+	}
+	for ; c.pos < len(c.docs) && len(c.batch) < c.size && c.remain != 0; c.pos++ {
+		doc := c.docs[c.pos]
+		if !matchDoc(doc, c.filter) {
+			continue
+		}
+		if c.skip > 0 {
+			c.skip--
+			continue
+		}
+		c.batch = append(c.batch, c.output(doc))
+		if c.remain > 0 {
+			c.remain--
+		}
+	}
+}
+
+// output 返回给调用方的文档：应用投影或复制
+func (c *Cursor) output(doc Document) Document {
+	if c.proj != nil {
+		return c.proj.apply(doc)
+	}
+	return cloneDocument(doc)
+}
+
+// Current 当前文档，Next 返回 true 之后有效
+func (c *Cursor) Current() Document {
+	return c.current
+}
+
+// Decode 把当前文档按 JSON 规则解码到 v，v 为结构体或 map 的指针
+func (c *Cursor) Decode(v interface{}) error {
+	if c.current == nil {
+		return errNoCurrent
+	}
+	bytes, err := json.Marshal(c.current)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, v)
+}
+
+// All 读取剩余的全部文档并关闭游标
+func (c *Cursor) All(ctx context.Context) (DocumentList, error) {
+	defer func() { _ = c.Close() }()
+	result := DocumentList{}
+	for c.Next(ctx) {
+		result = append(result, c.current)
+	}
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Err 使迭代提前结束的错误，正常读完时为 nil
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close 释放快照，可重复调用；关闭后 Next 返回 false
+func (c *Cursor) Close() error {
+	c.closed = true
+	c.docs, c.sorted, c.batch, c.current = nil, nil, nil, nil
+	return nil
+}
+
+// Documents 以 range-over-func 的方式遍历剩余文档，结束或提前 break 时关闭游标
+// 迭代出错时最后一次产出 nil 与错误
+func (c *Cursor) Documents(ctx context.Context) iter.Seq2[Document, error] {
+	return func(yield func(Document, error) bool) {
+		defer func() { _ = c.Close() }()
+		for c.Next(ctx) {
+			if !yield(c.current, nil) {
+				return
+			}
+		}
+		if err := c.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// FindSeq FindCursor 的迭代器形式：
+//
+//	for doc, err := range db.FindSeq(ctx, filter, opts) {
+//		if err != nil { ... }
+//	}
+//
+// 每次开始遍历时打开新的游标，打开失败时只产出一次 nil 与错误。
+func (db *DBContext) FindSeq(ctx context.Context, filter map[string]interface{}, opts *FindOptions) iter.Seq2[Document, error] {
+	return func(yield func(Document, error) bool) {
+		c, err := db.FindCursor(filter, opts)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		c.Documents(ctx)(yield)
+	}
+}
@@ -2,11 +2,6 @@ package services
 
 import (
 	"errors"
-	"github.com/StephenChristianW/JsonDB/config"
-	"github.com/StephenChristianW/JsonDB/fileIO"
-	ConfigFile "github.com/StephenChristianW/JsonDB/fileIO/configFileIO"
-	UtilsFile "github.com/StephenChristianW/JsonDB/utils/file"
-	"os"
 	"regexp"
 	"strings"
 )
@@ -51,14 +46,14 @@ type DBService interface {
 // - err: 错误对象
 // - funcName: 出错的函数名
 // - msg: 额外错误信息
-func writeDBError(err error, funcName string, msg string) error {
+func (db *DBContext) writeDBError(err error, funcName string, msg string) error {
 	var errInfo string
 	if msg != "" {
 		errInfo = err.Error() + " | msg: " + msg
 	} else {
 		errInfo = err.Error()
 	}
-	fileIO.WriteErrorInfo(errInfo, funcName, dbServicePath)
+	db.store().LogError(errInfo, funcName, dbServicePath)
 	return err
 }
 
@@ -72,29 +67,34 @@ func (db *DBContext) DBCreate(dbName string) error {
 	dbName = name
 
 	funcName := "CreateDB"
-	if dbName == "index" {
+	if dbName == indexDirName {
 		return errors.New("数据库名不能为: index")
 	}
 
 	// 检查数据库名是否有效
 	if err := validateName(dbName); err != nil {
-		return writeDBError(err, funcName, dbName)
+		return db.writeDBError(err, funcName, dbName)
 	}
 
-	// 获取数据库路径
-	dbPath, err := db.getDBFilePath(dbName)
+	// 校验数据库名
+	if err = db.checkDBName(dbName); err != nil {
+		return db.writeDBError(err, funcName, "")
+	}
+
+	unlock, err := db.lockDatabases(dbName)
 	if err != nil {
-		return writeDBError(err, funcName, "")
+		return err
 	}
+	defer unlock()
 
-	// 创建数据库目录
-	if err = fileIO.CreateDirectory(dbPath); err != nil {
-		return writeDBError(err, funcName, "")
+	// 创建数据库存储
+	if err = db.store().CreateDB(dbName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
 	// 在配置文件中创建数据库记录
-	if err = ConfigFile.DBCreateConfig(dbName); err != nil {
-		return writeDBError(err, funcName, "")
+	if err = db.catalog().DBCreateConfig(dbName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
 	return nil
@@ -105,20 +105,19 @@ func (db *DBContext) DBCreate(dbName string) error {
 func (db *DBContext) DBSwitch(dbName string) error {
 	funcName := "UseDB"
 
-	// 获取数据库路径
-	getDbPath, err := db.getDBFilePath(dbName)
-	if err != nil {
-		return writeDBError(err, funcName, "")
+	// 校验数据库名
+	if err := db.checkDBName(dbName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
-	// 检查数据库目录是否存在
-	if !UtilsFile.IsPathExist(getDbPath) {
+	// 检查数据库是否存在
+	if !db.store().DBExists(dbName) {
 		return errors.New("数据库: " + dbName + " 不存在")
 	}
 
 	// 切换当前数据库上下文
-	if err = db.switchDB(dbName); err != nil {
-		return writeDBError(err, funcName, "")
+	if err := db.switchDB(dbName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
 	return nil
@@ -132,44 +131,46 @@ func (db *DBContext) DBRename(oldDBName, newDBName string) error {
 
 	// 检查旧数据库名
 	if oldDBName == "" {
-		return writeDBError(errors.New("请输入正确的原数据库名"), funcName, "")
+		return db.writeDBError(errors.New("请输入正确的原数据库名"), funcName, "")
 	}
 
 	// 检查新数据库名
 	if err := validateName(newDBName); err != nil {
-		return writeDBError(errors.New("请输入正确的新数据库名"), funcName, "")
+		return db.writeDBError(errors.New("请输入正确的新数据库名"), funcName, "")
 	}
 
-	// 获取旧数据库路径
-	oldDbPath, err := db.getDBFilePath(oldDBName)
-	if err != nil {
-		return writeDBError(err, funcName, "")
+	// 校验新旧数据库名
+	if err := db.checkDBName(oldDBName); err != nil {
+		return db.writeDBError(err, funcName, "")
+	}
+	if err := db.checkDBName(newDBName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
-	// 获取新数据库路径
-	newDbPath, err := db.getDBFilePath(newDBName)
+	unlock, err := db.lockDatabases(oldDBName, newDBName)
 	if err != nil {
-		return writeDBError(err, funcName, "")
+		return err
 	}
+	defer unlock()
 
 	// 检查旧数据库是否存在
-	if !UtilsFile.IsPathExist(oldDbPath) {
-		return writeDBError(errors.New("源数据库不存在: "+oldDBName), funcName, "")
+	if !db.store().DBExists(oldDBName) {
+		return db.writeDBError(errors.New("源数据库不存在: "+oldDBName), funcName, "")
 	}
 
 	// 检查新数据库是否已存在
-	if UtilsFile.IsPathExist(newDbPath) {
-		return writeDBError(errors.New("同名数据库已存在: "+newDBName), funcName, "")
+	if db.store().DBExists(newDBName) {
+		return db.writeDBError(errors.New("同名数据库已存在: "+newDBName), funcName, "")
 	}
 
-	// 执行目录重命名
-	if err = os.Rename(oldDbPath, newDbPath); err != nil {
-		return writeDBError(err, funcName, "")
+	// 执行重命名
+	if err := db.store().RenameDB(oldDBName, newDBName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
 	// 更新配置文件
-	if err = ConfigFile.ReNameDBConfig(oldDBName, newDBName); err != nil {
-		return writeDBError(err, funcName, "")
+	if err := db.catalog().ReNameDBConfig(oldDBName, newDBName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
 	return nil
@@ -182,23 +183,28 @@ func (db *DBContext) DBDelete(dbName string) error {
 
 	// 检查数据库名是否为空
 	if dbName == "" {
-		return writeDBError(errors.New("数据库名为空"), funcName, "")
+		return db.writeDBError(errors.New("数据库名为空"), funcName, "")
+	}
+
+	// 校验数据库名
+	if err := db.checkDBName(dbName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
-	// 获取数据库目录路径
-	filePath, err := db.getDBFilePath(dbName)
+	unlock, err := db.lockDatabases(dbName)
 	if err != nil {
-		return writeDBError(err, funcName, "")
+		return err
 	}
+	defer unlock()
 
-	// 删除数据库目录及其内容
-	if err = os.RemoveAll(filePath); err != nil {
-		return writeDBError(err, funcName, "")
+	// 删除数据库及其内容
+	if err := db.store().DeleteDB(dbName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
 	// 更新配置文件（删除记录）
-	if err = ConfigFile.DBDeleteConfig(dbName); err != nil {
-		return writeDBError(err, funcName, "")
+	if err := db.catalog().DBDeleteConfig(dbName); err != nil {
+		return db.writeDBError(err, funcName, "")
 	}
 
 	return nil
@@ -208,24 +214,14 @@ func (db *DBContext) DBDelete(dbName string) error {
 func (db *DBContext) DBList() ([]string, error) {
 	funcName := "DBList"
 
-	// 读取数据库根目录
-	dirs, err := os.ReadDir(config.GetRootDir())
+	// 读取存储中的数据库
+	dbNames, err := db.store().ListDBs()
 	if err != nil {
-		return nil, writeDBError(err, funcName, "")
-	}
-
-	var dbNames []string
-	for _, dir := range dirs {
-		if dir.IsDir() {
-			if dir.Name() == "index" {
-				continue
-			}
-			dbNames = append(dbNames, dir.Name())
-		}
+		return nil, db.writeDBError(err, funcName, "")
 	}
 
 	if len(dbNames) == 0 {
-		return nil, writeDBError(errors.New("当前没有数据库"), funcName, "")
+		return nil, db.writeDBError(errors.New("当前没有数据库"), funcName, "")
 	}
 
 	return dbNames, nil
@@ -2,102 +2,244 @@ package services
 
 import (
 	"errors"
-	"fmt"
 	"sort"
-	"sync"
-
-	ConfigFile "github.com/StephenChristianW/JsonDB/fileIO/configFileIO"
+	"time"
 )
 
-var JsonMu sync.RWMutex
-
 type Document map[string]interface{}
 type DocumentList []Document
 
+// SortField 排序字段
+type SortField struct {
+	Field string // 字段名，支持点号路径
+	Order int    // 1 升序（默认），-1 降序
+}
+
 type FindOptions struct {
-	Sort   map[string]int // 1升序，-1降序
+	// Sort 排序字段，按优先级从高到低排列；值的比较规则见 compareValues，
+	// 字段缺失视为 null。排序字段全部相同的文档按 _id 升序排列，保证分页结果稳定
+	Sort   []SortField
 	Skip   int
 	Limit  int
-	Fields []string // 投影，可选
+	Fields []string // 投影，可选：只返回这些字段（及 _id），相当于 Projection 中全部取 1
+	// Projection 投影，可选，语法与 MongoDB 相同，如 {"password": 0}、{"address.city": 1, "_id": 0}、
+	// {"comments": {"$slice": -5}}、{"items": {"$elemMatch": {"qty": {"$gt": 1}}}}，详见 projection.go
+	Projection map[string]interface{}
+	// BatchSize 仅对 FindCursor 生效：每批读取的文档数，默认 DefaultBatchSize
+	BatchSize int
 }
 
 type DocServices interface {
 	Find(filter map[string]interface{}, opts *FindOptions) (DocumentList, error)
+	FindCursor(filter map[string]interface{}, opts *FindOptions) (*Cursor, error)
 	FindOne(filter map[string]interface{}) (Document, error)
 	InsertOne(doc Document) (Document, error)
-	InsertMany(docs []Document) ([]Document, error)
-	UpdateOne(filter map[string]interface{}, update Document) (Document, error)
-	UpdateMany(filter map[string]interface{}, update Document) ([]Document, error)
-	Delete(filter map[string]interface{}) (int, error)
+	InsertMany(docs []Document, opts ...InsertManyOptions) ([]Document, error)
+	ReplaceOne(filter map[string]interface{}, replacement Document, opts ...UpdateOptions) (*WriteResult, error)
+	BulkWrite(models []WriteModel, opts ...BulkWriteOptions) (*BulkWriteResult, error)
+	UpdateOne(filter map[string]interface{}, update Document, opts ...UpdateOptions) (*WriteResult, error)
+	UpdateMany(filter map[string]interface{}, update Document, opts ...UpdateOptions) (*WriteResult, error)
+	DeleteOne(filter map[string]interface{}, opts ...DeleteOptions) (*WriteResult, error)
+	Delete(filter map[string]interface{}) (*WriteResult, error)
+	FindOneAndUpdate(filter map[string]interface{}, update Document, opts ...FindOneAndOptions) (Document, error)
+	FindOneAndReplace(filter map[string]interface{}, replacement Document, opts ...FindOneAndOptions) (Document, error)
+	FindOneAndDelete(filter map[string]interface{}, opts ...FindOneAndOptions) (Document, error)
+}
+
+// ErrNotFound 没有满足条件的文档
+var ErrNotFound = errors.New("not found")
+
+// WriteResult 更新、删除操作的结果
+type WriteResult struct {
+	MatchedCount  int    // 满足过滤条件的文档数，UpdateOne / DeleteOne 最多为 1
+	ModifiedCount int    // 内容实际发生变化的文档数
+	DeletedCount  int    // 删除的文档数
+	UpsertedID    string // upsert 插入的新文档 _id，没有插入时为空
+}
+
+// UpdateOptions 更新的可选参数
+type UpdateOptions struct {
+	// Upsert 为 true 时，没有文档匹配则插入一篇新文档
+	Upsert bool
+	// Sort 仅对 UpdateOne 生效：多个文档匹配时更新排序后的第一个，未指定时取 _id 最小的
+	Sort []SortField
+	// ExpectedVersion 仅对 UpdateOne / ReplaceOne 生效，集合需启用文档版本：
+	// 选中文档的 _version 不等于该值时返回 *VersionConflictError，0 表示不检查
+	ExpectedVersion int
+}
+
+// DeleteOptions 删除的可选参数
+type DeleteOptions struct {
+	// Sort 多个文档匹配时删除排序后的第一个，未指定时取 _id 最小的
+	Sort []SortField
+	// ExpectedVersion 集合需启用文档版本：选中文档的 _version 不等于该值时返回 *VersionConflictError，0 表示不检查
+	ExpectedVersion int
+}
+
+// InsertManyOptions InsertMany 的可选参数
+type InsertManyOptions struct {
+	Mode WriteMode // 遇到错误时的处理方式，默认 WriteOrdered
+}
+
+// ReturnDocument FindOneAndUpdate / FindOneAndReplace 返回修改前还是修改后的文档
+type ReturnDocument int
+
+const (
+	ReturnBefore ReturnDocument = iota // 返回修改前的文档（默认），upsert 插入时返回 nil
+	ReturnAfter                        // 返回修改后的文档
+)
+
+// FindOneAndOptions FindOneAndUpdate / FindOneAndReplace / FindOneAndDelete 的可选参数
+type FindOneAndOptions struct {
+	Sort           []SortField            // 多个文档匹配时取排序后的第一个，未指定时取 _id 最小的
+	Projection     map[string]interface{} // 返回文档的投影
+	Upsert         bool                   // 没有文档匹配时插入新文档，对 FindOneAndDelete 无效
+	ReturnDocument ReturnDocument
 }
 
 // ---------------- DBContext 文档操作 ----------------
 
 func (db *DBContext) Find(filter map[string]interface{}, opts *FindOptions) (DocumentList, error) {
-	JsonMu.RLock()
-	defer JsonMu.RUnlock()
+	unlock, err := db.readLock(db.CurrentCollection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
+	proj, err := findProjection(opts)
+	if err != nil {
+		return nil, err
+	}
 	data, err := loadCollection(db)
 	if err != nil {
 		return nil, err
 	}
 
-	// 检查索引字段
-	indexFields := ConfigFile.GetIndexFields(db.CurrentDB, db.CurrentCollection)
-	candidateIDs := make(map[string]struct{})
-	for _, field := range indexFields {
-		if val, ok := filter[field]; ok {
-			indexMap, _ := loadIndex(db, field)
-			if ids, exists := indexMap[val]; exists {
-				for _, id := range ids {
-					candidateIDs[id] = struct{}{}
-				}
-			}
-		}
+	// 读锁下重建的索引只放入缓存，不写回存储
+	indexes, err := db.loadIndexes(db.CurrentCollection, data)
+	if err != nil {
+		return nil, err
 	}
 
-	var result DocumentList
-	for id, doc := range data {
-		if len(candidateIDs) > 0 {
-			if _, ok := candidateIDs[id]; !ok {
-				continue
-			}
-		}
-		if matchDoc(doc, filter) {
-			result = append(result, doc)
+	result := find(data, indexes, filter, opts, proj)
+	if proj == nil {
+		// 返回副本，调用方修改结果不影响存储后端缓存的文档
+		for i, doc := range result {
+			result[i] = cloneDocument(doc)
 		}
 	}
+	return result, nil
+}
 
-	// 排序
+// find 在已加载的集合上执行查询：过滤、排序、分页，proj 不为 nil 时应用投影
+func find(data map[string]Document, indexes indexSet, filter map[string]interface{}, opts *FindOptions, proj *projection) DocumentList {
+	var sortKeys []SortField
 	if opts != nil && len(opts.Sort) > 0 {
-		sort.Slice(result, func(i, j int) bool {
-			for k, order := range opts.Sort {
-				vi, _ := getNestedValue(result[i], k)
-				vj, _ := getNestedValue(result[j], k)
-				if compareNumber(vi, vj) == 0 {
-					continue
-				}
-				if order >= 0 {
-					return compareNumber(vi, vj) < 0
-				} else {
-					return compareNumber(vi, vj) > 0
-				}
-			}
-			return true
-		})
+		sortKeys = sortSpec(opts.Sort)
 	}
+	result := query(data, indexes, filter, sortKeys)
 
 	// 分页
 	if opts != nil && (opts.Skip > 0 || opts.Limit > 0) {
 		start := opts.Skip
 		end := len(result)
+		if start > end {
+			start = end
+		}
 		if opts.Limit > 0 && start+opts.Limit < end {
 			end = start + opts.Limit
 		}
 		result = result[start:end]
 	}
 
-	return result, nil
+	// 投影
+	if proj != nil {
+		for i, doc := range result {
+			result[i] = proj.apply(doc)
+		}
+	}
+
+	return result
+}
+
+// query 在已加载的集合上查找满足过滤条件的文档，sortKeys 为 sortSpec 规范化后的排序字段，为空时不排序
+func query(data map[string]Document, indexes indexSet, filter map[string]interface{}, sortKeys []SortField) DocumentList {
+	// 检查索引：有可用的索引时只复核候选文档
+	plan := indexes.plan(filter, sortKeys)
+
+	var result DocumentList
+	if !plan.scan {
+		for _, id := range plan.ids {
+			if doc, exists := data[id]; exists && matchDoc(doc, filter) {
+				result = append(result, doc)
+			}
+		}
+	} else {
+		for _, doc := range data {
+			if matchDoc(doc, filter) {
+				result = append(result, doc)
+			}
+		}
+	}
+
+	// 排序：索引已给出排序字段的顺序时，只需把排序字段相同的文档按 _id 排好
+	if len(sortKeys) > 0 {
+		if plan.sorted {
+			sortTies(result, sortKeys)
+		} else {
+			sort.Slice(result, func(i, j int) bool {
+				return compareDocs(result[i], result[j], sortKeys) < 0
+			})
+		}
+	}
+	return result
+}
+
+// sortSpec 规范化排序字段：方向统一为 1 或 -1，_id 之后的字段不影响顺序被丢弃，
+// 未指定 _id 时追加 _id 升序作为最后的排序字段
+func sortSpec(keys []SortField) []SortField {
+	spec := make([]SortField, 0, len(keys)+1)
+	for _, key := range keys {
+		order := 1
+		if key.Order < 0 {
+			order = -1
+		}
+		spec = append(spec, SortField{Field: key.Field, Order: order})
+		if key.Field == "_id" {
+			return spec
+		}
+	}
+	return append(spec, SortField{Field: "_id", Order: 1})
+}
+
+// compareDocs 按排序字段依次比较两个文档
+func compareDocs(a, b Document, keys []SortField) int {
+	for _, key := range keys {
+		va, _ := getNestedValue(a, key.Field)
+		vb, _ := getNestedValue(b, key.Field)
+		if c := compareValues(va, vb) * key.Order; c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// sortTies 对已按 _id 之外的排序字段排好序的文档，把排序字段全部相同的相邻文档按完整排序规则排序
+func sortTies(docs DocumentList, keys []SortField) {
+	groupKeys := keys[:len(keys)-1]
+	for i := 0; i < len(docs); {
+		j := i + 1
+		for j < len(docs) && compareDocs(docs[i], docs[j], groupKeys) == 0 {
+			j++
+		}
+		if j-i > 1 {
+			group := docs[i:j]
+			sort.Slice(group, func(a, b int) bool {
+				return compareDocs(group[a], group[b], keys) < 0
+			})
+		}
+		i = j
+	}
 }
 
 func (db *DBContext) FindOne(filter map[string]interface{}) (Document, error) {
@@ -108,157 +250,538 @@ func (db *DBContext) FindOne(filter map[string]interface{}) (Document, error) {
 	if len(res) > 0 {
 		return res[0], nil
 	}
-	return nil, errors.New("not found")
+	return nil, ErrNotFound
 }
 
 func (db *DBContext) InsertOne(doc Document) (Document, error) {
-	JsonMu.Lock()
-	defer JsonMu.Unlock()
+	unlock, err := db.writeLock(db.CurrentCollection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	data, err := loadCollection(db)
 	if err != nil {
 		return nil, err
 	}
 
-	// 唯一字段校验
-	uniqueFields, err := ConfigFile.GetUniqueFields(db.CurrentDB, db.CurrentCollection)
-	for _, field := range uniqueFields {
-		val, _ := getNestedValue(doc, field)
-		for _, d := range data {
-			v, _ := getNestedValue(d, field)
-			if fmt.Sprintf("%v", val) == fmt.Sprintf("%v", v) {
-				return nil, fmt.Errorf("唯一字段冲突: %s", field)
-			}
-		}
+	indexes, err := db.loadIndexes(db.CurrentCollection, data)
+	if err != nil {
+		return nil, err
+	}
+	versioned, err := db.versioned(db.CurrentCollection)
+	if err != nil {
+		return nil, err
 	}
 
 	id := generateObjectID()
 	doc["_id"] = id
-	data[id] = doc
+	if versioned {
+		doc[VersionField] = 1
+	}
+	normalizeDocument(doc)
 
-	if err := saveCollection(db, data); err != nil {
+	// 唯一约束校验
+	if err := indexes.checkUnique(id, doc); err != nil {
 		return nil, err
 	}
+	data[id] = doc
+
+	// 更新索引，随集合一起提交
+	indexes.insert(id, doc)
 
-	// 更新索引
-	indexFields := ConfigFile.GetIndexFields(db.CurrentDB, db.CurrentCollection)
-	updateIndex(db, id, doc, indexFields, false)
+	changes := []Change{putChange(id, doc)}
+	if err := commitCollection(db, data, changes, indexes); err != nil {
+		undoLog{{id: id}}.revert(data, indexes)
+		return nil, err
+	}
 
 	// 更新文档数量
-	_ = db.flashDocCount(db.CurrentCollection)
+	_ = db.setDocCount(db.CurrentCollection, len(data))
 
 	return doc, nil
 }
 
-func (db *DBContext) InsertMany(docs []Document) ([]Document, error) {
-	var result []Document
-	for _, doc := range docs {
-		newDoc, err := db.InsertOne(doc)
+// InsertMany 在一次写锁内插入一批文档，全部校验完成后只提交一次
+// - docs: 要插入的文档，与 InsertOne 相同，每篇文档都会生成新的 _id
+// - opts: 可选，Mode 指定遇到错误时的处理方式，默认 WriteOrdered
+//
+// WriteOrdered 遇到第一个错误即停止，整批都不写入，返回 nil 和只含该文档的 *BulkWriteError；
+// WriteUnordered 写入全部合法的文档并按输入顺序返回，有文档失败时同时返回 *BulkWriteError。
+// 输入的文档不会被修改。
+func (db *DBContext) InsertMany(docs []Document, opts ...InsertManyOptions) ([]Document, error) {
+	mode := WriteOrdered
+	if len(opts) > 0 {
+		mode = opts[0].Mode
+	}
+
+	unlock, err := db.writeLock(db.CurrentCollection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	data, err := loadCollection(db)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := db.loadIndexes(db.CurrentCollection, data)
+	if err != nil {
+		return nil, err
+	}
+	versioned, err := db.versioned(db.CurrentCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Document, 0, len(docs))
+	ids := make([]string, 0, len(docs))
+	changes := make([]Change, 0, len(docs))
+	var undo undoLog
+	var failed []WriteError
+	for i, doc := range docs {
+		id := generateObjectID()
+		if doc == nil {
+			err = errors.New("文档不能为空")
+		} else {
+			doc = cloneDocument(doc)
+			doc["_id"] = id
+			if versioned {
+				doc[VersionField] = 1
+			}
+			normalizeDocument(doc)
+			// 唯一约束索引逐条更新，批内的重复也能查出
+			err = indexes.checkUnique(id, doc)
+		}
 		if err != nil {
-			return nil, err
+			failed = append(failed, WriteError{Index: i, Err: err})
+			if mode == WriteOrdered {
+				// 撤销已插入的文档，有序索引尚未加入这一批文档，移除时不受影响
+				undo.revert(data, indexes)
+				return nil, &BulkWriteError{Errors: failed}
+			}
+			continue
 		}
-		result = append(result, newDoc)
+		data[id] = doc
+		indexes.insertHashed(id, doc)
+		undo = append(undo, undoEntry{id: id})
+		result = append(result, doc)
+		ids = append(ids, id)
+		changes = append(changes, putChange(id, doc))
+	}
+	indexes.insertOrdered(ids, result)
+
+	if err := commitCollection(db, data, changes, indexes); err != nil {
+		undo.revert(data, indexes)
+		return nil, err
+	}
+	if len(changes) > 0 {
+		_ = db.setDocCount(db.CurrentCollection, len(data))
+	}
+
+	if len(failed) > 0 {
+		return result, &BulkWriteError{Errors: failed}
 	}
 	return result, nil
 }
 
-func (db *DBContext) UpdateOne(filter map[string]interface{}, update Document) (Document, error) {
-	updatedDocs, err := db.UpdateMany(filter, update)
+// UpdateOne 更新一个满足过滤条件的文档，update 的写法见 updateEngine.go
+//
+// 多个文档匹配时更新 opts.Sort 排序后的第一个，未指定排序时取 _id 最小的；
+// Upsert 为 true 且没有文档匹配时插入一篇新文档，见 UpdateMany。
+func (db *DBContext) UpdateOne(filter map[string]interface{}, update Document, opts ...UpdateOptions) (*WriteResult, error) {
+	return db.update(filter, update, opts, true)
+}
+
+// UpdateMany 更新全部满足过滤条件的文档，update 的写法见 updateEngine.go
+//
+// opts 可选，Upsert 为 true 且没有文档匹配时插入一篇新文档：由过滤条件中的等值字段与更新内容生成，
+// 过滤条件含 _id 等值时沿用该 _id。
+func (db *DBContext) UpdateMany(filter map[string]interface{}, update Document, opts ...UpdateOptions) (*WriteResult, error) {
+	return db.update(filter, update, opts, false)
+}
+
+// ReplaceOne 用 replacement 整篇替换一个满足过滤条件的文档，_id 保持不变
+// - filter: 过滤条件
+// - replacement: 新的文档内容，不能包含更新操作符；带 _id 时必须与原文档相同
+// - opts: 可选，Sort 指定多个文档匹配时替换哪一个，未指定时取 _id 最小的；
+// Upsert 为 true 且没有文档匹配时插入 replacement，过滤条件含 _id 等值时沿用该 _id
+func (db *DBContext) ReplaceOne(filter map[string]interface{}, replacement Document, opts ...UpdateOptions) (*WriteResult, error) {
+	var opt UpdateOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	w, err := replaceWrite(filter, replacement, opt)
 	if err != nil {
 		return nil, err
 	}
-	if len(updatedDocs) > 0 {
-		return updatedDocs[0], nil
+
+	unlock, err := db.writeLock(db.CurrentCollection)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("not found")
+	defer unlock()
+	res, _, _, err := db.write(w)
+	return res, err
 }
 
-func (db *DBContext) UpdateMany(filter map[string]interface{}, update Document) ([]Document, error) {
-	JsonMu.Lock()
-	defer JsonMu.Unlock()
+func (db *DBContext) update(filter map[string]interface{}, update Document, opts []UpdateOptions, one bool) (*WriteResult, error) {
+	var opt UpdateOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	w, err := updateWrite(filter, update, opt, one)
+	if err != nil {
+		return nil, err
+	}
 
-	data, err := loadCollection(db)
+	unlock, err := db.writeLock(db.CurrentCollection)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+	res, _, _, err := db.write(w)
+	return res, err
+}
 
-	uniqueFields, err := ConfigFile.GetUniqueFields(db.CurrentDB, db.CurrentCollection)
+// updateWrite 构造更新操作，update 不合法时返回错误
+func updateWrite(filter map[string]interface{}, update Document, opt UpdateOptions, one bool) (writeOp, error) {
+	if !one && opt.ExpectedVersion != 0 {
+		return writeOp{}, errors.New("ExpectedVersion 只能用于单文档操作")
+	}
+	spec, err := parseUpdate(update)
+	if err != nil {
+		return writeOp{}, err
+	}
+	w := writeOp{filter: filter, sort: opt.Sort, one: one, version: opt.ExpectedVersion}
+	w.modify = func(old Document, now time.Time) (Document, error) {
+		return spec.apply(old, now)
+	}
+	if opt.Upsert {
+		w.insert = func(now time.Time) (Document, error) {
+			return upsertDoc(filter, spec, now)
+		}
+	}
+	return w, nil
+}
+
+// replaceWrite 构造整篇替换操作，replacement 含更新操作符时返回错误
+func replaceWrite(filter map[string]interface{}, replacement Document, opt UpdateOptions) (writeOp, error) {
+	if err := checkReplacement(replacement); err != nil {
+		return writeOp{}, err
+	}
+	w := writeOp{filter: filter, sort: opt.Sort, one: true, version: opt.ExpectedVersion}
+	w.modify = func(old Document, _ time.Time) (Document, error) {
+		return replaceDoc(old, replacement)
+	}
+	if opt.Upsert {
+		w.insert = func(time.Time) (Document, error) {
+			return upsertReplacement(filter, replacement)
+		}
+	}
+	return w, nil
+}
+
+// DeleteOne 删除一个满足过滤条件的文档，多个文档匹配时删除 opts.Sort 排序后的第一个，未指定排序时取 _id 最小的
+func (db *DBContext) DeleteOne(filter map[string]interface{}, opts ...DeleteOptions) (*WriteResult, error) {
+	var opt DeleteOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	w := deleteOneWrite(filter, opt)
+
+	unlock, err := db.writeLock(db.CurrentCollection)
 	if err != nil {
 		return nil, err
 	}
-	indexFields := ConfigFile.GetIndexFields(db.CurrentDB, db.CurrentCollection)
-	var updated []Document
-	for id, doc := range data {
-		if matchDoc(doc, filter) {
-			// 删除旧索引
-			updateIndex(db, id, doc, indexFields, true)
-
-			// 部分更新
-			for k, v := range update {
-				if k != "_id" {
-					doc[k] = v
-				}
-			}
+	defer unlock()
+	res, _, _, err := db.write(w)
+	return res, err
+}
 
-			// 唯一字段检查
-			for _, field := range uniqueFields {
-				val, _ := getNestedValue(doc, field)
-				for otherID, otherDoc := range data {
-					if otherID == id {
-						continue
-					}
-					v, _ := getNestedValue(otherDoc, field)
-					if val == v {
-						return nil, fmt.Errorf("唯一字段冲突: %s", field)
-					}
-				}
-			}
+// Delete 删除全部满足过滤条件的文档
+func (db *DBContext) Delete(filter map[string]interface{}) (*WriteResult, error) {
+	unlock, err := db.writeLock(db.CurrentCollection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	res, _, _, err := db.write(writeOp{filter: filter})
+	return res, err
+}
 
-			data[id] = doc
+// deleteOneWrite 构造删除单个文档的操作
+func deleteOneWrite(filter map[string]interface{}, opt DeleteOptions) writeOp {
+	return writeOp{filter: filter, sort: opt.Sort, one: true, version: opt.ExpectedVersion}
+}
 
-			// 添加新索引
-			updateIndex(db, id, doc, indexFields, false)
+// writeOp 一次更新或删除操作
+type writeOp struct {
+	filter map[string]interface{}
+	sort   []SortField // one 为 true 时用于选出第一个文档
+	one    bool        // 只处理一个文档
+	// modify 由旧文档生成新文档，为 nil 时删除匹配的文档
+	modify func(old Document, now time.Time) (Document, error)
+	// insert 没有文档匹配时生成 upsert 插入的新文档，为 nil 时不 upsert
+	insert func(now time.Time) (Document, error)
+	// insertOnly 不查找匹配的文档，直接插入 insert 生成的文档
+	insertOnly bool
+	version    int  // 期望的文档版本，0 表示不检查，见 versioning.go
+	versioned  bool // 集合启用了文档版本，由 withVersion 设置
+}
 
-			updated = append(updated, doc)
+// write 执行更新或删除并提交，返回操作结果以及第一个受影响文档修改前、修改后的内容
+// 内容没有变化的文档不会重新写入，只计入 MatchedCount
+// 调用方需持有当前集合的写锁
+func (db *DBContext) write(w writeOp) (res *WriteResult, before, after Document, err error) {
+	data, err := loadCollection(db)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	indexes, err := db.loadIndexes(db.CurrentCollection, data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	versioned, err := db.versioned(db.CurrentCollection)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if w, err = w.withVersion(versioned); err != nil {
+		return nil, nil, nil, err
+	}
+
+	out, err := applyWrite(data, indexes, w, time.Now())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(out.changes) == 0 {
+		return out.res, out.before, out.after, nil
+	}
+	if err := commitCollection(db, data, out.changes, indexes); err != nil {
+		out.undo.revert(data, indexes)
+		return nil, nil, nil, err
+	}
+
+	_ = db.setDocCount(db.CurrentCollection, len(data))
+
+	return out.res, out.before, out.after, nil
+}
+
+// writeOutcome applyWrite 的结果
+type writeOutcome struct {
+	res           *WriteResult
+	before, after Document // 第一个受影响文档修改前、修改后的内容
+	changes       []Change // 需要提交的变更
+	undo          undoLog  // 撤销这些变更，提交失败时使用
+}
+
+// applyWrite 在已加载的集合与索引上执行一次更新或删除，不提交
+// 出错时已做的修改全部撤销，data 与 indexes 保持调用前的状态；成功时 out.undo 可以撤销本次修改
+func applyWrite(data map[string]Document, indexes indexSet, w writeOp, now time.Time) (out *writeOutcome, err error) {
+	var undo undoLog
+	defer func() {
+		if err != nil {
+			undo.revert(data, indexes)
+		} else {
+			out.undo = undo
 		}
+	}()
+
+	var sortKeys []SortField
+	if w.one {
+		sortKeys = sortSpec(w.sort)
+	}
+	var matched DocumentList
+	if !w.insertOnly {
+		matched = query(data, indexes, w.filter, sortKeys)
+	}
+	if w.one && len(matched) > 1 {
+		matched = matched[:1]
 	}
 
-	if err := saveCollection(db, data); err != nil {
-		return nil, err
+	out = &writeOutcome{res: &WriteResult{}}
+	for i, old := range matched {
+		id, ok := old["_id"].(string)
+		if !ok {
+			return nil, errors.New("文档缺少 _id")
+		}
+		if err := w.checkVersion(id, old); err != nil {
+			return nil, err
+		}
+		out.res.MatchedCount++
+		if i == 0 {
+			out.before = old
+		}
+
+		if w.modify == nil {
+			// 删除索引
+			indexes.remove(id, old)
+			delete(data, id)
+			undo = append(undo, undoEntry{id: id, old: old})
+			out.res.DeletedCount++
+			out.changes = append(out.changes, deleteChange(id))
+			continue
+		}
+
+		doc, err := w.modify(old, now)
+		if err != nil {
+			return nil, err
+		}
+		if w.versioned {
+			bumpVersion(old, doc)
+		}
+		normalizeDocument(doc)
+		if i == 0 {
+			out.after = doc
+		}
+		if compareValues(old, doc) == 0 {
+			continue
+		}
+		if err := putDoc(data, indexes, id, old, doc); err != nil {
+			return nil, err
+		}
+		undo = append(undo, undoEntry{id: id, old: old})
+		out.res.ModifiedCount++
+		out.changes = append(out.changes, putChange(id, doc))
+	}
+
+	if len(matched) == 0 && w.insert != nil {
+		doc, err := w.insert(now)
+		if err != nil {
+			return nil, err
+		}
+		if w.versioned {
+			doc[VersionField] = 1
+		}
+		normalizeDocument(doc)
+		id, err := insertDoc(data, indexes, doc)
+		if err != nil {
+			return nil, err
+		}
+		undo = append(undo, undoEntry{id: id})
+		out.after = doc
+		out.res.UpsertedID = id
+		out.changes = append(out.changes, putChange(id, doc))
+	}
+	return out, nil
+}
+
+// undoLog 记录一次写操作改动过的文档，出错时按相反顺序恢复
+type undoLog []undoEntry
+
+type undoEntry struct {
+	id  string
+	old Document // 修改前的文档，nil 表示新插入
+}
+
+// revert 撤销全部改动，恢复 data 与 indexes
+func (u undoLog) revert(data map[string]Document, indexes indexSet) {
+	for i := len(u) - 1; i >= 0; i-- {
+		e := u[i]
+		if cur, ok := data[e.id]; ok {
+			indexes.remove(e.id, cur)
+			delete(data, e.id)
+		}
+		if e.old != nil {
+			data[e.id] = e.old
+			indexes.insert(e.id, e.old)
+		}
+	}
+}
+
+// putDoc 把 _id 为 id 的文档从 old 替换为 doc（old 为 nil 表示新插入），同步维护索引
+// 违反唯一约束时返回错误，data 与 indexes 保持不变
+func putDoc(data map[string]Document, indexes indexSet, id string, old, doc Document) error {
+	if old != nil {
+		// 删除旧索引
+		indexes.remove(id, old)
 	}
 
-	_ = db.flashDocCount(db.CurrentCollection)
+	// 唯一约束检查
+	if err := indexes.checkUnique(id, doc); err != nil {
+		if old != nil {
+			indexes.insert(id, old)
+		}
+		return err
+	}
 
-	return updated, nil
+	data[id] = doc
+
+	// 添加新索引
+	indexes.insert(id, doc)
+	return nil
 }
 
-func (db *DBContext) Delete(filter map[string]interface{}) (int, error) {
-	JsonMu.Lock()
-	defer JsonMu.Unlock()
+// insertDoc 插入已带有 _id 的新文档并维护索引，_id 已存在时返回 *DuplicateKeyError
+func insertDoc(data map[string]Document, indexes indexSet, doc Document) (string, error) {
+	id := doc["_id"].(string)
+	if _, exists := data[id]; exists {
+		return "", &DuplicateKeyError{Constraint: "_id", Fields: []string{"_id"}, Key: id, ConflictID: id}
+	}
+	return id, putDoc(data, indexes, id, nil, doc)
+}
 
+// upsertDoc 生成 upsert 插入的新文档：过滤条件中的等值字段加上更新内容
+func upsertDoc(filter map[string]interface{}, spec *updateSpec, now time.Time) (Document, error) {
+	base, err := upsertBase(filter)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := spec.apply(base, now)
+	if err != nil {
+		return nil, err
+	}
+	return withUpsertID(doc, base)
+}
+
+// withUpsertID 为 upsert 的新文档设置 _id：沿用过滤条件中的 _id，否则生成新的 _id
+func withUpsertID(doc, base Document) (Document, error) {
+	id, ok := base["_id"]
+	if !ok {
+		doc["_id"] = generateObjectID()
+		return doc, nil
+	}
+	if _, isString := id.(string); !isString {
+		return nil, errors.New("upsert 的 _id 必须是字符串")
+	}
+	doc["_id"] = id
+	return doc, nil
+}
+
+// deleteWhere 删除当前集合中满足 match 的全部文档，同步更新索引和文档数量
+// 调用方需持有当前集合的写锁
+func (db *DBContext) deleteWhere(match func(doc Document) bool) (int, error) {
 	data, err := loadCollection(db)
 	if err != nil {
 		return 0, err
 	}
 
-	indexFields := ConfigFile.GetIndexFields(db.CurrentDB, db.CurrentCollection)
+	indexes, err := db.loadIndexes(db.CurrentCollection, data)
+	if err != nil {
+		return 0, err
+	}
 
 	deleted := 0
+	var changes []Change
+	var undo undoLog
 	for id, doc := range data {
-		if matchDoc(doc, filter) {
+		if match(doc) {
 			// 删除索引
-			updateIndex(db, id, doc, indexFields, true)
+			indexes.remove(id, doc)
 
 			delete(data, id)
 			deleted++
+			changes = append(changes, deleteChange(id))
+			undo = append(undo, undoEntry{id: id, old: doc})
 		}
 	}
 
-	if err := saveCollection(db, data); err != nil {
+	if err := commitCollection(db, data, changes, indexes); err != nil {
+		undo.revert(data, indexes)
 		return 0, err
 	}
 
-	_ = db.flashDocCount(db.CurrentCollection)
+	_ = db.setDocCount(db.CurrentCollection, len(data))
 
 	return deleted, nil
 }
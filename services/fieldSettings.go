@@ -1,8 +1,15 @@
 package services
 
 import (
-	"github.com/StephenChristianW/JsonDB/fileIO"
-	Config "github.com/StephenChristianW/JsonDB/fileIO/configFileIO"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ConfigFile "github.com/StephenChristianW/JsonDB/fileIO/configFileIO"
 )
 
 // FieldService 字段约束 & 索引服务接口
@@ -12,14 +19,23 @@ type FieldService interface {
 
 	SetUniqueField(collectionName string, field string) error
 	UnSetUniqueField(collectionName string, field string) error
-	SetUniqueFields(collectionName string, fields []string) error
-	UnSetUniqueFields(collectionName string, fields []string) error
+	SetUniqueFields(collectionName string, fields []string, opts ...UniqueOptions) error
+	UnSetUniqueFields(collectionName string, fields []string, opts ...UniqueOptions) error
 	// ==================== 普通索引 index ====================
 
-	CreateIndex(collectionName string, index string) error
+	CreateIndex(collectionName string, index string, opts ...IndexOptions) error
 	DropIndex(collectionName string, index string) error
-	CreateIndexes(collectionName string, indexes []string) error
+	CreateIndexes(collectionName string, indexes []string, opts ...IndexOptions) error
 	DropIndexes(collectionName string, indexes []string) error
+	CreateCompoundIndex(collectionName string, keys []IndexKey, opts ...IndexOptions) error
+	// ==================== 索引管理 ====================
+
+	ListIndexes(collectionName string) ([]IndexInfo, error)
+	RebuildIndex(collectionName string, name string) error
+	VerifyIndexes(collectionName string, fix bool) ([]IndexReport, error)
+	// ==================== 文档版本 _version ====================
+
+	SetVersioning(collectionName string, enabled bool) error
 }
 
 const fieldSettingsPath = "JsonDB/services/fieldSettings.go"
@@ -29,7 +45,7 @@ const fieldSettingsPath = "JsonDB/services/fieldSettings.go"
 // - err: 实际捕获的错误
 // - msg: 可选的补充说明
 // 如果 err 不为 nil，则写入错误日志并返回原始错误
-func writeSettingsError(funcName string, err error, msg string) error {
+func (db *DBContext) writeSettingsError(funcName string, err error, msg string) error {
 	if err == nil {
 		return nil
 	}
@@ -39,7 +55,7 @@ func writeSettingsError(funcName string, err error, msg string) error {
 	} else {
 		errInfo = err.Error()
 	}
-	fileIO.WriteErrorInfo(errInfo, funcName, fieldSettingsPath)
+	db.store().LogError(errInfo, funcName, fieldSettingsPath)
 	return err
 }
 
@@ -49,64 +65,621 @@ func writeSettingsError(funcName string, err error, msg string) error {
 // - collectionName: 集合名
 // - field: 需要设置唯一约束的字段
 func (db *DBContext) SetUniqueField(collectionName string, field string) error {
-	err := Config.SetUniqueField(db.CurrentDB, collectionName, field)
-	return writeSettingsError("SetUniqueField", err, "")
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	keys, err := uniqueKeys([]string{field}, nil)
+	if err == nil {
+		err = db.setUnique(collectionName, keys, ConfigFile.UniqueDefinition{})
+	}
+	return db.writeSettingsError("SetUniqueField", err, "")
 }
 
 // UnSetUniqueField 取消集合的 单个唯一字段索引
 // - collectionName: 集合名
 // - field: 需要取消唯一约束的字段
 func (db *DBContext) UnSetUniqueField(collectionName string, field string) error {
-	err := Config.UnSetUniqueField(db.CurrentDB, collectionName, field)
-	return writeSettingsError("UnSetUniqueField", err, "")
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = db.unsetUnique(collectionName, []string{field})
+	return db.writeSettingsError("UnSetUniqueField", err, "")
+}
+
+// UniqueOptions 设置唯一约束的可选参数
+type UniqueOptions struct {
+	// Compound 为 true 时 fields 组成一个复合唯一约束：只有全部字段的值都相同才算冲突；
+	// 默认每个字段各自唯一
+	Compound bool
+	// Sparse 为 true 时缺少约束字段的文档不受约束（复合约束为全部字段都缺少），
+	// 适用于手机号这类只有部分文档才有的可选唯一字段
+	Sparse bool
+	// PartialFilter 只对满足该过滤条件的文档施加约束，语法与 Find 的过滤条件相同
+	PartialFilter map[string]interface{}
+}
+
+// uniqueDefinition 根据可选参数生成唯一约束定义
+func uniqueDefinition(opts []UniqueOptions) ConfigFile.UniqueDefinition {
+	if len(opts) == 0 {
+		return ConfigFile.UniqueDefinition{}
+	}
+	return ConfigFile.UniqueDefinition{Sparse: opts[0].Sparse, PartialFilter: opts[0].PartialFilter}
+}
+
+// compoundSep 复合唯一约束在配置中保存为用该分隔符连接的字段名，如 "email+org_id"
+const compoundSep = "+"
+
+// uniqueKeys 根据可选参数把字段列表转换为配置中的唯一约束键
+func uniqueKeys(fields []string, opts []UniqueOptions) ([]string, error) {
+	for _, field := range fields {
+		if field == "" || strings.Contains(field, compoundSep) {
+			return nil, errors.New("唯一字段名不能为空或包含 " + compoundSep + ": " + field)
+		}
+	}
+	if len(opts) == 0 || !opts[0].Compound {
+		return fields, nil
+	}
+	if len(fields) < 2 {
+		return nil, errors.New("复合唯一约束至少需要两个字段")
+	}
+	return []string{strings.Join(fields, compoundSep)}, nil
+}
+
+// uniqueFieldsOf 唯一约束键包含的字段
+func uniqueFieldsOf(key string) []string {
+	return strings.Split(key, compoundSep)
 }
 
 // SetUniqueFields 为集合设置 多个唯一字段索引
 // - collectionName: 集合名
 // - fields: 需要设置唯一约束的字段列表
-func (db *DBContext) SetUniqueFields(collectionName string, fields []string) error {
-	err := Config.SetUniqueFields(db.CurrentDB, collectionName, fields)
-	return writeSettingsError("SetUniqueFields", err, "")
+// - opts: 可选，Compound 为 true 时 fields 组成一个复合唯一约束
+func (db *DBContext) SetUniqueFields(collectionName string, fields []string, opts ...UniqueOptions) error {
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	keys, err := uniqueKeys(fields, opts)
+	if err == nil {
+		err = db.setUnique(collectionName, keys, uniqueDefinition(opts))
+	}
+	return db.writeSettingsError("SetUniqueFields", err, "")
 }
 
 // UnSetUniqueFields 取消集合的 多个唯一字段索引
 // - collectionName: 集合名
 // - fields: 需要取消唯一约束的字段列表
-func (db *DBContext) UnSetUniqueFields(collectionName string, fields []string) error {
-	err := Config.UnSetUniqueFields(db.CurrentDB, collectionName, fields)
-	return writeSettingsError("UnSetUniqueFields", err, "")
+// - opts: 可选，Compound 为 true 时取消由 fields 组成的复合唯一约束
+func (db *DBContext) UnSetUniqueFields(collectionName string, fields []string, opts ...UniqueOptions) error {
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	keys, err := uniqueKeys(fields, opts)
+	if err == nil {
+		err = db.unsetUnique(collectionName, keys)
+	}
+	return db.writeSettingsError("UnSetUniqueFields", err, "")
+}
+
+// setUnique 用集合现有文档构建唯一约束索引，存在重复值时返回 *DuplicateKeyError 且不修改配置；
+// 同名约束已存在时按新定义覆盖
+// 调用方需持有集合写锁
+func (db *DBContext) setUnique(collectionName string, constraints []string, def ConfigFile.UniqueDefinition) error {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return err
+	}
+	data, err := db.store().LoadCollection(db.CurrentDB, collectionName)
+	if err != nil {
+		return err
+	}
+	var set indexSet
+	defs := make(map[string]ConfigFile.UniqueDefinition, len(constraints))
+	for _, constraint := range constraints {
+		idx := newUniqueIndex(constraint, def)
+		idx.build(data)
+		if err := idx.duplicate(data); err != nil {
+			return err
+		}
+		set = append(set, idx)
+		defs[constraint] = def
+	}
+	if err := db.persistIndexes(collectionName, set); err != nil {
+		return err
+	}
+	return db.catalog().SetUniqueConstraints(db.CurrentDB, collectionName, defs)
+}
+
+// unsetUnique 取消唯一约束并删除其索引数据
+// 调用方需持有集合写锁
+func (db *DBContext) unsetUnique(collectionName string, constraints []string) error {
+	if err := db.catalog().UnSetUniqueFields(db.CurrentDB, collectionName, constraints); err != nil {
+		return err
+	}
+	for _, constraint := range constraints {
+		if err := db.store().DeleteIndex(db.CurrentDB, collectionName, uniqueIndexPrefix+constraint); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // ==================== 普通索引 index ====================
 
-// CreateIndex 为集合创建 单个普通索引
+// IndexOptions 创建索引的可选参数
+type IndexOptions struct {
+	// Kind 索引类型：IndexHash 只加速等值与 $in 查询；
+	// IndexOrdered 额外加速 $gt/$gte/$lt/$lte、前缀 $regex 以及按索引字段排序。
+	// 单字段索引默认 IndexHash，复合索引默认 IndexOrdered
+	Kind string
+	// Name 索引名，仅对复合索引生效；默认由字段与方向生成，如 "tenant_1_created_at_-1"
+	Name string
+	// Sparse 为 true 时跳过缺少索引字段的文档（复合索引为全部字段都缺少）
+	Sparse bool
+	// PartialFilter 只索引满足该过滤条件的文档，语法与 Find 的过滤条件相同；
+	// 查询条件原样包含 PartialFilter 的全部顶层条件时才会使用该索引
+	PartialFilter map[string]interface{}
+	// TTL 为 true 时创建 TTL 索引（仅限单字段）：字段时间加上 ExpireAfter 之后文档由 DBManager 在后台删除；
+	// ExpireAfter 为 0 时字段本身即过期时间。TTL 索引未指定 Kind 时为有序索引
+	TTL         bool
+	ExpireAfter time.Duration
+}
+
+// IndexKey 复合索引中的一个字段
+type IndexKey struct {
+	Field string // 字段名，支持点号路径
+	Order int    // 1 升序（默认），-1 降序
+}
+
+// indexDefinition 根据字段名与可选参数生成单字段索引定义
+func indexDefinition(field string, opts []IndexOptions) (ConfigFile.IndexDefinition, error) {
+	return compoundDefinition([]IndexKey{{Field: field}}, opts)
+}
+
+// compoundDefinition 根据字段列表与可选参数生成索引定义
+func compoundDefinition(keys []IndexKey, opts []IndexOptions) (ConfigFile.IndexDefinition, error) {
+	var def ConfigFile.IndexDefinition
+	if len(keys) == 0 {
+		return def, errors.New("索引字段不能为空")
+	}
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if key.Field == "" {
+			return def, errors.New("索引字段不能为空")
+		}
+		if _, dup := seen[key.Field]; dup {
+			return def, errors.New("索引字段重复: " + key.Field)
+		}
+		seen[key.Field] = struct{}{}
+		order := 1
+		if key.Order < 0 {
+			order = -1
+		}
+		def.Fields = append(def.Fields, key.Field)
+		def.Orders = append(def.Orders, order)
+	}
+
+	def.Kind = IndexHash
+	if len(keys) > 1 {
+		def.Kind = IndexOrdered
+	}
+	if len(opts) > 0 {
+		opt := opts[0]
+		if opt.TTL {
+			if len(keys) > 1 {
+				return def, errors.New("TTL 索引只能包含一个字段")
+			}
+			if opt.ExpireAfter < 0 {
+				return def, errors.New("TTL 索引的过期时长不能为负数")
+			}
+			seconds := int64(opt.ExpireAfter / time.Second)
+			def.ExpireAfterSeconds = &seconds
+			def.Kind = IndexOrdered
+		}
+		if opt.Kind != "" {
+			def.Kind = opt.Kind
+		}
+		def.Sparse = opt.Sparse
+		def.PartialFilter = opt.PartialFilter
+	}
+	if def.Kind != IndexHash && def.Kind != IndexOrdered {
+		return def, errors.New("不支持的索引类型: " + def.Kind)
+	}
+	return def, nil
+}
+
+// compoundIndexName 复合索引的默认名称：字段与方向依次用 "_" 连接
+func compoundIndexName(def ConfigFile.IndexDefinition) string {
+	parts := make([]string, 0, len(def.Fields)*2)
+	for i, field := range def.Fields {
+		parts = append(parts, field, strconv.Itoa(def.Orders[i]))
+	}
+	return strings.Join(parts, "_")
+}
+
+// CreateIndex 为集合创建 单个普通索引，并用集合现有文档构建索引数据
 // - collectionName: 集合名
-// - index: 索引字段名
-func (db *DBContext) CreateIndex(collectionName string, index string) error {
-	err := Config.CreateIndex(db.CurrentDB, collectionName, index)
-	return writeSettingsError("CreateIndexConfig", err, "")
+// - index: 索引字段名（支持点号路径），同时作为索引名
+// - opts: 可选，指定索引类型，默认哈希索引
+func (db *DBContext) CreateIndex(collectionName string, index string, opts ...IndexOptions) error {
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	def, err := indexDefinition(index, opts)
+	if err == nil {
+		err = db.createIndex(collectionName, index, def)
+	}
+	return db.writeSettingsError("CreateIndex", err, index)
 }
 
-// DropIndex 删除集合的 单个普通索引
+// DropIndex 删除集合的 单个普通索引及其索引数据
 // - collectionName: 集合名
-// - index: 要删除的索引字段名
+// - index: 要删除的索引名
 func (db *DBContext) DropIndex(collectionName string, index string) error {
-	err := Config.DropIndex(db.CurrentDB, collectionName, index)
-	return writeSettingsError("DropIndexConfig", err, "")
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = db.dropIndex(collectionName, index)
+	return db.writeSettingsError("DropIndex", err, index)
 }
 
 // CreateIndexes 为集合批量创建 普通索引
 // - collectionName: 集合名
 // - indexes: 需要创建索引的字段列表
-func (db *DBContext) CreateIndexes(collectionName string, indexes []string) error {
-	err := Config.CreateIndexes(db.CurrentDB, collectionName, indexes)
-	return writeSettingsError("CreateIndexes", err, "")
+// - opts: 可选，指定索引类型，对全部字段生效
+func (db *DBContext) CreateIndexes(collectionName string, indexes []string, opts ...IndexOptions) error {
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, index := range indexes {
+		def, err := indexDefinition(index, opts)
+		if err == nil {
+			err = db.createIndex(collectionName, index, def)
+		}
+		if err != nil {
+			return db.writeSettingsError("CreateIndexes", err, index)
+		}
+	}
+	return nil
 }
 
 // DropIndexes 批量删除集合的 普通索引
 // - collectionName: 集合名
 // - indexes: 需要删除索引的字段列表
 func (db *DBContext) DropIndexes(collectionName string, indexes []string) error {
-	err := Config.DropIndexes(db.CurrentDB, collectionName, indexes)
-	return writeSettingsError("DropIndexes", err, "")
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, index := range indexes {
+		if err := db.dropIndex(collectionName, index); err != nil {
+			return db.writeSettingsError("DropIndexes", err, index)
+		}
+	}
+	return nil
+}
+
+// CreateCompoundIndex 为集合创建 复合索引，并用集合现有文档构建索引数据
+// - collectionName: 集合名
+// - keys: 按顺序排列的索引字段及方向
+// - opts: 可选，指定索引类型与索引名
+//
+// 查询时前若干个字段为等值条件、下一个字段为等值/$in/范围/前缀正则条件即可使用该索引，
+// 如索引 {tenant: 1, created_at: -1} 可用于 {"tenant": "a", "created_at": {"$gte": "2024-05"}}。
+func (db *DBContext) CreateCompoundIndex(collectionName string, keys []IndexKey, opts ...IndexOptions) error {
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	def, err := compoundDefinition(keys, opts)
+	name := ""
+	if err == nil {
+		name = compoundIndexName(def)
+		if len(opts) > 0 && opts[0].Name != "" {
+			name = opts[0].Name
+		}
+		err = db.createIndex(collectionName, name, def)
+	}
+	return db.writeSettingsError("CreateCompoundIndex", err, name)
+}
+
+// ErrIndexExists 同名索引已存在且定义不同（字段、方向、类型、稀疏、部分过滤条件或 TTL），需要修改时先删除原索引
+var ErrIndexExists = errors.New("索引已存在且选项不同")
+
+// createIndex 构建并保存索引数据，再写入索引定义；同名索引已存在且定义相同时不做任何操作，
+// 定义不同时返回 ErrIndexExists
+// 调用方需持有集合写锁
+func (db *DBContext) createIndex(collectionName, name string, def ConfigFile.IndexDefinition) error {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("索引名不能为空")
+	}
+	defs, err := db.catalog().GetIndexes(db.CurrentDB, collectionName)
+	if err != nil {
+		return err
+	}
+	if old, exists := defs[name]; exists {
+		if !sameIndexDefinition(name, old, def) {
+			return fmt.Errorf("%w: %s", ErrIndexExists, name)
+		}
+		return nil
+	}
+
+	data, err := db.store().LoadCollection(db.CurrentDB, collectionName)
+	if err != nil {
+		return err
+	}
+	idx := newCollectionIndex(name, def)
+	idx.build(data)
+	if err := db.persistIndexes(collectionName, indexSet{idx}); err != nil {
+		return err
+	}
+	return db.catalog().CreateIndexConfig(db.CurrentDB, collectionName, name, idx.def)
+}
+
+// sameIndexDefinition 两个索引定义是否相同：缺省的字段、方向与类型按 newCollectionIndex 补全后比较，不比较创建时间
+func sameIndexDefinition(name string, a, b ConfigFile.IndexDefinition) bool {
+	x, y := newCollectionIndex(name, a).def, newCollectionIndex(name, b).def
+	if !slices.Equal(x.Fields, y.Fields) || !slices.Equal(x.Orders, y.Orders) ||
+		x.Kind != y.Kind || x.Sparse != y.Sparse {
+		return false
+	}
+	if (x.ExpireAfterSeconds == nil) != (y.ExpireAfterSeconds == nil) ||
+		x.ExpireAfterSeconds != nil && *x.ExpireAfterSeconds != *y.ExpireAfterSeconds {
+		return false
+	}
+	// 元数据目录中读出的过滤条件数字为 float64，按值比较
+	return len(x.PartialFilter) == 0 && len(y.PartialFilter) == 0 || valuesEqual(x.PartialFilter, y.PartialFilter)
+}
+
+// dropIndex 删除索引定义与索引数据；索引不存在时不做任何操作
+// 调用方需持有集合写锁
+func (db *DBContext) dropIndex(collectionName, name string) error {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return err
+	}
+	defs, err := db.catalog().GetIndexes(db.CurrentDB, collectionName)
+	if err != nil {
+		return err
+	}
+	if _, exists := defs[name]; !exists {
+		return nil
+	}
+	if err := db.catalog().DropIndexConfig(db.CurrentDB, collectionName, name); err != nil {
+		return err
+	}
+	return db.store().DeleteIndex(db.CurrentDB, collectionName, name)
+}
+
+// ==================== 索引管理 ====================
+
+// IndexInfo 索引的定义与统计信息
+type IndexInfo struct {
+	Name       string                     // 索引名，唯一约束为约束名（如 "email+org_id"）
+	Unique     bool                       // 是否为唯一约束索引
+	Definition ConfigFile.IndexDefinition // 索引定义，Definition.Kind 为索引类型
+	Keys       int                        // 不同键的数量
+	Entries    int                        // 收录的文档数，稀疏、部分索引可能少于集合文档数
+	Size       int                        // 索引数据序列化后的字节数，即在存储中占用的大小
+	BuiltAt    string                     // 最近一次全量构建的时间
+}
+
+// IndexReport 单个索引的校验结果
+type IndexReport struct {
+	Name      string             // 索引名，唯一约束为约束名
+	Unique    bool               // 是否为唯一约束索引
+	Corrupt   bool               // 索引数据缺失、无法解析、与定义不一致或有序索引顺序错乱
+	Missing   []string           // 应被收录但索引中没有的文档 _id
+	Stale     []string           // 索引中多余、重复或键与文档当前值不一致的 _id
+	Duplicate *DuplicateKeyError // 唯一约束：集合中已存在重复值，重建索引无法修复
+	Fixed     bool               // 已按集合数据重建索引
+}
+
+// Healthy 索引内容与集合数据是否一致
+func (r IndexReport) Healthy() bool {
+	return !r.Corrupt && len(r.Missing) == 0 && len(r.Stale) == 0 && r.Duplicate == nil
+}
+
+// ListIndexes 列出集合的全部普通索引与唯一约束索引，按普通索引在前、名称升序排列
+// - collectionName: 集合名
+//
+// 索引数据缺失或损坏时与查询一样先重建再统计。
+func (db *DBContext) ListIndexes(collectionName string) ([]IndexInfo, error) {
+	unlock, err := db.readLock(collectionName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	infos, err := db.listIndexes(collectionName)
+	return infos, db.writeSettingsError("ListIndexes", err, collectionName)
+}
+
+// listIndexes 收集集合全部索引的统计信息
+// 调用方需持有集合读锁
+func (db *DBContext) listIndexes(collectionName string) ([]IndexInfo, error) {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return nil, err
+	}
+	data, err := db.store().LoadCollection(db.CurrentDB, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	set, err := db.loadIndexes(collectionName, data)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]IndexInfo, 0, len(set))
+	for _, idx := range set {
+		// 读锁下重建的索引尚未写回存储，按序列化后的内容统计大小
+		bytes, err := json.Marshal(idx.data)
+		if err != nil {
+			return nil, err
+		}
+		info := IndexInfo{
+			Name:       idx.name,
+			Unique:     idx.constraint != "",
+			Definition: idx.def,
+			Size:       len(bytes),
+			BuiltAt:    idx.data.BuiltAt,
+		}
+		if info.Unique {
+			info.Name = idx.constraint
+		}
+		if info.BuiltAt == "" {
+			// 记录构建时间之前生成的索引数据，以创建时间代替
+			info.BuiltAt = idx.def.CreateAt
+		}
+		info.Keys, info.Entries = idx.counts()
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// RebuildIndex 丢弃索引数据并按集合现有文档重新构建
+// - collectionName: 集合名
+// - name: 索引名或唯一约束名，同名的普通索引与唯一约束都会重建；为空时重建集合的全部索引
+//
+// 唯一约束重建后集合中仍存在重复值时返回 *DuplicateKeyError，索引数据已按实际内容保存。
+func (db *DBContext) RebuildIndex(collectionName string, name string) error {
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = db.rebuildIndex(collectionName, name)
+	return db.writeSettingsError("RebuildIndex", err, name)
+}
+
+// rebuildIndex 重建名称匹配的索引
+// 调用方需持有集合写锁
+func (db *DBContext) rebuildIndex(collectionName, name string) error {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return err
+	}
+	all, err := db.newIndexSet(collectionName)
+	if err != nil {
+		return err
+	}
+	var set indexSet
+	for _, idx := range all {
+		if name == "" || idx.name == name || idx.constraint == name {
+			set = append(set, idx)
+		}
+	}
+	if len(set) == 0 {
+		if name == "" {
+			return nil
+		}
+		return errors.New("Index 不存在: " + name)
+	}
+
+	data, err := db.store().LoadCollection(db.CurrentDB, collectionName)
+	if err != nil {
+		return err
+	}
+	for _, idx := range set {
+		idx.build(data)
+	}
+	if err := db.persistIndexes(collectionName, set); err != nil {
+		return err
+	}
+	for _, idx := range set {
+		if idx.constraint == "" {
+			continue
+		}
+		if err := idx.duplicate(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyIndexes 逐一比较集合全部索引的数据与集合文档，报告不一致之处
+// - collectionName: 集合名
+// - fix: 为 true 时重建不一致的索引
+//
+// 与 ListIndexes 不同，校验直接读取存储中的索引数据，不会先自动重建。
+func (db *DBContext) VerifyIndexes(collectionName string, fix bool) ([]IndexReport, error) {
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	reports, err := db.verifyIndexes(collectionName, fix)
+	return reports, db.writeSettingsError("VerifyIndexes", err, collectionName)
+}
+
+// verifyIndexes 校验集合全部索引，fix 为 true 时重建不一致的索引
+// 调用方需持有集合写锁
+func (db *DBContext) verifyIndexes(collectionName string, fix bool) ([]IndexReport, error) {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return nil, err
+	}
+	// 推迟保存的索引先写入存储，校验的是存储中的索引数据
+	if fs, ok := db.store().(FlushStorage); ok {
+		if err := fs.FlushCollection(db.CurrentDB, collectionName); err != nil {
+			return nil, err
+		}
+	}
+	set, err := db.newIndexSet(collectionName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := db.store().LoadCollection(db.CurrentDB, collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]IndexReport, 0, len(set))
+	for _, idx := range set {
+		bytes, err := db.store().LoadIndex(db.CurrentDB, collectionName, idx.name)
+		if err != nil {
+			return nil, err
+		}
+		report := IndexReport{Name: idx.name, Unique: idx.constraint != ""}
+		if report.Unique {
+			report.Name = idx.constraint
+		}
+
+		var stored indexData
+		report.Corrupt = len(bytes) == 0 || json.Unmarshal(bytes, &stored) != nil ||
+			!stored.valid(idx.def) || idx.ordered() && !idx.inOrder(stored.Ordered)
+		idx.build(data)
+		if !report.Corrupt {
+			report.Missing, report.Stale = idx.diff(stored)
+		}
+		if report.Unique {
+			var dupErr *DuplicateKeyError
+			if errors.As(idx.duplicate(data), &dupErr) {
+				report.Duplicate = dupErr
+			}
+		}
+
+		drifted := report.Corrupt || len(report.Missing) > 0 || len(report.Stale) > 0
+		if fix && drifted {
+			if err := db.persistIndexes(collectionName, indexSet{idx}); err != nil {
+				return nil, err
+			}
+			report.Fixed = true
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
 }
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/StephenChristianW/JsonDB/config"
+)
+
+// TestCreateIndexExisting 同名索引已存在时：定义相同不做任何操作，定义不同返回 ErrIndexExists
+func TestCreateIndexExisting(t *testing.T) {
+	db := NewDBContext("", "", NewFileStorage(config.Options{RootDir: t.TempDir()}.Normalize()))
+	if err := db.DBCreate("idx"); err != nil {
+		t.Fatalf("DBCreate: %v", err)
+	}
+	db.CurrentDB = "idx"
+	if err := db.CollectionCreate("c"); err != nil {
+		t.Fatalf("CollectionCreate: %v", err)
+	}
+
+	partial := IndexOptions{PartialFilter: map[string]interface{}{"n": map[string]interface{}{"$gt": 5}}}
+	if err := db.CreateIndex("c", "a"); err != nil {
+		t.Fatalf("CreateIndex a: %v", err)
+	}
+	if err := db.CreateIndex("c", "b", partial); err != nil {
+		t.Fatalf("CreateIndex b: %v", err)
+	}
+	ttl := IndexOptions{TTL: true, ExpireAfter: time.Hour}
+	if err := db.CreateIndex("c", "t", ttl); err != nil {
+		t.Fatalf("CreateIndex t: %v", err)
+	}
+	keys := []IndexKey{{Field: "x"}, {Field: "y", Order: -1}}
+	if err := db.CreateCompoundIndex("c", keys); err != nil {
+		t.Fatalf("CreateCompoundIndex: %v", err)
+	}
+
+	same := []struct {
+		name   string
+		create func() error
+	}{
+		{"a", func() error { return db.CreateIndex("c", "a") }},
+		{"a 显式指定默认类型", func() error { return db.CreateIndex("c", "a", IndexOptions{Kind: IndexHash}) }},
+		{"b", func() error { return db.CreateIndex("c", "b", partial) }},
+		{"t", func() error { return db.CreateIndex("c", "t", ttl) }},
+		{"x_1_y_-1", func() error { return db.CreateCompoundIndex("c", keys) }},
+	}
+	for _, c := range same {
+		if err := c.create(); err != nil {
+			t.Errorf("%s: 定义相同时返回 %v", c.name, err)
+		}
+	}
+
+	differ := []struct {
+		name   string
+		create func() error
+	}{
+		{"类型", func() error { return db.CreateIndex("c", "a", IndexOptions{Kind: IndexOrdered}) }},
+		{"稀疏", func() error { return db.CreateIndex("c", "a", IndexOptions{Sparse: true}) }},
+		{"部分过滤条件", func() error {
+			return db.CreateIndex("c", "b", IndexOptions{PartialFilter: map[string]interface{}{"n": map[string]interface{}{"$gt": 6}}})
+		}},
+		{"TTL", func() error { return db.CreateIndex("c", "t", IndexOptions{TTL: true, ExpireAfter: time.Minute}) }},
+		{"非 TTL", func() error { return db.CreateIndex("c", "t", IndexOptions{Kind: IndexOrdered}) }},
+		{"方向", func() error {
+			return db.CreateCompoundIndex("c", []IndexKey{{Field: "x"}, {Field: "y"}}, IndexOptions{Name: "x_1_y_-1"})
+		}},
+	}
+	for _, c := range differ {
+		if err := c.create(); !errors.Is(err, ErrIndexExists) {
+			t.Errorf("%s: 定义不同时返回 %v，应为 ErrIndexExists", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,944 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/StephenChristianW/JsonDB/config"
+	"github.com/StephenChristianW/JsonDB/fileIO"
+	ConfigFile "github.com/StephenChristianW/JsonDB/fileIO/configFileIO"
+	UtilsFile "github.com/StephenChristianW/JsonDB/utils/file"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const fileStoragePath = "JsonDB/services/fileStorage.go"
+
+// indexDirName 根目录下存放索引数据的目录，数据库不能使用该名称
+const indexDirName = "index"
+
+// lockDirName 根目录下存放跨进程文件锁的目录
+const lockDirName = ".locks"
+
+// FileStorage JSON 目录存储后端
+//
+// 目录结构：
+//
+//	<root>/.config                       元数据目录
+//	<root>/<db>/<collection>.json        集合文档（FormatJSON）
+//	<root>/<db>/<collection>.jsonl       集合文档（FormatJSONL），见 collectionFormat.go
+//	<root>/<db>/.wal                     数据库预写日志
+//	<root>/index/<db>/<collection>/*.index 索引数据
+//	<root>/.locks/                       跨进程文件锁，见 lockManager.go
+//
+// 已解析的集合缓存在内存中，写入可以按数据库的落盘策略延迟写入集合文件，见 collectionCache.go。
+type FileStorage struct {
+	root  string
+	opts  config.Options
+	cache *rootCache // 同一根目录的实例共用
+}
+
+// NewFileStorage 按配置创建 JSON 目录存储，零值字段使用默认值（见 config.Options）
+//
+// 不做任何 I/O，根目录在第一次写入时创建；使用前应调用 Recover 重放残留的 WAL（NewDBManager 会自动调用）。
+func NewFileStorage(opts config.Options) *FileStorage {
+	opts = opts.Normalize()
+	s := &FileStorage{root: opts.RootDir, opts: opts}
+	s.cache = getRootCache(s)
+	return s
+}
+
+// RootDir 数据根目录
+func (s *FileStorage) RootDir() string {
+	return s.root
+}
+
+// ---------------- 路径 ----------------
+
+func (s *FileStorage) dbPath(dbName string) string {
+	return filepath.Join(s.root, dbName)
+}
+
+func (s *FileStorage) collectionPath(dbName, collectionName string) string {
+	return filepath.Join(s.root, dbName, collectionName+".json")
+}
+
+func (s *FileStorage) logPath(dbName, collectionName string) string {
+	return filepath.Join(s.root, dbName, collectionName+".jsonl")
+}
+
+// collectionFile 集合文件的路径与格式；两种文件同时存在（转换格式时异常退出）时以日志格式为准
+func (s *FileStorage) collectionFile(dbName, collectionName string) (string, CollectionFormat) {
+	if path := s.logPath(dbName, collectionName); UtilsFile.IsPathExist(path) {
+		return path, FormatJSONL
+	}
+	return s.collectionPath(dbName, collectionName), FormatJSON
+}
+
+func (s *FileStorage) docLog(dbName, collectionName string) *fileIO.DocLog {
+	return fileIO.GetDocLog(s.logPath(dbName, collectionName), s.opts.FileMode)
+}
+
+func (s *FileStorage) indexDBPath(dbName string) string {
+	return filepath.Join(s.root, indexDirName, dbName)
+}
+
+func (s *FileStorage) indexCollectionPath(dbName, collectionName string) string {
+	return filepath.Join(s.indexDBPath(dbName), collectionName)
+}
+
+// indexPath 索引名可能包含 "." "$" 等字符，转义后作为文件名
+func (s *FileStorage) indexPath(dbName, collectionName, name string) string {
+	return filepath.Join(s.indexCollectionPath(dbName, collectionName), url.QueryEscape(name)+".index")
+}
+
+// ---------------- 数据库 ----------------
+
+func (s *FileStorage) CreateDB(dbName string) error {
+	return fileIO.CreateDirectoryMode(s.dbPath(dbName), s.opts.DirMode)
+}
+
+func (s *FileStorage) DeleteDB(dbName string) error {
+	// 先落盘，使 WAL 中不再有该数据库待处理的记录
+	if err := s.flushDB(dbName); err != nil {
+		return err
+	}
+	defer s.cache.dropDB(dbName)
+	if err := os.RemoveAll(s.dbPath(dbName)); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.indexDBPath(dbName))
+}
+
+func (s *FileStorage) RenameDB(oldName, newName string) error {
+	if err := s.flushDB(oldName); err != nil {
+		return err
+	}
+	defer s.cache.dropDB(oldName)
+	if err := os.Rename(s.dbPath(oldName), s.dbPath(newName)); err != nil {
+		return err
+	}
+	return s.renameIfExist(s.indexDBPath(oldName), s.indexDBPath(newName))
+}
+
+func (s *FileStorage) ListDBs() ([]string, error) {
+	dirs, err := os.ReadDir(s.root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var dbNames []string
+	for _, dir := range dirs {
+		// 跳过索引目录以及 .locks 等内部目录
+		if dir.IsDir() && dir.Name() != indexDirName && !strings.HasPrefix(dir.Name(), ".") {
+			dbNames = append(dbNames, dir.Name())
+		}
+	}
+	return dbNames, nil
+}
+
+func (s *FileStorage) DBExists(dbName string) bool {
+	return UtilsFile.IsPathExist(s.dbPath(dbName))
+}
+
+// ---------------- 集合 ----------------
+
+func (s *FileStorage) CreateCollection(dbName, collectionName string) error {
+	return fileIO.WriteFileAtomic(s.collectionPath(dbName, collectionName), []byte("{}"), s.opts.FileMode)
+}
+
+func (s *FileStorage) DeleteCollection(dbName, collectionName string) error {
+	if err := s.FlushCollection(dbName, collectionName); err != nil {
+		return err
+	}
+	defer s.cache.drop(cacheKey{dbName, collectionName})
+	// 两种格式的文件都删除，转换格式时异常退出可能留下两个文件
+	if err := os.Remove(s.logPath(dbName, collectionName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(s.collectionPath(dbName, collectionName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(s.indexCollectionPath(dbName, collectionName))
+}
+
+func (s *FileStorage) RenameCollection(dbName, oldName, newName string) error {
+	if err := s.FlushCollection(dbName, oldName); err != nil {
+		return err
+	}
+	defer s.cache.drop(cacheKey{dbName, oldName}, cacheKey{dbName, newName})
+	oldPath, format := s.collectionFile(dbName, oldName)
+	newPath := s.collectionPath(dbName, newName)
+	if format == FormatJSONL {
+		newPath = s.logPath(dbName, newName)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	return s.renameIfExist(s.indexCollectionPath(dbName, oldName), s.indexCollectionPath(dbName, newName))
+}
+
+func (s *FileStorage) ListCollections(dbName string) ([]string, error) {
+	files, err := os.ReadDir(s.dbPath(dbName))
+	if err != nil {
+		return nil, err
+	}
+	nameSlice := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, file := range files {
+		// 只有 .json / .jsonl 文件是集合，跳过 WAL 等内部文件
+		if file.IsDir() {
+			continue
+		}
+		name, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			name, ok = strings.CutSuffix(file.Name(), ".jsonl")
+		}
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		nameSlice = append(nameSlice, name)
+	}
+	return nameSlice, nil
+}
+
+func (s *FileStorage) CollectionExists(dbName, collectionName string) bool {
+	path, _ := s.collectionFile(dbName, collectionName)
+	return UtilsFile.IsPathExist(path)
+}
+
+// LoadCollection 读取集合全部文档，集合文件没有变化时直接使用缓存
+func (s *FileStorage) LoadCollection(dbName, collectionName string) (map[string]Document, error) {
+	data, err := s.loadShared(dbName, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	return copyCollection(data), nil
+}
+
+// loadShared 与 LoadCollection 相同，但直接返回缓存中的文档映射，见 sharedLoader
+func (s *FileStorage) loadShared(dbName, collectionName string) (map[string]Document, error) {
+	key := cacheKey{dbName, collectionName}
+	colPath, format := s.collectionFile(dbName, collectionName)
+	// 先取文件信息再读取：读取期间文件被替换时缓存记下的是旧信息，下次读取会重新加载
+	info, err := os.Stat(colPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if data, ok := s.cache.get(key, info); ok {
+		return data, nil
+	}
+	data, err := s.readCollection(dbName, collectionName, colPath, format)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		s.cache.put(key, data, info)
+	}
+	return data, nil
+}
+
+// readCollection 从集合文件解析全部文档
+func (s *FileStorage) readCollection(dbName, collectionName, colPath string, format CollectionFormat) (map[string]Document, error) {
+	if format == FormatJSONL {
+		docs, err := s.docLog(dbName, collectionName).Load()
+		if err != nil {
+			return nil, err
+		}
+		data := make(map[string]Document, len(docs))
+		for id, doc := range docs {
+			data[id] = doc
+		}
+		return data, nil
+	}
+	data := make(map[string]Document)
+	if UtilsFile.IsPathExist(colPath) {
+		bytes, err := os.ReadFile(colPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes) > 0 {
+			if err := json.Unmarshal(bytes, &data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
+
+// Commit 提交一次写操作
+//
+// 先把全部变更作为一条记录追加到数据库的 WAL 并 fsync，再依次原子写集合文件和索引数据，最后做检查点。
+// 集合文件一个都没写成功时作废该条日志，调用方收到错误且变更不会在重放时生效；
+// 部分集合已写入时提交已经生效，其余集合改为延迟落盘并返回 nil，见 rollForward。
+// 索引数据写入失败时删除该索引文件，读取时自动重建。
+// 数据库使用延迟落盘策略时集合文件的写入推迟到落盘时，见 commitDeferred。
+// 数据为 nil 的索引先删除已保存的数据，落盘时再保存，见 metaDeferrer。
+//
+// 提交后 writes 中的 Data 会被缓存，调用方不能再修改。
+func (s *FileStorage) Commit(dbName string, writes ...CollectionWrite) error {
+	for _, w := range writes {
+		if err := s.deferIndexes(dbName, w); err != nil {
+			return err
+		}
+	}
+	policy := s.cache.policy(dbName)
+	if !policy.Immediate() || s.cache.anyDirty(dbName, writes) {
+		return s.commitDeferred(dbName, policy, writes)
+	}
+
+	ops := walOps(writes)
+	if len(ops) == 0 {
+		for _, w := range writes {
+			if _, err := s.applyWrite(dbName, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	wal := s.wal(dbName)
+	seq, err := wal.Append(ops)
+	if err != nil {
+		return err
+	}
+	written := false
+	for i, w := range writes {
+		wrote, err := s.applyWrite(dbName, w)
+		if err == nil {
+			written = written || wrote
+			continue
+		}
+		if !written {
+			_ = wal.Abort(seq)
+			for _, w := range writes {
+				s.cache.drop(cacheKey{dbName, w.Collection})
+			}
+			return err
+		}
+		s.LogError(err.Error()+" | msg: "+dbName+"."+w.Collection, "Commit", fileStoragePath)
+		s.stored(dbName, writes[:i])
+		s.rollForward(dbName, seq, writes[i:])
+		return nil
+	}
+	s.stored(dbName, writes)
+	return wal.Checkpoint(seq)
+}
+
+// rollForward 部分集合文件已写入后其余集合写入失败时完成提交
+//
+// WAL 记录已经 fsync，且已有集合文件包含这次写入，作废记录会让已写入的集合与其余集合不一致，
+// 因此其余集合按延迟落盘处理：缓存中保留写入后的文档并持有集合的文件锁，立即重试一次，之后每隔
+// config.DefaultFlushDelay 重试，全部写入后做检查点。进程在此之前退出时由 Recover 重放该记录。
+func (s *FileStorage) rollForward(dbName string, seq uint64, writes []CollectionWrite) {
+	retry := config.FlushPolicy{Interval: config.DefaultFlushDelay}
+	var dirty []string
+	for _, w := range writes {
+		s.saveIndexes(dbName, w)
+		if len(w.Changes) == 0 || w.Data == nil {
+			continue
+		}
+		key := cacheKey{dbName, w.Collection}
+		s.cache.markDirty(key, w, seq, retry)
+		if err := s.cache.retain(s, key); err != nil {
+			s.LogError(err.Error()+" | msg: "+dbName+"."+w.Collection, "rollForward", fileStoragePath)
+		}
+		dirty = append(dirty, w.Collection)
+	}
+	for _, name := range dirty {
+		if err := s.FlushCollection(dbName, name); err != nil {
+			s.LogError(err.Error()+" | msg: "+dbName+"."+name, "rollForward", fileStoragePath)
+		}
+	}
+}
+
+// commitDeferred 延迟落盘：只追加 WAL、写索引数据并更新缓存，集合文件在落盘时写入
+//
+// 累计写入次数达到 FlushPolicy.Writes 时立即落盘；集合之前有未落盘的写入而策略已改为立即落盘时同样立即落盘。
+// 变更已经写入 WAL，落盘失败只记录错误，之后再次尝试。
+func (s *FileStorage) commitDeferred(dbName string, policy config.FlushPolicy, writes []CollectionWrite) error {
+	var seq uint64
+	if ops := walOps(writes); len(ops) > 0 {
+		var err error
+		if seq, err = s.wal(dbName).Append(ops); err != nil {
+			return err
+		}
+	}
+
+	var flush []string
+	for _, w := range writes {
+		s.saveIndexes(dbName, w)
+		if len(w.Changes) == 0 || w.Data == nil {
+			continue
+		}
+		key := cacheKey{dbName, w.Collection}
+		writesSinceFlush := s.cache.markDirty(key, w, seq, policy)
+		if err := s.cache.retain(s, key); err != nil || policy.Immediate() ||
+			(policy.Writes > 0 && writesSinceFlush >= policy.Writes) {
+			flush = append(flush, w.Collection)
+		}
+	}
+	for _, name := range flush {
+		if err := s.FlushCollection(dbName, name); err != nil {
+			s.LogError(err.Error()+" | msg: "+dbName+"."+name, "commitDeferred", fileStoragePath)
+		}
+	}
+	return nil
+}
+
+// walOps 把各集合的变更展开为 WAL 记录中的操作
+func walOps(writes []CollectionWrite) []fileIO.WALOp {
+	var ops []fileIO.WALOp
+	for _, w := range writes {
+		for _, c := range w.Changes {
+			ops = append(ops, fileIO.WALOp{Op: c.Op, Collection: w.Collection, ID: c.ID, Doc: c.Doc})
+		}
+	}
+	return ops
+}
+
+func (s *FileStorage) wal(dbName string) *fileIO.WAL {
+	return fileIO.GetWAL(s.dbPath(dbName), s.opts.FileMode, s.opts.LockTimeout)
+}
+
+// applyWrite 写入一个集合的集合文件与索引数据，返回是否写入了集合文件
+//
+// 日志格式的集合只追加本次变更，追加后失效记录过多时压缩。
+func (s *FileStorage) applyWrite(dbName string, w CollectionWrite) (bool, error) {
+	wrote := false
+	if _, format := s.collectionFile(dbName, w.Collection); format == FormatJSONL && len(w.Changes) > 0 {
+		if err := s.injectedFault(dbName, w.Collection); err != nil {
+			return false, err
+		}
+		if err := s.appendChanges(dbName, w.Collection, w.Changes); err != nil {
+			return false, err
+		}
+		wrote = true
+		s.autoCompact(dbName, w.Collection, w.Data)
+	} else if w.Data != nil {
+		if err := s.injectedFault(dbName, w.Collection); err != nil {
+			return false, err
+		}
+		if err := s.writeCollection(dbName, w.Collection, w.Data); err != nil {
+			return false, err
+		}
+		wrote = true
+	}
+	s.saveIndexes(dbName, w)
+	return wrote, nil
+}
+
+// writeFault 测试用：非 nil 时在提交或落盘写集合文件之前调用，返回的错误作为写入失败
+var writeFault func(dbName, collectionName string) error
+
+func (s *FileStorage) injectedFault(dbName, collectionName string) error {
+	if writeFault == nil {
+		return nil
+	}
+	return writeFault(dbName, collectionName)
+}
+
+// saveIndexes 保存 w 中的索引数据，写入失败时删除该索引文件，读取时自动重建；数据为 nil 的索引已由 deferIndexes 处理
+func (s *FileStorage) saveIndexes(dbName string, w CollectionWrite) {
+	for name, data := range w.Indexes {
+		if data == nil {
+			continue
+		}
+		if err := s.SaveIndex(dbName, w.Collection, name, data); err != nil {
+			_ = s.DeleteIndex(dbName, w.Collection, name)
+		}
+	}
+}
+
+// writeCollection 按集合当前的格式写入全部文档，日志格式即压缩
+func (s *FileStorage) writeCollection(dbName, collectionName string, data map[string]Document) error {
+	if _, format := s.collectionFile(dbName, collectionName); format == FormatJSONL {
+		return s.writeLog(dbName, collectionName, data)
+	}
+	return s.writeJSON(dbName, collectionName, data)
+}
+
+func (s *FileStorage) writeJSON(dbName, collectionName string, data map[string]Document) error {
+	bytes, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileIO.WriteFileAtomic(s.collectionPath(dbName, collectionName), bytes, s.opts.FileMode)
+}
+
+func (s *FileStorage) writeLog(dbName, collectionName string, data map[string]Document) error {
+	docs := make(map[string]map[string]interface{}, len(data))
+	for id, doc := range data {
+		docs[id] = doc
+	}
+	return s.docLog(dbName, collectionName).Rewrite(docs)
+}
+
+// appendChanges 把变更追加到日志格式集合的文件末尾
+func (s *FileStorage) appendChanges(dbName, collectionName string, changes []Change) error {
+	ops := make([]fileIO.WALOp, len(changes))
+	for i, c := range changes {
+		ops[i] = fileIO.WALOp{Op: c.Op, ID: c.ID, Doc: c.Doc}
+	}
+	return s.docLog(dbName, collectionName).Append(ops)
+}
+
+// autoCompact 失效记录数达到 Options.CompactThreshold 且不少于有效文档数时压缩日志
+//
+// 变更已经落盘，压缩失败只记录错误，下次写入时再尝试。
+func (s *FileStorage) autoCompact(dbName, collectionName string, data map[string]Document) {
+	if data == nil || s.opts.CompactThreshold < 0 {
+		return
+	}
+	live, records, err := s.docLog(dbName, collectionName).Counts()
+	if err != nil {
+		return
+	}
+	if dead := records - live; dead < s.opts.CompactThreshold || dead < live {
+		return
+	}
+	if err := s.writeLog(dbName, collectionName, data); err != nil {
+		s.LogError(err.Error()+" | msg: "+dbName+"."+collectionName, "autoCompact", fileStoragePath)
+	}
+}
+
+// ---------------- 集合格式 ----------------
+
+// CollectionFormat 集合当前的文件格式
+func (s *FileStorage) CollectionFormat(dbName, collectionName string) (CollectionFormat, error) {
+	path, format := s.collectionFile(dbName, collectionName)
+	if !UtilsFile.IsPathExist(path) {
+		return "", errors.New("集合不存在: " + collectionName)
+	}
+	return format, nil
+}
+
+// ConvertCollection 把集合转换为 format 格式：先完整写入新格式的文件，再删除旧文件
+func (s *FileStorage) ConvertCollection(dbName, collectionName string, format CollectionFormat) error {
+	if err := format.check(); err != nil {
+		return err
+	}
+	if err := s.FlushCollection(dbName, collectionName); err != nil {
+		return err
+	}
+	current, err := s.CollectionFormat(dbName, collectionName)
+	if err != nil || current == format {
+		return err
+	}
+	data, err := s.LoadCollection(dbName, collectionName)
+	if err != nil {
+		return err
+	}
+	if format == FormatJSONL {
+		if err := s.writeLog(dbName, collectionName, data); err != nil {
+			return err
+		}
+		return os.Remove(s.collectionPath(dbName, collectionName))
+	}
+	if err := s.writeJSON(dbName, collectionName, data); err != nil {
+		return err
+	}
+	return os.Remove(s.logPath(dbName, collectionName))
+}
+
+// CompactCollection 压缩日志格式集合，只保留每篇文档的最新内容；JSON 格式的集合不需要压缩
+func (s *FileStorage) CompactCollection(dbName, collectionName string) error {
+	format, err := s.CollectionFormat(dbName, collectionName)
+	if err != nil || format != FormatJSONL {
+		return err
+	}
+	if err := s.FlushCollection(dbName, collectionName); err != nil {
+		return err
+	}
+	data, err := s.LoadCollection(dbName, collectionName)
+	if err != nil {
+		return err
+	}
+	return s.writeLog(dbName, collectionName, data)
+}
+
+// ---------------- 落盘 ----------------
+
+// FlushPolicy 数据库当前的落盘策略
+func (s *FileStorage) FlushPolicy(dbName string) config.FlushPolicy {
+	return s.cache.policy(dbName)
+}
+
+// SetFlushPolicy 修改数据库的落盘策略，同一根目录上的实例共用；已有的未落盘写入按原策略落盘
+func (s *FileStorage) SetFlushPolicy(dbName string, policy config.FlushPolicy) {
+	s.cache.setPolicy(dbName, policy)
+}
+
+// Unflushed 数据库中有未落盘写入的集合，按名称升序
+func (s *FileStorage) Unflushed(dbName string) []string {
+	return s.cache.unflushed(dbName)
+}
+
+// FlushCollection 把集合未落盘的写入写入集合文件，再对相应的 WAL 记录做检查点，
+// 最后保存推迟写入的文档数量与索引数据（见 saveMeta）
+//
+// JSON 格式写入全部文档，日志格式只追加变更过的文档。调用方需持有集合写锁或数据库写锁。
+func (s *FileStorage) FlushCollection(dbName, collectionName string) error {
+	key := cacheKey{dbName, collectionName}
+	data, changed, ok := s.cache.dirtyState(key)
+	if !ok {
+		s.saveMeta(key, false)
+		return nil
+	}
+	if err := s.injectedFault(dbName, collectionName); err != nil {
+		return err
+	}
+	if _, format := s.collectionFile(dbName, collectionName); format == FormatJSONL {
+		changes := make([]Change, len(changed))
+		for i, id := range changed {
+			if doc, exists := data[id]; exists {
+				changes[i] = putChange(id, doc)
+			} else {
+				changes[i] = deleteChange(id)
+			}
+		}
+		if err := s.appendChanges(dbName, collectionName, changes); err != nil {
+			return err
+		}
+		s.autoCompact(dbName, collectionName, data)
+	} else if err := s.writeJSON(dbName, collectionName, data); err != nil {
+		return err
+	}
+
+	path, _ := s.collectionFile(dbName, collectionName)
+	info, err := os.Stat(path)
+	if err != nil {
+		info = nil
+	}
+	wal := s.wal(dbName)
+	done := s.cache.markClean(key, info)
+	s.saveMeta(key, true)
+	for _, seq := range done {
+		if err := wal.Checkpoint(seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushDB 落盘数据库中全部集合，调用方需持有数据库写锁
+func (s *FileStorage) flushDB(dbName string) error {
+	for _, name := range s.Unflushed(dbName) {
+		if err := s.FlushCollection(dbName, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushAll 落盘根目录中全部数据库，调用方需持有根目录写锁（见 lockManager.root）
+func (s *FileStorage) flushAll() error {
+	dbNames, err := s.ListDBs()
+	if err != nil {
+		return err
+	}
+	for _, dbName := range dbNames {
+		if err := s.flushDB(dbName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------------- 元数据目录 ----------------
+
+func (s *FileStorage) ReadCatalog() ([]byte, error) {
+	data, err := os.ReadFile(s.opts.ConfigFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *FileStorage) WriteCatalog(data []byte) error {
+	if err := fileIO.CreateDirectoryMode(s.root, s.opts.DirMode); err != nil {
+		return err
+	}
+	return fileIO.WriteFileAtomic(s.opts.ConfigFilePath(), data, s.opts.FileMode)
+}
+
+// LockCatalog 获得元数据目录的排他文件锁，见 configFileIO.CatalogLocker
+func (s *FileStorage) LockCatalog() (*fileIO.FileLock, error) {
+	return s.lockFile(fileIO.LockExclusive, ".config.lock")
+}
+
+// ---------------- 索引 ----------------
+
+func (s *FileStorage) LoadIndex(dbName, collectionName, name string) ([]byte, error) {
+	data, err := os.ReadFile(s.indexPath(dbName, collectionName, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *FileStorage) SaveIndex(dbName, collectionName, name string, data []byte) error {
+	if err := fileIO.CreateDirectoryMode(s.indexCollectionPath(dbName, collectionName), s.opts.DirMode); err != nil {
+		return err
+	}
+	return fileIO.WriteFileAtomic(s.indexPath(dbName, collectionName, name), data, s.opts.FileMode)
+}
+
+func (s *FileStorage) DeleteIndex(dbName, collectionName, name string) error {
+	err := os.Remove(s.indexPath(dbName, collectionName, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// cachedIndexes 缓存中的已解析索引，见 indexCache
+func (s *FileStorage) cachedIndexes(dbName, collectionName string) indexSet {
+	return s.cache.indexes(cacheKey{dbName, collectionName})
+}
+
+// cacheIndexes 随缓存的集合一起缓存已解析索引，见 indexCache
+func (s *FileStorage) cacheIndexes(dbName, collectionName string, set indexSet) {
+	s.cache.setIndexes(cacheKey{dbName, collectionName}, set)
+}
+
+// ---------------- 推迟写入的元数据 ----------------
+
+// defersMeta 延迟落盘的数据库，以及已缓存的日志格式集合，推迟写入文档数量与索引数据，见 metaDeferrer
+func (s *FileStorage) defersMeta(dbName, collectionName string) bool {
+	if !s.cache.policy(dbName).Immediate() {
+		return true
+	}
+	_, format := s.collectionFile(dbName, collectionName)
+	return format == FormatJSONL && s.cache.cached(cacheKey{dbName, collectionName})
+}
+
+// deferCount 推迟写入文档数量，见 metaDeferrer
+func (s *FileStorage) deferCount(dbName, collectionName string, count int) bool {
+	return s.defersMeta(dbName, collectionName) && s.cache.pendCount(cacheKey{dbName, collectionName}, count)
+}
+
+// deferIndexes 推迟保存 w 中数据为 nil 的索引：先删除已保存的旧数据，避免与集合文件不一致，落盘时再保存
+func (s *FileStorage) deferIndexes(dbName string, w CollectionWrite) error {
+	key := cacheKey{dbName, w.Collection}
+	for name, data := range w.Indexes {
+		if data != nil || s.cache.pendingIndex(key, name) {
+			continue
+		}
+		if err := s.DeleteIndex(dbName, w.Collection, name); err != nil {
+			return err
+		}
+		s.cache.pendIndex(key, name)
+	}
+	return nil
+}
+
+// saveMeta 保存推迟写入的文档数量与索引数据，调用方需持有集合写锁
+// - trusted: 集合刚落盘，缓存与集合文件一致
+//
+// trusted 为 false 时，集合文件在记录之后被其他进程改写过则全部丢弃：文档数量由其他进程写入，
+// 索引在读取时重建。元数据只是辅助信息，保存失败只记录错误，索引文件缺失时同样会重建。
+func (s *FileStorage) saveMeta(key cacheKey, trusted bool) {
+	p := s.cache.takePending(key)
+	if p == nil {
+		return
+	}
+	if !trusted {
+		path, _ := s.collectionFile(key.db, key.collection)
+		if info, err := os.Stat(path); err != nil || !sameFile(p.info, info) {
+			return
+		}
+	}
+	logError := func(err error) {
+		s.LogError(err.Error()+" | msg: "+key.db+"."+key.collection, "saveMeta", fileStoragePath)
+	}
+	if p.counted {
+		if err := ConfigFile.NewCatalog(s).UpdateCollectionStats(key.db, key.collection, p.count); err != nil {
+			logError(err)
+		}
+	}
+	for _, idx := range s.cache.indexes(key) {
+		if _, ok := p.indexes[idx.name]; !ok {
+			continue
+		}
+		bytes, err := json.Marshal(idx.data)
+		if err == nil {
+			err = s.SaveIndex(key.db, key.collection, idx.name, bytes)
+		}
+		if err != nil {
+			logError(err)
+		}
+	}
+}
+
+// ---------------- WAL 恢复 ----------------
+
+// Recover 重放所有数据库中残留的 WAL 记录
+//
+// 上次进程在写 WAL 之后、检查点之前退出时，集合文件可能缺少已提交的变更。
+// 这里把这些变更重新应用到集合文件（原子写），刷新文档数量，然后清空日志。
+// 其他进程有延迟落盘的写入时等待其落盘，超过 Options.LockTimeout 返回 *fileIO.LockedError。
+func (s *FileStorage) Recover() error {
+	rootMu := locks.root(s)
+	rootMu.Lock()
+	defer rootMu.Unlock()
+
+	if !UtilsFile.IsPathExist(s.root) {
+		return nil
+	}
+	// 本进程中其他实例尚未落盘的写入先写入集合文件，重放时就不会与缓存不一致
+	if err := s.flushAll(); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(s.opts.LockTimeout)
+	for {
+		err := s.tryRecover()
+		var locked *fileIO.LockedError
+		if !errors.As(err, &locked) || !time.Now().Before(deadline) {
+			return err
+		}
+		time.Sleep(recoverRetryInterval)
+	}
+}
+
+// recoverRetryInterval 其他进程持有 WAL 共享锁时 Recover 重试的间隔
+const recoverRetryInterval = 10 * time.Millisecond
+
+// tryRecover 持有根目录与全部 WAL 的排他锁重放日志；WAL 被其他进程占用时不等待，返回 *fileIO.LockedError
+//
+// 其他进程的操作都持有根目录共享锁，拿到根目录排他锁时没有进行中的操作；但延迟落盘的进程在操作之间
+// 仍持有 WAL 的共享锁，其记录尚未检查点，不能重放或清空。拿不到 WAL 排他锁时释放全部锁，
+// 不阻塞其他进程的普通操作，由 Recover 稍后重试；对方最多 FlushPolicy.Delay 后落盘并释放共享锁。
+func (s *FileStorage) tryRecover() error {
+	var held heldLocks
+	defer func() { held.release() }()
+	if err := held.file(s.lockRoot(fileIO.LockExclusive)); err != nil {
+		return err
+	}
+	dbNames, err := s.ListDBs()
+	if err != nil {
+		return err
+	}
+	for _, dbName := range dbNames {
+		if err := held.file(s.wal(dbName).LockExclusive(0)); err != nil {
+			return err
+		}
+	}
+	for _, dbName := range dbNames {
+		if err := s.replayDB(dbName); err != nil {
+			s.LogError(err.Error()+" | msg: "+dbName, "Recover", fileStoragePath)
+			return err
+		}
+	}
+	return nil
+}
+
+// replayDB 重放单个数据库的 WAL，调用方需持有根目录与该数据库 WAL 的排他锁
+//
+// 此时其他进程都没有待处理的记录，日志中剩下的记录都来自异常退出的进程。
+func (s *FileStorage) replayDB(dbName string) error {
+	wal := s.wal(dbName)
+	records, err := wal.Records()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return wal.Reset()
+	}
+
+	// 按集合汇总，每个集合只读写一次
+	collections := make(map[string]map[string]Document)
+	for _, rec := range records {
+		for _, op := range rec.Ops {
+			data, ok := collections[op.Collection]
+			if !ok {
+				// 集合已被删除，跳过其残留变更
+				if !s.CollectionExists(dbName, op.Collection) {
+					continue
+				}
+				if data, err = s.LoadCollection(dbName, op.Collection); err != nil {
+					return err
+				}
+				collections[op.Collection] = data
+			}
+			switch op.Op {
+			case fileIO.WALOpPut:
+				data[op.ID] = op.Doc
+			case fileIO.WALOpDelete:
+				delete(data, op.ID)
+			}
+		}
+	}
+
+	catalog := NewDBContext(dbName, "", s).catalog()
+	for colName, data := range collections {
+		if err := s.writeCollection(dbName, colName, data); err != nil {
+			return err
+		}
+		// 索引数据可能与重放后的集合不一致，删除后按重放后的文档重建
+		if err := os.RemoveAll(s.indexCollectionPath(dbName, colName)); err != nil {
+			return err
+		}
+		ctx := NewDBContext(dbName, colName, s)
+		if indexes, err := ctx.loadIndexes(colName, data); err == nil {
+			if err := ctx.persistIndexes(colName, indexes); err != nil {
+				return err
+			}
+		}
+		_ = catalog.UpdateCollectionStats(dbName, colName, len(data))
+	}
+	return wal.Reset()
+}
+
+// ---------------- 跨进程文件锁 ----------------
+
+// lockRoot 根目录锁：普通操作共享，Recover 排他
+func (s *FileStorage) lockRoot(mode fileIO.LockMode) (*fileIO.FileLock, error) {
+	return s.lockFile(mode, ".root.lock")
+}
+
+// lockDB 数据库锁：访问集合时共享，删除、重命名数据库以及事务排他
+func (s *FileStorage) lockDB(dbName string, mode fileIO.LockMode) (*fileIO.FileLock, error) {
+	return s.lockFile(mode, dbName+".lock")
+}
+
+// lockCollection 集合锁：读文档共享，写文档与修改集合排他，返回释放函数
+// 进程内同一集合共用一个文件描述符，集合有未落盘的写入时释放后仍保留排他锁，见 collectionCache.go
+func (s *FileStorage) lockCollection(dbName, collectionName string, mode fileIO.LockMode) (func(), error) {
+	return s.cache.lockCollection(s, cacheKey{dbName, collectionName}, mode)
+}
+
+// lockFile 获得 <root>/.locks 下的文件锁，elem 为相对路径
+func (s *FileStorage) lockFile(mode fileIO.LockMode, elem ...string) (*fileIO.FileLock, error) {
+	path := filepath.Join(append([]string{s.root, lockDirName}, elem...)...)
+	if err := fileIO.CreateDirectoryMode(filepath.Dir(path), s.opts.DirMode); err != nil {
+		return nil, err
+	}
+	return fileIO.LockFile(path, mode, s.opts.LockTimeout, s.opts.FileMode)
+}
+
+// ---------------- 错误日志 ----------------
+
+// LogError 记录错误信息到根目录下的 .errors 文件
+func (s *FileStorage) LogError(errMsg, funcName, errorLoc string) {
+	if err := fileIO.CreateDirectoryMode(s.root, s.opts.DirMode); err != nil {
+		return
+	}
+	fileIO.WriteErrorInfoTo(s.opts.ErrorFilePath(), errMsg, funcName, errorLoc)
+}
+
+// renameIfExist 源路径存在时才重命名
+func (s *FileStorage) renameIfExist(oldPath, newPath string) error {
+	if !UtilsFile.IsPathExist(oldPath) {
+		return nil
+	}
+	if err := fileIO.CreateDirectoryMode(filepath.Dir(newPath), s.opts.DirMode); err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/StephenChristianW/JsonDB/config"
+)
+
+const commitTestDB = "commit"
+
+// TestCommitPartialFailure 事务写两个集合，集合文件写入失败时：
+// 第二个集合失败时事务已提交，其余集合稍后落盘；第一个集合失败时整个事务回滚，重放 WAL 也不会生效
+func TestCommitPartialFailure(t *testing.T) {
+	t.Run("second", func(t *testing.T) {
+		store, root := newCommitTestStore(t)
+		// 提交时与随后的立即重试都失败
+		var failures atomic.Int32
+		injectWriteFault(t, func(dbName, collectionName string) error {
+			if collectionName == "b" && failures.Add(1) <= 2 {
+				return errors.New("注入的写入失败")
+			}
+			return nil
+		})
+
+		if err := insertBoth(store); err != nil {
+			t.Fatalf("WithTransaction: %v", err)
+		}
+		assertFileDocs(t, root, "a", 1)
+		assertFileDocs(t, root, "b", 0)
+		if got := store.Unflushed(commitTestDB); !reflect.DeepEqual(got, []string{"b"}) {
+			t.Errorf("Unflushed = %v, want [b]", got)
+		}
+		assertFindDocs(t, store, 1)
+
+		if err := FlushAll(store); err != nil {
+			t.Fatalf("FlushAll: %v", err)
+		}
+		assertFileDocs(t, root, "b", 1)
+		if info, err := os.Stat(filepath.Join(root, commitTestDB, ".wal")); err == nil && info.Size() != 0 {
+			t.Errorf("WAL not truncated: %d bytes", info.Size())
+		}
+	})
+
+	t.Run("first", func(t *testing.T) {
+		store, root := newCommitTestStore(t)
+		injectWriteFault(t, func(dbName, collectionName string) error {
+			if collectionName == "a" {
+				return errors.New("注入的写入失败")
+			}
+			return nil
+		})
+
+		if err := insertBoth(store); err == nil {
+			t.Fatal("WithTransaction succeeded, want error")
+		}
+		assertFindDocs(t, store, 0)
+		if err := store.Recover(); err != nil {
+			t.Fatalf("Recover: %v", err)
+		}
+		assertFileDocs(t, root, "a", 0)
+		assertFileDocs(t, root, "b", 0)
+		assertFindDocs(t, store, 0)
+	})
+}
+
+func newCommitTestStore(t *testing.T) (*FileStorage, string) {
+	root := t.TempDir()
+	store := NewFileStorage(config.Options{RootDir: root}.Normalize())
+	db := NewDBContext("", "", store)
+	if err := db.DBCreate(commitTestDB); err != nil {
+		t.Fatalf("DBCreate: %v", err)
+	}
+	db.CurrentDB = commitTestDB
+	for _, name := range []string{"a", "b"} {
+		if err := db.CollectionCreate(name); err != nil {
+			t.Fatalf("CollectionCreate %s: %v", name, err)
+		}
+	}
+	return store, root
+}
+
+// injectWriteFault 设置 writeFault，测试结束时清除
+func injectWriteFault(t *testing.T, fault func(dbName, collectionName string) error) {
+	writeFault = fault
+	t.Cleanup(func() { writeFault = nil })
+}
+
+// insertBoth 在一个事务中向集合 a 与 b 各插入一篇文档
+func insertBoth(store Storage) error {
+	return NewDBContext(commitTestDB, "", store).WithTransaction(func(tx *Tx) error {
+		for _, name := range []string{"a", "b"} {
+			c, err := tx.Collection(name)
+			if err != nil {
+				return err
+			}
+			if _, err := c.InsertOne(Document{"in": name}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// assertFindDocs 两个集合通过查询都能看到 want 篇文档
+func assertFindDocs(t *testing.T, store Storage, want int) {
+	t.Helper()
+	for _, name := range []string{"a", "b"} {
+		docs, err := NewDBContext(commitTestDB, name, store).Find(nil, nil)
+		if err != nil {
+			t.Fatalf("%s Find: %v", name, err)
+		}
+		if len(docs) != want {
+			t.Errorf("%s: Find returned %d documents, want %d", name, len(docs), want)
+		}
+	}
+}
+
+// assertFileDocs 集合文件中有 want 篇文档
+func assertFileDocs(t *testing.T, root, collectionName string, want int) {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join(root, commitTestDB, collectionName+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	docs := map[string]Document{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &docs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(docs) != want {
+		t.Errorf("%s: collection file has %d documents, want %d", collectionName, len(docs), want)
+	}
+}
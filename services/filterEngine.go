@@ -1,14 +1,14 @@
 package services
 
 import (
-	"encoding/json"
+	"cmp"
 	"errors"
 	"fmt"
-	UtilsFile "github.com/StephenChristianW/JsonDB/utils/file"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 // ---------------- utils ----------------
@@ -17,103 +17,57 @@ func generateObjectID() string {
 	return primitive.NewObjectID().Hex()
 }
 
-func getCollectionFilePath(db *DBContext) (string, error) {
-	if db.CurrentDB == "" || db.CurrentCollection == "" {
-		return "", errors.New("数据库或集合未选择")
-	}
-	return db.getCollectionFilePath(db.CurrentCollection)
-}
-
-func getIndexFilePath(db *DBContext, field string) (string, error) {
-	if db.CurrentDB == "" || db.CurrentCollection == "" {
-		return "", errors.New("数据库或集合未选择")
-	}
-	return fmt.Sprintf("MyDB/%s/%s.%s.index", db.CurrentDB, db.CurrentCollection, field), nil
-}
-
 // ---------------- load/save ----------------
 
+// loadCollection 读取当前集合的全部文档
+//
+// 存储后端实现了 sharedLoader 时返回与缓存共用的映射：读操作只能读取，写操作需持有集合写锁，
+// 提交失败时用 undoLog 恢复。
 func loadCollection(db *DBContext) (map[string]Document, error) {
-	colPath, err := getCollectionFilePath(db)
-	if err != nil {
-		return nil, err
+	if err := db.checkCollectionName(db.CurrentCollection); err != nil {
+		return nil, errors.New("数据库或集合未选择")
 	}
-
-	data := make(map[string]Document)
-	if UtilsFile.IsPathExist(colPath) {
-		bytes, err := os.ReadFile(colPath)
-		if err != nil {
-			return nil, err
-		}
-		if len(bytes) > 0 {
-			if err := json.Unmarshal(bytes, &data); err != nil {
-				return nil, err
-			}
-		}
+	if l, ok := db.store().(sharedLoader); ok {
+		return l.loadShared(db.CurrentDB, db.CurrentCollection)
 	}
-	return data, nil
+	return db.store().LoadCollection(db.CurrentDB, db.CurrentCollection)
 }
 
-func saveCollection(db *DBContext, data map[string]Document) error {
-	colPath, err := getCollectionFilePath(db)
-	if err != nil {
-		return err
-	}
-	bytes, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(colPath, bytes, 0666)
-}
-
-// ---------------- index utils ----------------
-
-func loadIndex(db *DBContext, field string) (map[interface{}][]string, error) {
-	path, err := getIndexFilePath(db, field)
-	if err != nil {
-		return nil, err
+// commitCollection 提交一次写操作：把集合全部文档、本次变更以及有变化的索引一起交给存储后端持久化
+// 返回错误时 data 与 indexes 中的修改由调用方撤销
+func commitCollection(db *DBContext, data map[string]Document, changes []Change, indexes indexSet) error {
+	if len(changes) == 0 {
+		return nil
 	}
-	index := make(map[interface{}][]string)
-	if UtilsFile.IsPathExist(path) {
-		bytes, _ := os.ReadFile(path)
-		if len(bytes) > 0 {
-			_ = json.Unmarshal(bytes, &index)
-		}
+	if err := db.checkCollectionName(db.CurrentCollection); err != nil {
+		return errors.New("数据库或集合未选择")
 	}
-	return index, nil
-}
-
-func saveIndex(db *DBContext, field string, index map[interface{}][]string) error {
-	path, err := getIndexFilePath(db, field)
+	indexData, err := db.indexWrites(db.CurrentCollection, indexes)
 	if err != nil {
 		return err
 	}
-	bytes, err := json.MarshalIndent(index, "", "  ")
+	err = db.store().Commit(db.CurrentDB, CollectionWrite{
+		Collection: db.CurrentCollection,
+		Data:       data,
+		Changes:    changes,
+		Indexes:    indexData,
+		parsed:     indexes,
+	})
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, bytes, 0666)
+	db.committed(indexes)
+	return nil
 }
 
-func updateIndex(db *DBContext, docID string, doc Document, fields []string, remove bool) {
-	for _, field := range fields {
-		val, _ := getNestedValue(doc, field)
-		index, _ := loadIndex(db, field)
-		if remove {
-			if arr, ok := index[val]; ok {
-				newArr := make([]string, 0, len(arr))
-				for _, id := range arr {
-					if id != docID {
-						newArr = append(newArr, id)
-					}
-				}
-				index[val] = newArr
-			}
-		} else {
-			index[val] = append(index[val], docID)
-		}
-		_ = saveIndex(db, field, index)
-	}
+// putChange 构造写入文档的变更
+func putChange(id string, doc Document) Change {
+	return Change{Op: ChangePut, ID: id, Doc: doc}
+}
+
+// deleteChange 构造删除文档的变更
+func deleteChange(id string) Change {
+	return Change{Op: ChangeDelete, ID: id}
 }
 
 // ---------------- match ----------------
@@ -157,18 +111,10 @@ func matchDoc(doc Document, filter map[string]interface{}) bool {
 				}
 			}
 		default:
+			// 字段按精确路径查找，缺失时任何条件都不匹配，与索引查找的结果一致
 			val, ok := getNestedValue(doc, k)
 			if !ok {
-				// 模糊匹配字段名
-				for field := range doc {
-					if strings.Contains(field, k) {
-						val, ok = doc[field]
-						break
-					}
-				}
-				if !ok {
-					return false
-				}
+				return false
 			}
 
 			if condMap, ok := v.(map[string]interface{}); ok {
@@ -189,14 +135,23 @@ func matchDoc(doc Document, filter map[string]interface{}) bool {
 
 func getNestedValue(doc Document, field string) (interface{}, bool) {
 	parts := strings.Split(field, ".")
-	var val interface{} = doc
+	// Document 与 map[string]interface{} 是不同的类型，顶层需要先转换
+	var val interface{} = map[string]interface{}(doc)
 	for _, p := range parts {
-		if m, ok := val.(map[string]interface{}); ok {
-			val, ok = m[p]
+		switch m := val.(type) {
+		case map[string]interface{}:
+			v, ok := m[p]
 			if !ok {
 				return nil, false
 			}
-		} else {
+			val = v
+		case Document:
+			v, ok := m[p]
+			if !ok {
+				return nil, false
+			}
+			val = v
+		default:
 			return nil, false
 		}
 	}
@@ -206,24 +161,25 @@ func getNestedValue(doc Document, field string) (interface{}, bool) {
 func matchOperator(value interface{}, op string, cond interface{}) bool {
 	switch op {
 	case "$eq":
-		return value == cond
+		return valuesEqual(value, cond)
 	case "$ne":
-		return value != cond
+		return !valuesEqual(value, cond)
+	// 范围比较只在同类型之间进行：数值与数值、字符串与字符串……
 	case "$gt":
-		return compareNumber(value, cond) > 0
+		return sameTypeClass(value, cond) && compareValues(value, cond) > 0
 	case "$gte":
-		return compareNumber(value, cond) >= 0
+		return sameTypeClass(value, cond) && compareValues(value, cond) >= 0
 	case "$lt":
-		return compareNumber(value, cond) < 0
+		return sameTypeClass(value, cond) && compareValues(value, cond) < 0
 	case "$lte":
-		return compareNumber(value, cond) <= 0
+		return sameTypeClass(value, cond) && compareValues(value, cond) <= 0
 	case "$in":
 		arr, ok := cond.([]interface{})
 		if !ok {
 			return false
 		}
 		for _, v := range arr {
-			if value == v {
+			if valuesEqual(value, v) {
 				return true
 			}
 		}
@@ -234,7 +190,7 @@ func matchOperator(value interface{}, op string, cond interface{}) bool {
 			return false
 		}
 		for _, v := range arr {
-			if value == v {
+			if valuesEqual(value, v) {
 				return false
 			}
 		}
@@ -255,28 +211,163 @@ func matchOperator(value interface{}, op string, cond interface{}) bool {
 	}
 }
 
-func compareNumber(a, b interface{}) int {
-	var fa, fb float64
-	switch v := a.(type) {
-	case int:
-		fa = float64(v)
-	case float64:
-		fa = v
-	default:
-		return 0
+// valuesEqual 判断两个值是否相等，与索引键使用同一套规则：
+// 数值按大小比较（int 5 等于 JSON 读出的 5.0），对象、数组按内容比较
+func valuesEqual(a, b interface{}) bool {
+	return indexKey(a) == indexKey(b)
+}
+
+// ---------------- 值比较 ----------------
+
+// 不同类型之间的排序：null < 数值 < 字符串 < 对象 < 数组 < 布尔
+//
+// 日期没有单独的类别：文档读回后只有 JSON 类型，写入的 time.Time 与 $currentDate 统一保存为 UTC、
+// 固定宽度的字符串（见 formatTime），归入字符串类，按字节序比较即为时间先后；查询条件中的 time.Time 按同一格式比较。
+// 调用方自行写入的其他格式的时间字符串（其他时区偏移、小数位数或本地时间格式）仍按文本比较，
+// 需要按时间排序或范围查询时应写入 time.Time。
+const (
+	classNull = iota
+	classNumber
+	classString
+	classObject
+	classArray
+	classBool
+	classOther
+)
+
+// typeClass 值所属的类型类别，数值的各种 Go 类型归为同一类
+func typeClass(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return classNull
+	case string:
+		return classString
+	case map[string]interface{}, Document:
+		return classObject
+	case []interface{}:
+		return classArray
+	case bool:
+		return classBool
+	case time.Time:
+		return classString
 	}
-	switch v := b.(type) {
-	case int:
-		fb = float64(v)
-	case float64:
-		fb = v
-	default:
-		return 0
+	if _, ok := toFloat(v); ok {
+		return classNumber
 	}
-	if fa > fb {
+	return classOther
+}
+
+// sameTypeClass 两个值是否属于同一类型类别
+func sameTypeClass(a, b interface{}) bool {
+	return typeClass(a) == typeClass(b)
+}
+
+// compareValues 比较任意两个 JSON 风格的值，返回 -1 / 0 / 1
+//
+// 先按类型类别排序，同类之间再按值比较：数值按大小，字符串按字节序，
+// 对象按排序后的键值逐一比较，数组按元素逐一比较，false < true。
+// 有序索引与排序共用该规则，保证走索引和全表扫描的结果顺序一致。
+func compareValues(a, b interface{}) int {
+	ca, cb := typeClass(a), typeClass(b)
+	if ca != cb {
+		return compareInt(ca, cb)
+	}
+	switch ca {
+	case classNull:
+		return 0
+	case classNumber:
+		// 整数之间按整数比较，大于 2^53 的不同整数不会因转换为 float64 而相等，与 indexKey 一致
+		if ia, ok := toInt64(a); ok {
+			if ib, ok := toInt64(b); ok {
+				return cmp.Compare(ia, ib)
+			}
+		}
+		if ua, ok := toUint64(a); ok {
+			if ub, ok := toUint64(b); ok {
+				return cmp.Compare(ua, ub)
+			}
+		}
+		fa, _ := toFloat(a)
+		fb, _ := toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case classString:
+		return strings.Compare(stringValue(a), stringValue(b))
+	case classObject:
+		return compareObjects(toMap(a), toMap(b))
+	case classArray:
+		arrA, arrB := a.([]interface{}), b.([]interface{})
+		for i := 0; i < len(arrA) && i < len(arrB); i++ {
+			if c := compareValues(arrA[i], arrB[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInt(len(arrA), len(arrB))
+	case classBool:
+		ba, bb := a.(bool), b.(bool)
+		if ba == bb {
+			return 0
+		}
+		if !ba {
+			return -1
+		}
 		return 1
-	} else if fa < fb {
+	}
+	// 无法识别的类型退化为按文本比较
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+// stringValue 字符串类别的值对应的字符串，time.Time 转换为保存格式
+func stringValue(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return formatTime(t)
+	}
+	return v.(string)
+}
+
+// compareObjects 按键名排序后逐个比较键和值
+func compareObjects(a, b map[string]interface{}) int {
+	keysA, keysB := sortedKeys(a), sortedKeys(b)
+	for i := 0; i < len(keysA) && i < len(keysB); i++ {
+		if c := strings.Compare(keysA[i], keysB[i]); c != 0 {
+			return c
+		}
+		if c := compareValues(a[keysA[i]], b[keysB[i]]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(keysA), len(keysB))
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// toMap 把 Document 与 map[string]interface{} 统一为 map[string]interface{}
+func toMap(v interface{}) map[string]interface{} {
+	if doc, ok := v.(Document); ok {
+		return doc
+	}
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
 		return -1
+	case a > b:
+		return 1
 	}
 	return 0
 }
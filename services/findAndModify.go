@@ -0,0 +1,102 @@
+package services
+
+import (
+	"time"
+)
+
+// ==================== findOneAnd* ====================
+//
+// 选择文档与修改在同一次集合写锁内完成，不会与其他写操作交错，
+// 可替代 "FindOne 之后再 Insert / Update" 这类存在竞争的写法。
+
+// FindOneAndUpdate 更新第一个满足过滤条件的文档并返回它
+// - filter: 过滤条件
+// - update: 更新内容，写法见 updateEngine.go
+// - opts: 可选，指定排序、投影、upsert 以及返回修改前还是修改后的文档
+//
+// 没有文档匹配且不 upsert 时返回 ErrNotFound。
+func (db *DBContext) FindOneAndUpdate(filter map[string]interface{}, update Document, opts ...FindOneAndOptions) (Document, error) {
+	spec, err := parseUpdate(update)
+	if err != nil {
+		return nil, err
+	}
+	modify := func(old Document, now time.Time) (Document, error) {
+		return spec.apply(old, now)
+	}
+	insert := func(now time.Time) (Document, error) {
+		return upsertDoc(filter, spec, now)
+	}
+	return db.findOneAndModify(filter, opts, modify, insert)
+}
+
+// FindOneAndReplace 用 replacement 整篇替换第一个满足过滤条件的文档并返回它，_id 保持不变
+// - filter: 过滤条件
+// - replacement: 新的文档内容，不能包含更新操作符
+// - opts: 可选，指定排序、投影、upsert 以及返回修改前还是修改后的文档
+//
+// 没有文档匹配且不 upsert 时返回 ErrNotFound。
+func (db *DBContext) FindOneAndReplace(filter map[string]interface{}, replacement Document, opts ...FindOneAndOptions) (Document, error) {
+	if err := checkReplacement(replacement); err != nil {
+		return nil, err
+	}
+	modify := func(old Document, _ time.Time) (Document, error) {
+		return replaceDoc(old, replacement)
+	}
+	insert := func(time.Time) (Document, error) {
+		return upsertReplacement(filter, replacement)
+	}
+	return db.findOneAndModify(filter, opts, modify, insert)
+}
+
+// FindOneAndDelete 删除第一个满足过滤条件的文档并返回被删除的文档
+// - filter: 过滤条件
+// - opts: 可选，指定排序与投影
+//
+// 没有文档匹配时返回 ErrNotFound。
+func (db *DBContext) FindOneAndDelete(filter map[string]interface{}, opts ...FindOneAndOptions) (Document, error) {
+	return db.findOneAndModify(filter, opts, nil, nil)
+}
+
+// findOneAndModify 在一次写锁内选出第一个满足过滤条件的文档并修改或删除
+// - modify: 由旧文档生成新文档，为 nil 时删除该文档
+// - insert: 没有文档匹配且 Upsert 为 true 时生成插入的新文档，为 nil 时不支持 upsert
+func (db *DBContext) findOneAndModify(filter map[string]interface{}, opts []FindOneAndOptions,
+	modify func(old Document, now time.Time) (Document, error), insert func(now time.Time) (Document, error)) (Document, error) {
+	var opt FindOneAndOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	proj, err := findProjection(&FindOptions{Projection: opt.Projection})
+	if err != nil {
+		return nil, err
+	}
+
+	w := writeOp{filter: filter, sort: opt.Sort, one: true, modify: modify}
+	if opt.Upsert {
+		w.insert = insert
+	}
+
+	unlock, err := db.writeLock(db.CurrentCollection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	res, before, after, err := db.write(w)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 && res.UpsertedID == "" {
+		return nil, ErrNotFound
+	}
+
+	result := before
+	if modify != nil && opt.ReturnDocument == ReturnAfter {
+		result = after
+	}
+	if result != nil && proj != nil {
+		result = proj.apply(result)
+	} else if result != nil {
+		result = cloneDocument(result)
+	}
+	return result, nil
+}
@@ -0,0 +1,1178 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ConfigFile "github.com/StephenChristianW/JsonDB/fileIO/configFileIO"
+	UtilsTime "github.com/StephenChristianW/JsonDB/utils/time"
+)
+
+// ==================== 二级索引 ====================
+//
+// 索引定义保存在集合配置 settings.index 中，索引数据通过存储后端的 LoadIndex/SaveIndex 读写。
+// 索引数据缺失或损坏时（如 WAL 重放之后）从集合数据重建。存储后端实现了 indexCache 时，
+// 已解析的索引随集合缓存在内存中，查询直接使用；读操作重建的索引只保存在缓存中，由之后的写操作随集合一起持久化。
+// 支持两种索引：
+//
+//   - hash：   "类型化键 -> _id 列表" 的映射，键由 indexKey 生成，只用于全部字段的等值与单字段 $in 查询
+//   - ordered：按 (索引键, _id) 排好序的数组，比较规则见 compareValues，每个字段可单独指定升序或降序，
+//     用二分查找支持前缀字段等值 + 下一个字段的 $in、$gt/$gte/$lt/$lte、前缀 $regex，以及按索引字段排序
+//
+// 单字段索引的键就是字段值；复合索引的键是按定义顺序排列的字段值数组。
+//
+// 唯一约束（settings.unique_field）由同名前缀为 uniqueIndexPrefix 的哈希索引支撑，
+// 插入、更新时用它在 O(1) 内找到冲突文档，比较规则与普通索引相同（见 indexKey）。
+//
+// 稀疏索引（sparse）跳过缺少索引字段的文档，部分索引（partialFilter）只收录满足过滤条件的文档；
+// 唯一约束同样只作用于被收录的文档。这两类索引不包含全部文档，查询计划只在确定不会漏掉结果时使用它们。
+
+const (
+	IndexHash    = "hash"    // 哈希索引（单字段索引默认）
+	IndexOrdered = "ordered" // 有序索引（复合索引默认）
+)
+
+// uniqueIndexPrefix 唯一约束索引的名称前缀，后接约束名，如 "$unique:email+org_id"
+const uniqueIndexPrefix = "$unique:"
+
+// DuplicateKeyError 写入的文档违反唯一约束
+type DuplicateKeyError struct {
+	Constraint string      // 约束名：字段名，复合约束为 "email+org_id" 形式
+	Fields     []string    // 约束包含的字段
+	Key        interface{} // 冲突的值，复合约束为按字段顺序排列的数组
+	ConflictID string      // 已占用该值的文档 _id
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("唯一字段冲突: %s = %v，与文档 %s 重复", e.Constraint, e.Key, e.ConflictID)
+}
+
+// indexData 索引持久化结构
+type indexData struct {
+	Fields  []string            `json:"fields"`
+	Orders  []int               `json:"orders,omitempty"`
+	Kind    string              `json:"kind,omitempty"`
+	Entries map[string][]string `json:"entries,omitempty"`  // hash：类型化键 -> _id 列表
+	KeyFmt  int                 `json:"key_fmt,omitempty"`  // hash：键的编码版本，见 hashKeyFormat
+	Ordered []orderedEntry      `json:"ordered,omitempty"`  // ordered：按索引键有序的条目
+	BuiltAt string              `json:"built_at,omitempty"` // 最近一次全量构建的时间
+}
+
+// hashKeyFormat 当前 indexKey 的编码版本；之前的版本（缺省为 0）把整数按 float64 编码，读取时重建
+const hashKeyFormat = 1
+
+// orderedEntry 有序索引中的一条记录
+type orderedEntry struct {
+	Value interface{} `json:"v"`
+	ID    string      `json:"id"`
+}
+
+// rangeBound 范围查询的一端
+type rangeBound struct {
+	value     interface{}
+	inclusive bool
+}
+
+// keyRange 有序索引上单个字段的取值范围
+//
+// lo、hi 为 nil 表示该端不设限，但范围只包含与另一端同类型的值，与 matchOperator 只在同类型之间比较一致；
+// prefix 非空时表示以 prefix 开头的字符串。
+type keyRange struct {
+	lo, hi *rangeBound
+	prefix string
+}
+
+// collectionIndex 内存中的单个索引
+type collectionIndex struct {
+	name       string
+	def        ConfigFile.IndexDefinition
+	data       indexData
+	dirty      bool   // 内容有变化，需要持久化
+	constraint string // 非空时为唯一约束索引，值为约束名
+}
+
+// indexSet 一个集合上的全部索引
+type indexSet []*collectionIndex
+
+// indexKey 把字段值编码为带类型前缀的字符串键
+//
+// 数值按 numberKey 编码，保证 int 5 与 JSON 读出的 5.0 落在同一个键上；
+// 字段缺失与 null 视为同一个键。
+func indexKey(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "b:" + strconv.FormatBool(val)
+	case string:
+		return "s:" + val
+	case time.Time:
+		// 与写入时保存的字符串落在同一个键上
+		return "s:" + formatTime(val)
+	}
+	if n, ok := numberKey(v); ok {
+		return "n:" + n
+	}
+	// 对象、数组：json.Marshal 会对 map 的键排序，结果稳定
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return "?"
+	}
+	return "j:" + string(bytes)
+}
+
+// numberKey 数值的十进制编码：整数值（含恰好为整数的浮点数）按整数编码，其余按 float64 编码
+//
+// 不经过 float64 转换，大于 2^53 的不同 int64 / uint64 不会落在同一个键上。
+// 集合文件重新加载后数值解码为 float64，这类整数在文档中本身就会丢失精度，需要精确保存时应使用字符串。
+func numberKey(v interface{}) (string, bool) {
+	if i, ok := toInt64(v); ok {
+		return strconv.FormatInt(i, 10), true
+	}
+	if u, ok := toUint64(v); ok {
+		return strconv.FormatUint(u, 10), true
+	}
+	f, ok := toFloat(v)
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), true
+}
+
+// toInt64 不丢失精度地转换为 int64：有符号整数、不超过 int64 范围的无符号整数、恰好为整数的浮点数
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint:
+		return int64(n), uint64(n) <= math.MaxInt64
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, true
+		}
+	}
+	f, ok := toFloat(v)
+	if !ok || f != math.Trunc(f) || f < -(1<<63) || f >= 1<<63 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// toUint64 不丢失精度地转换为 uint64：非负的整数值，用于超出 int64 范围的整数
+func toUint64(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case uint:
+		return uint64(n), true
+	case uint64:
+		return n, true
+	}
+	if i, ok := toInt64(v); ok {
+		return uint64(i), i >= 0
+	}
+	f, ok := toFloat(v)
+	if !ok || f != math.Trunc(f) || f < 0 || f >= 1<<64 {
+		return 0, false
+	}
+	return uint64(f), true
+}
+
+// toFloat 把各种数值类型转换为 float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// ---------------- collectionIndex ----------------
+
+func newCollectionIndex(name string, def ConfigFile.IndexDefinition) *collectionIndex {
+	if len(def.Fields) == 0 {
+		def.Fields = []string{name}
+	}
+	orders := make([]int, len(def.Fields))
+	for i := range orders {
+		orders[i] = 1
+		if i < len(def.Orders) && def.Orders[i] < 0 {
+			orders[i] = -1
+		}
+	}
+	def.Orders = orders
+	if def.Kind == "" {
+		def.Kind = IndexHash
+		if len(def.Fields) > 1 {
+			def.Kind = IndexOrdered
+		}
+	}
+	idx := &collectionIndex{name: name, def: def}
+	idx.reset()
+	return idx
+}
+
+// ordered 是否为有序索引
+func (idx *collectionIndex) ordered() bool {
+	return idx.def.Kind == IndexOrdered
+}
+
+// compound 是否为复合索引
+func (idx *collectionIndex) compound() bool {
+	return len(idx.def.Fields) > 1
+}
+
+// fields 索引字段，按定义顺序
+func (idx *collectionIndex) fields() []string {
+	return idx.def.Fields
+}
+
+// order 第 i 个字段的方向：1 升序，-1 降序
+func (idx *collectionIndex) order(i int) int {
+	return idx.def.Orders[i]
+}
+
+// reset 清空索引数据
+func (idx *collectionIndex) reset() {
+	idx.data = indexData{Fields: idx.def.Fields, Orders: idx.def.Orders, Kind: idx.def.Kind}
+	if idx.ordered() {
+		idx.data.Ordered = []orderedEntry{}
+	} else {
+		idx.data.Entries = make(map[string][]string)
+		idx.data.KeyFmt = hashKeyFormat
+	}
+}
+
+// partial 索引是否只收录部分文档
+func (idx *collectionIndex) partial() bool {
+	return idx.def.Sparse || len(idx.def.PartialFilter) > 0
+}
+
+// includes 文档是否应被收录到该索引
+func (idx *collectionIndex) includes(doc Document) bool {
+	if idx.def.Sparse {
+		present := false
+		for _, field := range idx.def.Fields {
+			if _, ok := getNestedValue(doc, field); ok {
+				present = true
+				break
+			}
+		}
+		if !present {
+			return false
+		}
+	}
+	return len(idx.def.PartialFilter) == 0 || matchDoc(doc, idx.def.PartialFilter)
+}
+
+// key 文档在该索引上的键，字段缺失视为 null
+func (idx *collectionIndex) key(doc Document) interface{} {
+	if !idx.compound() {
+		val, _ := getNestedValue(doc, idx.def.Fields[0])
+		return cloneValue(val)
+	}
+	key := make([]interface{}, len(idx.def.Fields))
+	for i, field := range idx.def.Fields {
+		val, _ := getNestedValue(doc, field)
+		key[i] = cloneValue(val)
+	}
+	return key
+}
+
+// keyAt 索引键中第 i 个字段的值
+func (idx *collectionIndex) keyAt(key interface{}, i int) interface{} {
+	if !idx.compound() {
+		return key
+	}
+	return key.([]interface{})[i]
+}
+
+// compareKeys 按字段方向比较两个索引键
+func (idx *collectionIndex) compareKeys(a, b interface{}) int {
+	for i := range idx.def.Fields {
+		if c := compareValues(idx.keyAt(a, i), idx.keyAt(b, i)) * idx.order(i); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// search 有序索引中第一个不小于 (key, id) 的位置
+func (idx *collectionIndex) search(key interface{}, id string) int {
+	entries := idx.data.Ordered
+	return sort.Search(len(entries), func(i int) bool {
+		if c := idx.compareKeys(entries[i].Value, key); c != 0 {
+			return c > 0
+		}
+		return entries[i].ID >= id
+	})
+}
+
+// add 把文档加入索引
+func (idx *collectionIndex) add(id string, doc Document) {
+	if !idx.includes(doc) {
+		return
+	}
+	key := idx.key(doc)
+	if idx.ordered() {
+		pos := idx.search(key, id)
+		entries := append(idx.data.Ordered, orderedEntry{})
+		copy(entries[pos+1:], entries[pos:])
+		entries[pos] = orderedEntry{Value: key, ID: id}
+		idx.data.Ordered = entries
+	} else {
+		hashKey := indexKey(key)
+		idx.data.Entries[hashKey] = append(idx.data.Entries[hashKey], id)
+	}
+	idx.dirty = true
+}
+
+// addMany 把一批新文档加入索引；有序索引先把新条目排好序再与已有条目归并，
+// 避免逐条插入时反复移动整个数组
+func (idx *collectionIndex) addMany(ids []string, docs []Document) {
+	if !idx.ordered() {
+		for i, id := range ids {
+			idx.add(id, docs[i])
+		}
+		return
+	}
+	added := make([]orderedEntry, 0, len(ids))
+	for i, id := range ids {
+		if idx.includes(docs[i]) {
+			added = append(added, orderedEntry{Value: idx.key(docs[i]), ID: id})
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+	compare := func(a, b orderedEntry) int {
+		if c := idx.compareKeys(a.Value, b.Value); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	}
+	slices.SortFunc(added, compare)
+
+	old := idx.data.Ordered
+	merged := make([]orderedEntry, 0, len(old)+len(added))
+	i, j := 0, 0
+	for i < len(old) && j < len(added) {
+		if compare(old[i], added[j]) <= 0 {
+			merged = append(merged, old[i])
+			i++
+		} else {
+			merged = append(merged, added[j])
+			j++
+		}
+	}
+	merged = append(merged, old[i:]...)
+	merged = append(merged, added[j:]...)
+	idx.data.Ordered = merged
+	idx.dirty = true
+}
+
+// remove 把文档从索引中移除
+func (idx *collectionIndex) remove(id string, doc Document) {
+	if !idx.includes(doc) {
+		return
+	}
+	key := idx.key(doc)
+	idx.dirty = true
+	if idx.ordered() {
+		pos := idx.search(key, id)
+		if pos < len(idx.data.Ordered) && idx.data.Ordered[pos].ID == id {
+			idx.data.Ordered = append(idx.data.Ordered[:pos], idx.data.Ordered[pos+1:]...)
+		}
+		return
+	}
+	hashKey := indexKey(key)
+	ids := idx.data.Entries[hashKey]
+	for i, v := range ids {
+		if v == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(idx.data.Entries, hashKey)
+	} else {
+		idx.data.Entries[hashKey] = ids
+	}
+}
+
+// lookup 全部字段等值查询，values 按索引字段顺序排列
+func (idx *collectionIndex) lookup(values []interface{}) []string {
+	if idx.ordered() {
+		return idx.scan(values, nil)
+	}
+	if !idx.compound() {
+		return idx.data.Entries[indexKey(values[0])]
+	}
+	return idx.data.Entries[indexKey(values)]
+}
+
+// scan 有序索引查询：前 len(prefix) 个字段依次等于 prefix，下一个字段落在 r 内（r 为 nil 表示不限）
+//
+// 返回的 _id 按索引顺序排列。
+func (idx *collectionIndex) scan(prefix []interface{}, r *keyRange) []string {
+	entries := idx.data.Ordered
+	p := len(prefix)
+	comparePrefix := func(key interface{}) int {
+		for i, want := range prefix {
+			if c := compareValues(idx.keyAt(key, i), want) * idx.order(i); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	// 在前缀相同的条目中，下一个字段按其方向有序：升序时先出现低于范围的值，降序时先出现高于范围的值
+	before := func(v interface{}) bool {
+		if idx.order(p) > 0 {
+			return r.below(v)
+		}
+		return r.above(v)
+	}
+	after := func(v interface{}) bool {
+		if idx.order(p) > 0 {
+			return r.above(v)
+		}
+		return r.below(v)
+	}
+
+	start := sort.Search(len(entries), func(i int) bool {
+		if c := comparePrefix(entries[i].Value); c != 0 {
+			return c > 0
+		}
+		return r == nil || !before(idx.keyAt(entries[i].Value, p))
+	})
+	end := sort.Search(len(entries), func(i int) bool {
+		if c := comparePrefix(entries[i].Value); c != 0 {
+			return c > 0
+		}
+		return r != nil && after(idx.keyAt(entries[i].Value, p))
+	})
+
+	ids := []string{}
+	for i := start; i < end; i++ {
+		ids = append(ids, entries[i].ID)
+	}
+	return ids
+}
+
+// below 值是否排在范围之前（按 compareValues 升序）
+func (r *keyRange) below(v interface{}) bool {
+	switch {
+	case r.prefix != "":
+		return compareValues(v, r.prefix) < 0
+	case r.lo != nil:
+		c := compareValues(v, r.lo.value)
+		return c < 0 || (c == 0 && !r.lo.inclusive)
+	default:
+		return typeClass(v) < typeClass(r.hi.value)
+	}
+}
+
+// above 值是否排在范围之后（按 compareValues 升序）
+func (r *keyRange) above(v interface{}) bool {
+	switch {
+	case r.prefix != "":
+		s, isStr := v.(string)
+		return typeClass(v) > classString || (isStr && s > r.prefix && !strings.HasPrefix(s, r.prefix))
+	case r.hi != nil:
+		c := compareValues(v, r.hi.value)
+		return c > 0 || (c == 0 && !r.hi.inclusive)
+	default:
+		return typeClass(v) > typeClass(r.lo.value)
+	}
+}
+
+// ---------------- 唯一约束 ----------------
+
+// newUniqueIndex 唯一约束对应的哈希索引
+func newUniqueIndex(constraint string, def ConfigFile.UniqueDefinition) *collectionIndex {
+	idx := newCollectionIndex(uniqueIndexPrefix+constraint, ConfigFile.IndexDefinition{
+		Fields:        uniqueFieldsOf(constraint),
+		Kind:          IndexHash,
+		Sparse:        def.Sparse,
+		PartialFilter: def.PartialFilter,
+	})
+	idx.constraint = constraint
+	return idx
+}
+
+// conflict 检查 doc 写入后是否与 _id 不等于 id 的文档键相同，不被收录的文档不受约束
+func (idx *collectionIndex) conflict(id string, doc Document) error {
+	if !idx.includes(doc) {
+		return nil
+	}
+	key := idx.key(doc)
+	for _, other := range idx.data.Entries[indexKey(key)] {
+		if other != id {
+			return &DuplicateKeyError{Constraint: idx.constraint, Fields: idx.fields(), Key: key, ConflictID: other}
+		}
+	}
+	return nil
+}
+
+// duplicate 索引中已存在的第一个重复键，用于在已有数据上新建唯一约束
+func (idx *collectionIndex) duplicate(data map[string]Document) error {
+	keys := make([]string, 0, len(idx.data.Entries))
+	for key, ids := range idx.data.Entries {
+		if len(ids) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	ids := idx.data.Entries[keys[0]]
+	return &DuplicateKeyError{Constraint: idx.constraint, Fields: idx.fields(), Key: idx.key(data[ids[1]]), ConflictID: ids[0]}
+}
+
+// allIDs 有序索引上的全部 _id，按索引顺序
+func (idx *collectionIndex) allIDs() []string {
+	ids := make([]string, len(idx.data.Ordered))
+	for i, e := range idx.data.Ordered {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+// build 从集合数据重建索引
+func (idx *collectionIndex) build(data map[string]Document) {
+	idx.reset()
+	ids := make([]string, 0, len(data))
+	for id := range data {
+		ids = append(ids, id)
+	}
+	// 按 _id 排序，保证同一份数据重建出的索引完全一致
+	sort.Strings(ids)
+	if idx.ordered() {
+		for _, id := range ids {
+			if idx.includes(data[id]) {
+				idx.data.Ordered = append(idx.data.Ordered, orderedEntry{Value: idx.key(data[id]), ID: id})
+			}
+		}
+		// 稳定排序：键相同的条目保持 _id 顺序
+		sort.SliceStable(idx.data.Ordered, func(i, j int) bool {
+			return idx.compareKeys(idx.data.Ordered[i].Value, idx.data.Ordered[j].Value) < 0
+		})
+	} else {
+		for _, id := range ids {
+			idx.add(id, data[id])
+		}
+	}
+	idx.data.BuiltAt = UtilsTime.TimeNow()
+	idx.dirty = true
+}
+
+// valid 从存储读出的索引数据是否与定义一致，不一致时需要重建
+func (d indexData) valid(def ConfigFile.IndexDefinition) bool {
+	kind := d.Kind
+	if kind == "" {
+		kind = IndexHash
+	}
+	orders := d.Orders
+	if len(orders) == 0 {
+		orders = slices.Repeat([]int{1}, len(d.Fields))
+	}
+	if kind != def.Kind || !slices.Equal(d.Fields, def.Fields) || !slices.Equal(orders, def.Orders) {
+		return false
+	}
+	if kind == IndexOrdered {
+		return d.Ordered != nil
+	}
+	return d.Entries != nil && d.KeyFmt == hashKeyFormat
+}
+
+// ---------------- 统计与校验 ----------------
+
+// counts 索引中不同键的数量与收录的文档数
+func (idx *collectionIndex) counts() (keys, entries int) {
+	if !idx.ordered() {
+		for _, ids := range idx.data.Entries {
+			entries += len(ids)
+		}
+		return len(idx.data.Entries), entries
+	}
+	for i, e := range idx.data.Ordered {
+		if i == 0 || idx.compareKeys(idx.data.Ordered[i-1].Value, e.Value) != 0 {
+			keys++
+		}
+	}
+	return keys, len(idx.data.Ordered)
+}
+
+// entryKeys 索引数据中每个 _id 对应的类型化键，同一 _id 出现多次时记入 repeated
+func (idx *collectionIndex) entryKeys(d indexData) (keys map[string]string, repeated []string) {
+	keys = make(map[string]string)
+	put := func(id, key string) {
+		if _, ok := keys[id]; ok {
+			repeated = append(repeated, id)
+			return
+		}
+		keys[id] = key
+	}
+	if idx.ordered() {
+		for _, e := range d.Ordered {
+			put(e.ID, indexKey(e.Value))
+		}
+	} else {
+		for key, ids := range d.Entries {
+			for _, id := range ids {
+				put(id, key)
+			}
+		}
+	}
+	return keys, repeated
+}
+
+// inOrder 有序索引的条目是否按 (索引键, _id) 排列，复合索引的键是否为等长数组
+func (idx *collectionIndex) inOrder(entries []orderedEntry) bool {
+	for i, e := range entries {
+		if idx.compound() {
+			if key, ok := e.Value.([]interface{}); !ok || len(key) != len(idx.def.Fields) {
+				return false
+			}
+		}
+		if i == 0 {
+			continue
+		}
+		prev := entries[i-1]
+		if c := idx.compareKeys(prev.Value, e.Value); c > 0 || c == 0 && prev.ID >= e.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// diff 比较 stored 与 idx 当前的数据（应已从集合 build），返回应收录但 stored 中缺失的 _id，
+// 以及 stored 中多余、重复或键与文档不一致的 _id，均按 _id 排序
+func (idx *collectionIndex) diff(stored indexData) (missing, stale []string) {
+	want, _ := idx.entryKeys(idx.data)
+	have, repeated := idx.entryKeys(stored)
+	stale = repeated
+	for id, key := range want {
+		if got, ok := have[id]; !ok {
+			missing = append(missing, id)
+		} else if got != key {
+			stale = append(stale, id)
+		}
+	}
+	for id := range have {
+		if _, ok := want[id]; !ok {
+			stale = append(stale, id)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	return missing, slices.Compact(stale)
+}
+
+// ---------------- indexSet ----------------
+
+// indexCache 能随集合缓存已解析索引的存储后端，FileStorage 实现了该接口
+//
+// 缓存的索引与存储后端当前缓存的集合文档对应，集合文件变化时一起失效；调用方需持有集合锁。
+type indexCache interface {
+	cachedIndexes(dbName, collectionName string) indexSet     // 没有缓存时返回 nil
+	cacheIndexes(dbName, collectionName string, set indexSet) // 集合不在缓存中时不做任何操作
+}
+
+// loadIndexes 读取集合的全部索引（含唯一约束索引），缺失或无法解析的索引数据从 data 重建
+//
+// 按元数据目录中的当前定义组装：缓存中定义相同的索引直接使用，其余从存储读取或重建，组装结果放回缓存。
+// 返回的索引与缓存共用，与 loadCollection 返回的文档映射一样：读操作只能读取；写操作持有集合写锁直接修改，
+// 失败时用 undoLog 恢复，提交成功后调用 committed。
+func (db *DBContext) loadIndexes(collectionName string, data map[string]Document) (indexSet, error) {
+	set, err := db.newIndexSet(collectionName)
+	if err != nil {
+		return nil, err
+	}
+	cache, cacheable := db.store().(indexCache)
+	var cached indexSet
+	if cacheable {
+		cached = cache.cachedIndexes(db.CurrentDB, collectionName)
+	}
+	loaded := false
+	for i, idx := range set {
+		if c := cached.find(idx); c != nil {
+			set[i] = c
+			continue
+		}
+		if err := db.loadIndexData(collectionName, idx, data); err != nil {
+			return nil, err
+		}
+		loaded = true
+	}
+	if cacheable && (loaded || len(set) != len(cached)) {
+		cache.cacheIndexes(db.CurrentDB, collectionName, set)
+	}
+	return set, nil
+}
+
+// indexWrites 提交时需要写入的索引数据，见 CollectionWrite.Indexes
+//
+// 存储后端推迟保存索引时只给出有变化的索引名，不序列化索引（大集合上每次写入都序列化代价与集合大小成正比）。
+func (db *DBContext) indexWrites(collectionName string, set indexSet) (map[string][]byte, error) {
+	if d, ok := db.store().(metaDeferrer); ok && d.defersMeta(db.CurrentDB, collectionName) {
+		out := make(map[string][]byte)
+		for _, idx := range set {
+			if idx.dirty {
+				out[idx.name] = nil
+			}
+		}
+		return out, nil
+	}
+	return set.dirtyData()
+}
+
+// committed 写操作已把 set 随集合一起提交，清除变化标记
+func (db *DBContext) committed(set indexSet) {
+	for _, idx := range set {
+		idx.dirty = false
+	}
+}
+
+// find 与 idx 名称、定义都相同的索引，没有时返回 nil
+func (s indexSet) find(idx *collectionIndex) *collectionIndex {
+	for _, c := range s {
+		if c.name == idx.name && c.constraint == idx.constraint && sameDefinition(c.def, idx.def) {
+			return c
+		}
+	}
+	return nil
+}
+
+// sameDefinition 两个索引定义是否相同
+func sameDefinition(a, b ConfigFile.IndexDefinition) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
+
+// newIndexSet 按元数据目录中的定义创建集合的全部索引（含唯一约束索引），不读取索引数据
+func (db *DBContext) newIndexSet(collectionName string) (indexSet, error) {
+	defs, err := db.catalog().GetIndexes(db.CurrentDB, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	uniqueDefs, err := db.catalog().GetUniqueConstraints(db.CurrentDB, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	constraints := make([]string, 0, len(uniqueDefs))
+	for constraint := range uniqueDefs {
+		constraints = append(constraints, constraint)
+	}
+	sort.Strings(constraints)
+
+	var set indexSet
+	for _, name := range names {
+		set = append(set, newCollectionIndex(name, defs[name]))
+	}
+	for _, constraint := range constraints {
+		set = append(set, newUniqueIndex(constraint, uniqueDefs[constraint]))
+	}
+	return set, nil
+}
+
+// loadIndexData 读取单个索引的数据，缺失、无法解析或与定义不一致时从 data 重建
+func (db *DBContext) loadIndexData(collectionName string, idx *collectionIndex, data map[string]Document) error {
+	bytes, err := db.store().LoadIndex(db.CurrentDB, collectionName, idx.name)
+	if err != nil {
+		return err
+	}
+	var stored indexData
+	if len(bytes) == 0 || json.Unmarshal(bytes, &stored) != nil || !stored.valid(idx.def) {
+		idx.build(data)
+	} else {
+		stored.Orders, stored.Kind = idx.def.Orders, idx.def.Kind
+		idx.data = stored
+	}
+	return nil
+}
+
+// insert 文档写入后更新全部索引
+func (s indexSet) insert(id string, doc Document) {
+	for _, idx := range s {
+		idx.add(id, doc)
+	}
+}
+
+// insertHashed 批量插入时只把文档加入哈希索引（唯一约束都是哈希索引），有序索引留给 insertOrdered
+func (s indexSet) insertHashed(id string, doc Document) {
+	for _, idx := range s {
+		if !idx.ordered() {
+			idx.add(id, doc)
+		}
+	}
+}
+
+// insertOrdered 把一批已通过 insertHashed 加入的文档一次性加入有序索引
+func (s indexSet) insertOrdered(ids []string, docs []Document) {
+	for _, idx := range s {
+		if idx.ordered() {
+			idx.addMany(ids, docs)
+		}
+	}
+}
+
+// checkUnique 检查文档写入后是否违反任一唯一约束，需在 insert 之前、remove 旧文档之后调用
+func (s indexSet) checkUnique(id string, doc Document) error {
+	for _, idx := range s {
+		if idx.constraint == "" {
+			continue
+		}
+		if err := idx.conflict(id, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove 文档删除（或更新前）从全部索引中移除
+func (s indexSet) remove(id string, doc Document) {
+	for _, idx := range s {
+		idx.remove(id, doc)
+	}
+}
+
+// dirtyData 序列化有变化的索引，用于随集合一起提交
+func (s indexSet) dirtyData() (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	for _, idx := range s {
+		if !idx.dirty {
+			continue
+		}
+		bytes, err := json.Marshal(idx.data)
+		if err != nil {
+			return nil, err
+		}
+		out[idx.name] = bytes
+	}
+	return out, nil
+}
+
+// persistIndexes 单独保存有变化的索引（创建、重建索引后调用），调用方需持有集合写锁
+//
+// 缓存中的索引随之丢弃，下次读取时重新加载。
+func (db *DBContext) persistIndexes(collectionName string, set indexSet) error {
+	data, err := set.dirtyData()
+	if err != nil {
+		return err
+	}
+	if cache, ok := db.store().(indexCache); ok {
+		cache.cacheIndexes(db.CurrentDB, collectionName, nil)
+	}
+	for name, bytes := range data {
+		if err := db.store().SaveIndex(db.CurrentDB, collectionName, name, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------------- 查询计划 ----------------
+
+// queryPlan Find 的执行计划
+type queryPlan struct {
+	ids    []string // 候选文档 _id，仍需经过 matchDoc 复核
+	scan   bool     // 没有可用索引，需要全表扫描
+	sorted bool     // ids 已按排序字段排好序，无需再排序
+}
+
+// indexCandidates 单个索引能给出的候选集
+type indexCandidates struct {
+	idx       *collectionIndex
+	ids       []string
+	prefixLen int // 等值匹配的前缀字段数
+	sortDepth int // ids 按索引前 sortDepth 个字段有序
+}
+
+// plan 根据过滤条件与排序字段选择索引
+//
+// 顶层条件之间是 AND 关系，任意一个能用索引的条件都能缩小范围，取候选最少的那个；
+// 没有可用条件但排序字段与某个有序索引的字段一致时，按索引顺序遍历全部文档，省去排序。
+// sortKeys 为 sortSpec 规范化后的排序字段，最后一个总是 _id，为空表示不排序。
+// 索引与 matchDoc 一样按精确字段路径查找，候选文档仍由 matchDoc 复核。
+func (s indexSet) plan(filter map[string]interface{}, sortKeys []SortField) queryPlan {
+	var best *indexCandidates
+	for _, idx := range s {
+		if c, ok := idx.candidates(filter); ok && (best == nil || len(c.ids) < len(best.ids)) {
+			best = c
+		}
+	}
+	if best != nil {
+		p := queryPlan{ids: best.ids}
+		if reverse, ok := best.sortedBy(sortKeys); ok {
+			p.sorted = true
+			if reverse {
+				p.ids = reverseIDs(p.ids)
+			}
+		}
+		return p
+	}
+
+	for _, idx := range s {
+		// 按索引顺序遍历全部文档时，只收录部分文档的索引会漏掉结果
+		if !idx.ordered() || idx.partial() {
+			continue
+		}
+		all := &indexCandidates{idx: idx, sortDepth: len(idx.fields())}
+		if reverse, ok := all.sortedBy(sortKeys); ok {
+			p := queryPlan{ids: idx.allIDs(), sorted: true}
+			if reverse {
+				p.ids = reverseIDs(p.ids)
+			}
+			return p
+		}
+	}
+	return queryPlan{scan: true}
+}
+
+// sortedBy 候选 _id 是否已按 sortKeys 中 _id 之外的字段排好序，reverse 为 true 表示倒序后才有序
+//
+// 等值前缀字段在候选文档中都是同一个值，不影响顺序；其余排序字段必须依次是索引接下来的字段，
+// 且方向与索引全部相同或全部相反。
+func (c *indexCandidates) sortedBy(sortKeys []SortField) (reverse bool, ok bool) {
+	if len(sortKeys) == 0 {
+		return false, false
+	}
+	fields := c.idx.fields()
+	var rest []SortField
+	for _, key := range sortKeys[:len(sortKeys)-1] {
+		if !slices.Contains(fields[:c.prefixLen], key.Field) {
+			rest = append(rest, key)
+		}
+	}
+	if len(rest) == 0 || c.prefixLen+len(rest) > c.sortDepth {
+		return false, false
+	}
+	for i, key := range rest {
+		pos := c.prefixLen + i
+		if fields[pos] != key.Field {
+			return false, false
+		}
+		r := key.Order != c.idx.order(pos)
+		if i > 0 && r != reverse {
+			return false, false
+		}
+		reverse = r
+	}
+	return reverse, true
+}
+
+// covers 部分索引是否收录了所有可能匹配 filter 的文档
+//
+// 稀疏索引：filter 对第一个索引字段有顶层条件时，缺少该字段的文档不会被匹配（matchDoc 对缺失字段的任何条件返回 false，
+// 包括 null 与 $ne）；$or、$not 中的条件不计入。
+// 部分索引：filter 必须原样包含 partialFilter 的每个顶层条件。
+func (idx *collectionIndex) covers(filter map[string]interface{}) bool {
+	if idx.def.Sparse {
+		if _, exists := filter[idx.fields()[0]]; !exists {
+			return false
+		}
+	}
+	for k, cond := range idx.def.PartialFilter {
+		v, exists := filter[k]
+		if !exists || !valuesEqual(v, cond) {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates 该索引能为过滤条件给出的候选 _id，ok=false 表示无法使用该索引
+func (idx *collectionIndex) candidates(filter map[string]interface{}) (*indexCandidates, bool) {
+	if idx.partial() && !idx.covers(filter) {
+		return nil, false
+	}
+	fields := idx.fields()
+	var prefix []interface{}
+	for _, field := range fields {
+		val, isEq := equalityValue(filter[field])
+		if _, exists := filter[field]; !exists || !isEq {
+			break
+		}
+		prefix = append(prefix, val)
+	}
+	p := len(prefix)
+	c := &indexCandidates{idx: idx, prefixLen: p, sortDepth: p}
+
+	if p == len(fields) {
+		c.ids = idx.lookup(prefix)
+		if idx.ordered() {
+			c.sortDepth = len(fields)
+		}
+		return c, true
+	}
+
+	condMap, _ := filter[fields[p]].(map[string]interface{})
+	if arr, isIn := condMap["$in"].([]interface{}); isIn && (idx.ordered() || !idx.compound()) {
+		c.ids = idx.lookupIn(prefix, arr)
+		return c, true
+	}
+	if !idx.ordered() {
+		return nil, false
+	}
+
+	// 下一个字段上的范围条件
+	c.sortDepth = p + 1
+	if r, ok := rangeOf(condMap); ok {
+		if r.lo != nil && r.hi != nil && !sameTypeClass(r.lo.value, r.hi.value) {
+			c.ids = []string{}
+		} else {
+			c.ids = idx.scan(prefix, r)
+		}
+		return c, true
+	}
+	if p == 0 {
+		return nil, false
+	}
+	c.ids = idx.scan(prefix, nil)
+	return c, true
+}
+
+// rangeOf 从操作符条件中提取范围：$gt/$gte/$lt/$lte，或以 ^ 开头的 $regex 字面量前缀
+func rangeOf(condMap map[string]interface{}) (*keyRange, bool) {
+	r := &keyRange{}
+	if v, exists := condMap["$gt"]; exists {
+		r.lo = &rangeBound{value: v}
+	}
+	if v, exists := condMap["$gte"]; exists {
+		r.lo = &rangeBound{value: v, inclusive: true}
+	}
+	if v, exists := condMap["$lt"]; exists {
+		r.hi = &rangeBound{value: v}
+	}
+	if v, exists := condMap["$lte"]; exists {
+		r.hi = &rangeBound{value: v, inclusive: true}
+	}
+	if r.lo != nil || r.hi != nil {
+		return r, true
+	}
+	if pattern, isStr := condMap["$regex"].(string); isStr {
+		if prefix, hasPrefix := regexPrefix(pattern); hasPrefix {
+			return &keyRange{prefix: prefix}, true
+		}
+	}
+	return nil, false
+}
+
+// lookupIn 前缀字段等值、下一个字段 $in 的查询：各个取值结果的并集
+func (idx *collectionIndex) lookupIn(prefix []interface{}, values []interface{}) []string {
+	seen := make(map[string]struct{})
+	ids := []string{}
+	for _, v := range values {
+		var found []string
+		if idx.ordered() {
+			bound := &rangeBound{value: v, inclusive: true}
+			found = idx.scan(prefix, &keyRange{lo: bound, hi: bound})
+		} else {
+			found = idx.lookup([]interface{}{v})
+		}
+		for _, id := range found {
+			if _, dup := seen[id]; !dup {
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// equalityValue 判断条件是否为等值匹配：普通值或 {"$eq": v}
+func equalityValue(cond interface{}) (interface{}, bool) {
+	condMap, isMap := cond.(map[string]interface{})
+	if !isMap {
+		return cond, true
+	}
+	if v, ok := condMap["$eq"]; ok && len(condMap) == 1 {
+		return v, true
+	}
+	return nil, false
+}
+
+// regexPrefix 提取以 ^ 开头的正则表达式的字面量前缀，如 "^2024-05" -> "2024-05"
+//
+// 含 | 的表达式可能匹配其他前缀，不能使用索引；字面量后紧跟 * ? { 时该字符可以不出现，不计入前缀。
+func regexPrefix(pattern string) (string, bool) {
+	if !strings.HasPrefix(pattern, "^") || strings.Contains(pattern, "|") {
+		return "", false
+	}
+	const meta = `.+*?()[]{}|\^$`
+	rest := pattern[1:]
+	var prefix strings.Builder
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+		if strings.IndexByte(meta, c) >= 0 {
+			break
+		}
+		if i+1 < len(rest) && strings.IndexByte("*?{", rest[i+1]) >= 0 {
+			break
+		}
+		prefix.WriteByte(c)
+	}
+	return prefix.String(), prefix.Len() > 0
+}
+
+// reverseIDs 返回倒序后的新切片
+func reverseIDs(ids []string) []string {
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[len(ids)-1-i] = id
+	}
+	return out
+}
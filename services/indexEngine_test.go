@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestIndexKeyIntegers 整数按整数编码：1 与 1.0 是同一个键，大于 2^53 的相邻 int64 是不同的键
+func TestIndexKeyIntegers(t *testing.T) {
+	const big = int64(1) << 53
+	same := [][2]interface{}{
+		{1, 1.0},
+		{int64(-3), float32(-3)},
+		{uint64(big), float64(big)},
+		{uint64(1) << 63, float64(1 << 63)},
+	}
+	for _, c := range same {
+		if indexKey(c[0]) != indexKey(c[1]) {
+			t.Errorf("indexKey(%v) = %s, indexKey(%v) = %s, want equal", c[0], indexKey(c[0]), c[1], indexKey(c[1]))
+		}
+	}
+	differ := [][2]interface{}{
+		{big, big + 1},
+		{int64(1<<62) + 1, int64(1 << 62)},
+		{uint64(1<<63) + 1, uint64(1 << 63)},
+		{1, 1.5},
+	}
+	for _, c := range differ {
+		if indexKey(c[0]) == indexKey(c[1]) {
+			t.Errorf("indexKey(%v) == indexKey(%v) = %s, want different", c[0], c[1], indexKey(c[0]))
+		}
+		if compareValues(c[0], c[1]) == 0 {
+			t.Errorf("compareValues(%v, %v) = 0", c[0], c[1])
+		}
+	}
+
+	// 唯一约束与等值查询
+	db := NewDBContext("", "", NewMemoryStorage())
+	if err := db.DBCreate("keys"); err != nil {
+		t.Fatalf("DBCreate: %v", err)
+	}
+	db.CurrentDB = "keys"
+	if err := db.CollectionCreate("c"); err != nil {
+		t.Fatalf("CollectionCreate: %v", err)
+	}
+	db.CurrentCollection = "c"
+	if err := db.SetUniqueField("c", "n"); err != nil {
+		t.Fatalf("SetUniqueField: %v", err)
+	}
+	for _, n := range []int64{big, big + 1} {
+		if _, err := db.InsertOne(Document{"n": n}); err != nil {
+			t.Fatalf("InsertOne %d: %v", n, err)
+		}
+	}
+	var dup *DuplicateKeyError
+	if _, err := db.InsertOne(Document{"n": big + 1}); !errors.As(err, &dup) {
+		t.Errorf("重复插入 %d 返回 %v，应为 *DuplicateKeyError", big+1, err)
+	}
+	docs, err := db.Find(map[string]interface{}{"n": big + 1}, nil)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(docs) != 1 {
+		t.Errorf("Find n = %d returned %d documents, want 1", big+1, len(docs))
+	}
+}
+
+// TestHashIndexOldKeyFormat 旧版本编码的哈希索引数据视为无效，读取时重建
+func TestHashIndexOldKeyFormat(t *testing.T) {
+	def, err := indexDefinition("n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := newCollectionIndex("n", def)
+	idx.build(map[string]Document{"a": {"_id": "a", "n": 1000000}})
+	if !idx.data.valid(idx.def) {
+		t.Fatal("newly built index is not valid")
+	}
+	old := idx.data
+	old.KeyFmt = 0
+	if old.valid(idx.def) {
+		t.Error("index data without key format is valid, want rebuild")
+	}
+}
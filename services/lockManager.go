@@ -0,0 +1,255 @@
+package services
+
+import (
+	"github.com/StephenChristianW/JsonDB/fileIO"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ==================== 锁管理 ====================
+//
+// 锁分三级，必须按以下顺序获取；同一级需要多把锁时按名称升序获取，因此不会出现循环等待：
+//
+//  1. 根目录锁：存储后端的读写锁。普通操作只持有读锁；Recover 这类需要独占全部数据的操作持有写锁
+//  2. 数据库锁：访问数据库中的集合时持有读锁；删除、重命名数据库以及事务持有写锁（事务另外持有所访问集合的文件锁，见 transaction.go）
+//  3. 集合锁：读文档持有读锁；写文档、修改索引与集合设置、创建、删除、重命名集合持有写锁
+//
+// 元数据目录（.config）另由 configFileIO 内部的 configMu 与 .config.lock 文件锁保护，只在单次读写配置期间持有，
+// 持有期间不会再获取上面任何一把锁，因此可以在持有任意锁时访问元数据目录。
+//
+// 三级锁都按存储后端区分：FileStorage 按根目录区分，同一根目录上的多个 DBManager 共用一组锁；
+// 不同存储后端（如不同 RootDir）互不影响，一个根目录上的 Recover 不会阻塞其他根目录上的操作。
+// 锁不可重入：已持有锁的代码只能调用不加锁的内部函数（注释中标明 "调用方需持有 ..." 的函数）。
+//
+// FileStorage 上每一级锁还对应 <root>/.locks 下的一个文件锁（根目录 .root.lock、数据库 <db>.lock、
+// 集合 <db>/<collection>.lock），模式与进程内的锁相同，使同一根目录上的多个进程互斥。
+// 进程内的锁全部获得后再按同样的顺序获得文件锁；等待文件锁超过 Options.LockTimeout 时返回
+// *fileIO.LockedError，已获得的锁全部释放。进程内同一集合的文件锁共用一个文件描述符；集合有未落盘的写入时
+// （见 collectionCache.go），操作结束后本进程仍持有该集合的排他文件锁，直到落盘。落盘只复用这把集合文件锁，
+// 不获取根目录与数据库的文件锁（见 flushLock）。
+
+// dbLockKey 存储后端中的一个数据库
+type dbLockKey struct {
+	scope interface{} // 见 lockScope
+	db    string
+}
+
+// lockScope 锁的作用范围：FileStorage 为根目录的绝对路径，其他存储后端为其自身
+func lockScope(store Storage) interface{} {
+	if fs, ok := store.(*FileStorage); ok {
+		if abs, err := filepath.Abs(fs.root); err == nil {
+			return abs
+		}
+		return filepath.Clean(fs.root)
+	}
+	return store
+}
+
+// dbLock 数据库锁及其下的集合锁
+type dbLock struct {
+	mu          sync.RWMutex
+	collections map[string]*sync.RWMutex
+}
+
+// fileLocker 支持跨进程文件锁的存储后端，见 FileStorage.lockRoot
+type fileLocker interface {
+	lockRoot(mode fileIO.LockMode) (*fileIO.FileLock, error)
+	lockDB(dbName string, mode fileIO.LockMode) (*fileIO.FileLock, error)
+	lockCollection(dbName, collectionName string, mode fileIO.LockMode) (func(), error)
+}
+
+// heldLocks 已获得的锁的释放函数，按获得的逆序释放
+type heldLocks []func()
+
+func (h *heldLocks) push(release func()) {
+	*h = append(*h, release)
+}
+
+// file 记录已获得的文件锁，err 非 nil 时原样返回
+func (h *heldLocks) file(l *fileIO.FileLock, err error) error {
+	if err != nil {
+		return err
+	}
+	h.push(func() { _ = l.Unlock() })
+	return nil
+}
+
+func (h heldLocks) release() {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]()
+	}
+}
+
+// lockManager 按需创建根目录锁、数据库锁与集合锁，创建后不再回收
+type lockManager struct {
+	mu    sync.Mutex
+	roots map[interface{}]*sync.RWMutex // lockScope -> 根目录锁
+	dbs   map[dbLockKey]*dbLock
+}
+
+var locks = &lockManager{roots: make(map[interface{}]*sync.RWMutex), dbs: make(map[dbLockKey]*dbLock)}
+
+// root 根目录锁
+func (m *lockManager) root(store Storage) *sync.RWMutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	scope := lockScope(store)
+	mu, ok := m.roots[scope]
+	if !ok {
+		mu = &sync.RWMutex{}
+		m.roots[scope] = mu
+	}
+	return mu
+}
+
+// database 数据库锁
+func (m *lockManager) database(store Storage, dbName string) *dbLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := dbLockKey{scope: lockScope(store), db: dbName}
+	l, ok := m.dbs[key]
+	if !ok {
+		l = &dbLock{collections: make(map[string]*sync.RWMutex)}
+		m.dbs[key] = l
+	}
+	return l
+}
+
+// collection 集合锁
+func (m *lockManager) collection(store Storage, dbName, collectionName string) *sync.RWMutex {
+	l := m.database(store, dbName)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mu, ok := l.collections[collectionName]
+	if !ok {
+		mu = &sync.RWMutex{}
+		l.collections[collectionName] = mu
+	}
+	return mu
+}
+
+// readLock 获取当前数据库中一个集合的读锁，返回释放函数
+func (db *DBContext) readLock(collectionName string) (func(), error) {
+	return db.lockCollections(false, collectionName)
+}
+
+// writeLock 获取当前数据库中一个或多个集合的写锁，返回释放函数
+func (db *DBContext) writeLock(collectionNames ...string) (func(), error) {
+	return db.lockCollections(true, collectionNames...)
+}
+
+// lockCollections 依次获取根目录读锁、当前数据库的读锁以及各集合的锁（按名称升序）
+func (db *DBContext) lockCollections(write bool, collectionNames ...string) (func(), error) {
+	names := sortedUnique(collectionNames)
+	store := db.store()
+	mode := fileIO.LockShared
+	if write {
+		mode = fileIO.LockExclusive
+	}
+
+	held := db.lockLocal(write, names)
+	if fl, ok := store.(fileLocker); ok {
+		err := held.file(fl.lockRoot(fileIO.LockShared))
+		if err == nil {
+			err = held.file(fl.lockDB(db.CurrentDB, fileIO.LockShared))
+		}
+		for _, name := range names {
+			if err == nil {
+				var release func()
+				if release, err = fl.lockCollection(db.CurrentDB, name, mode); err == nil {
+					held.push(release)
+				}
+			}
+		}
+		if err != nil {
+			held.release()
+			return nil, err
+		}
+	}
+	return held.release, nil
+}
+
+// lockLocal 依次获取进程内的根目录读锁、当前数据库的读锁以及各集合的锁，names 需已按名称升序排列
+func (db *DBContext) lockLocal(write bool, names []string) heldLocks {
+	store := db.store()
+	var held heldLocks
+	rootMu := locks.root(store)
+	rootMu.RLock()
+	held.push(rootMu.RUnlock)
+	dbMu := &locks.database(store, db.CurrentDB).mu
+	dbMu.RLock()
+	held.push(dbMu.RUnlock)
+	for _, name := range names {
+		mu := locks.collection(store, db.CurrentDB, name)
+		if write {
+			mu.Lock()
+			held.push(mu.Unlock)
+		} else {
+			mu.RLock()
+			held.push(mu.RUnlock)
+		}
+	}
+	return held
+}
+
+// flushLock 获取落盘一个集合所需的锁：进程内的锁与 writeLock 相同，文件锁只获取集合的排他锁
+//
+// 集合有未落盘的写入时本进程一直持有该集合的排他文件锁，WAL 中也有尚未检查点的记录，其他进程既不会读写该集合，
+// 也不会重放或清空日志，因此落盘不需要根目录与数据库的文件锁。若也获取数据库文件锁，其他进程的事务持有数据库
+// 排他文件锁并等待该集合时，落盘又在等待事务，双方只能等到超时。
+func (db *DBContext) flushLock(collectionName string) (func(), error) {
+	held := db.lockLocal(true, []string{collectionName})
+	if fl, ok := db.store().(fileLocker); ok {
+		release, err := fl.lockCollection(db.CurrentDB, collectionName, fileIO.LockExclusive)
+		if err != nil {
+			held.release()
+			return nil, err
+		}
+		held.push(release)
+	}
+	return held.release, nil
+}
+
+// lockDatabases 依次获取根目录读锁以及各数据库的写锁（按名称升序），返回释放函数
+func (db *DBContext) lockDatabases(dbNames ...string) (func(), error) {
+	names := sortedUnique(dbNames)
+	store := db.store()
+
+	var held heldLocks
+	rootMu := locks.root(store)
+	rootMu.RLock()
+	held.push(rootMu.RUnlock)
+	for _, name := range names {
+		mu := &locks.database(store, name).mu
+		mu.Lock()
+		held.push(mu.Unlock)
+	}
+
+	if fl, ok := store.(fileLocker); ok {
+		err := held.file(fl.lockRoot(fileIO.LockShared))
+		for _, name := range names {
+			if err == nil {
+				err = held.file(fl.lockDB(name, fileIO.LockExclusive))
+			}
+		}
+		if err != nil {
+			held.release()
+			return nil, err
+		}
+	}
+	return held.release, nil
+}
+
+// sortedUnique 去重并升序排列
+func sortedUnique(names []string) []string {
+	out := append([]string(nil), names...)
+	sort.Strings(out)
+	uniq := out[:0]
+	for i, name := range out {
+		if i == 0 || name != out[i-1] {
+			uniq = append(uniq, name)
+		}
+	}
+	return uniq
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StephenChristianW/JsonDB/config"
+)
+
+// 并发写入测试：多个 DBContext 共用同一存储后端，同时写不同集合与同一集合，
+// 结束并落盘后检查每个集合的文档数及元数据目录中的文档数量。用 go test -race 运行。
+
+const (
+	lockTestDB      = "locktest"
+	lockTestWorkers = 4  // 每组并发写入的 goroutine 数
+	lockTestWrites  = 25 // 每个 goroutine 的写入次数
+)
+
+func TestConcurrentWriters(t *testing.T) {
+	cases := []struct {
+		name  string
+		store func(t *testing.T) Storage
+	}{
+		{"memory", func(t *testing.T) Storage { return NewMemoryStorage() }},
+		{"file", func(t *testing.T) Storage {
+			return NewFileStorage(config.Options{RootDir: t.TempDir()}.Normalize())
+		}},
+		{"file-deferred", func(t *testing.T) Storage {
+			return NewFileStorage(config.Options{RootDir: t.TempDir(), Flush: config.FlushPolicy{Writes: 7}}.Normalize())
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runConcurrentWriters(t, c.store(t))
+		})
+	}
+}
+
+func runConcurrentWriters(t *testing.T, store Storage) {
+	own := []string{"own0", "own1", "own2", "own3"}
+	setup := NewDBContext("", "", store)
+	if err := setup.DBCreate(lockTestDB); err != nil {
+		t.Fatalf("DBCreate: %v", err)
+	}
+	setup.CurrentDB = lockTestDB
+	for _, name := range append([]string{"shared"}, own...) {
+		if err := setup.CollectionCreate(name); err != nil {
+			t.Fatalf("CollectionCreate %s: %v", name, err)
+		}
+	}
+	setup.CurrentCollection = "shared"
+	if _, err := setup.InsertOne(Document{"counter": true, "n": 0}); err != nil {
+		t.Fatalf("InsertOne: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	run := func(fn func(db *DBContext) error, collectionName string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 每个 goroutine 使用自己的 DBContext
+			if err := fn(NewDBContext(lockTestDB, collectionName, store)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// 不同集合：每个集合一个写入者
+	for _, name := range own {
+		run(func(db *DBContext) error {
+			for i := 0; i < lockTestWrites; i++ {
+				if _, err := db.InsertOne(Document{"i": i}); err != nil {
+					return fmt.Errorf("%s InsertOne: %w", db.CurrentCollection, err)
+				}
+			}
+			return nil
+		}, name)
+	}
+
+	// 同一集合：插入与对同一文档的计数更新交替进行
+	for w := 0; w < lockTestWorkers; w++ {
+		run(func(db *DBContext) error {
+			for i := 0; i < lockTestWrites; i++ {
+				if _, err := db.InsertOne(Document{"worker": w, "i": i}); err != nil {
+					return fmt.Errorf("shared InsertOne: %w", err)
+				}
+				update := Document{"$inc": map[string]interface{}{"n": 1}}
+				if _, err := db.UpdateOne(map[string]interface{}{"counter": true}, update); err != nil {
+					return fmt.Errorf("shared UpdateOne: %w", err)
+				}
+			}
+			return nil
+		}, "shared")
+	}
+
+	// 事务同时写两个集合，持有数据库写锁，与上面的集合锁互斥
+	run(func(db *DBContext) error {
+		for i := 0; i < lockTestWrites; i++ {
+			err := db.WithTransaction(func(tx *Tx) error {
+				for _, name := range []string{"shared", own[0]} {
+					c, err := tx.Collection(name)
+					if err != nil {
+						return err
+					}
+					if _, err := c.InsertOne(Document{"tx": i}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("WithTransaction: %w", err)
+			}
+		}
+		return nil
+	}, "shared")
+
+	// 读取者：并发读取不应报错
+	for _, name := range []string{"shared", own[1]} {
+		run(func(db *DBContext) error {
+			for i := 0; i < lockTestWrites; i++ {
+				if _, err := db.Find(nil, nil); err != nil {
+					return fmt.Errorf("%s Find: %w", db.CurrentCollection, err)
+				}
+			}
+			return nil
+		}, name)
+	}
+	wg.Wait()
+
+	// 推迟写入的文档数量在落盘时写入元数据目录
+	if err := FlushAll(store); err != nil {
+		t.Fatalf("FlushAll: %v", err)
+	}
+
+	want := map[string]int{"shared": 1 + lockTestWorkers*lockTestWrites + lockTestWrites}
+	for _, name := range own {
+		want[name] = lockTestWrites
+	}
+	want[own[0]] += lockTestWrites
+
+	for name, n := range want {
+		db := NewDBContext(lockTestDB, name, store)
+		docs, err := db.Find(nil, nil)
+		if err != nil {
+			t.Fatalf("%s Find: %v", name, err)
+		}
+		if len(docs) != n {
+			t.Errorf("%s: %d documents, want %d", name, len(docs), n)
+		}
+		count, err := catalogDocCount(store, name)
+		if err != nil {
+			t.Fatalf("%s catalog: %v", name, err)
+		}
+		if count != n {
+			t.Errorf("%s: catalog docCount %d, want %d", name, count, n)
+		}
+	}
+
+	counter, err := NewDBContext(lockTestDB, "shared", store).FindOne(map[string]interface{}{"counter": true})
+	if err != nil {
+		t.Fatalf("FindOne counter: %v", err)
+	}
+	if n, _ := toFloat(counter["n"]); int(n) != lockTestWorkers*lockTestWrites {
+		t.Errorf("counter = %v, want %d", counter["n"], lockTestWorkers*lockTestWrites)
+	}
+}
+
+// TestRootLocksIndependent 一个根目录的根目录锁被独占（如 Recover 期间）时，其他根目录上的读写不受影响
+func TestRootLocksIndependent(t *testing.T) {
+	busy := NewFileStorage(config.Options{RootDir: t.TempDir()}.Normalize())
+	other := NewFileStorage(config.Options{RootDir: t.TempDir()}.Normalize())
+	rootMu := locks.root(busy)
+	rootMu.Lock()
+	defer rootMu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		db := NewDBContext("", "", other)
+		err := db.DBCreate(lockTestDB)
+		if err == nil {
+			db.CurrentDB = lockTestDB
+			err = db.CollectionCreate("c")
+		}
+		if err == nil {
+			db.CurrentCollection = "c"
+			_, err = db.InsertOne(Document{"n": 1})
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("其他根目录上的写入被阻塞")
+	}
+}
+
+// catalogDocCount 读取元数据目录中记录的集合文档数量
+func catalogDocCount(store Storage, collectionName string) (int, error) {
+	raw, err := store.ReadCatalog()
+	if err != nil {
+		return 0, err
+	}
+	var conf struct {
+		Databases map[string]struct {
+			Collections map[string]struct {
+				DocsCount int `json:"fields_count"`
+			} `json:"collections"`
+		} `json:"databases"`
+	}
+	if err := json.Unmarshal(raw, &conf); err != nil {
+		return 0, err
+	}
+	col, ok := conf.Databases[lockTestDB].Collections[collectionName]
+	if !ok {
+		return 0, fmt.Errorf("集合未登记: %s", collectionName)
+	}
+	return col.DocsCount, nil
+}
@@ -2,11 +2,8 @@ package services
 
 import (
 	"errors"
-	"github.com/StephenChristianW/JsonDB/config"
-	"os"
-	"path/filepath"
+	ConfigFile "github.com/StephenChristianW/JsonDB/fileIO/configFileIO"
 	"regexp"
-	"strings"
 )
 
 // ==================== 数据库上下文操作 ====================
@@ -23,11 +20,14 @@ type DBContextInterface interface {
 type DBContext struct {
 	CurrentDB         string // 当前选中的数据库
 	CurrentCollection string // 当前选中的集合
+
+	storage Storage             // 存储后端，为 nil 时使用 DefaultStorage()
+	meta    *ConfigFile.Catalog // 存储后端上的元数据目录
 }
 
-// ==================== 数据库路径相关 ====================
+// ==================== 数据库名校验 ====================
 
-// getDBFilePath 获取指定数据库的路径
+// checkDBName 校验数据库名
 //
 // 参数：
 //
@@ -35,17 +35,16 @@ type DBContext struct {
 //
 // 返回值：
 //
-//	string - 数据库路径
 //	error - 数据库名为空时返回错误
-func (db *DBContext) getDBFilePath(dbName string) (string, error) {
+func (db *DBContext) checkDBName(dbName string) error {
 	if dbName == "" {
-		return "", errors.New("请输入正确数据库名")
+		return errors.New("请输入正确数据库名")
 	}
 	// 如果当前上下文未指定数据库，则默认使用传入的数据库
 	if db.CurrentDB == "" {
 		db.CurrentDB = dbName
 	}
-	return filepath.Join(config.GetRootDir(), dbName), nil
+	return nil
 }
 
 // ==================== 数据库切换 ====================
@@ -89,7 +88,7 @@ func (db *DBContext) switchCollection(collectionName string) error {
 	return nil
 }
 
-// getCollectionFilePath 获取当前集合的文件路径
+// checkCollectionName 校验当前数据库与集合名
 //
 // 参数：
 //
@@ -97,17 +96,15 @@ func (db *DBContext) switchCollection(collectionName string) error {
 //
 // 返回值：
 //
-//	string - 集合文件路径
 //	error - 数据库未选择或集合名为空时返回错误
-func (db *DBContext) getCollectionFilePath(collectionName string) (string, error) {
+func (db *DBContext) checkCollectionName(collectionName string) error {
 	if db.CurrentDB == "" {
-		return "", errors.New("未选择数据库")
+		return errors.New("未选择数据库")
 	}
 	if collectionName == "" {
-		return "", errors.New("未选择集合")
+		return errors.New("未选择集合")
 	}
-	path := filepath.Join(config.GetRootDir(), db.CurrentDB, collectionName+".json")
-	return path, nil
+	return nil
 }
 
 // ==================== 数据库列表 ====================
@@ -117,9 +114,9 @@ func (db *DBContext) getCollectionFilePath(collectionName string) (string, error
 // 返回值：
 //
 //	map[string]struct{} - 数据库名称集合
-//	error - 根目录读取失败或无数据库时返回错误
-func getDBs() (map[string]struct{}, error) {
-	dbNames, err := os.ReadDir(config.GetRootDir())
+//	error - 存储读取失败或无数据库时返回错误
+func (db *DBContext) getDBs() (map[string]struct{}, error) {
+	dbNames, err := db.store().ListDBs()
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +126,7 @@ func getDBs() (map[string]struct{}, error) {
 
 	var dbs = make(map[string]struct{})
 	for _, v := range dbNames {
-		dbs[v.Name()] = struct{}{}
+		dbs[v] = struct{}{}
 	}
 	return dbs, nil
 }
@@ -147,22 +144,15 @@ func getDBs() (map[string]struct{}, error) {
 //	[]string - 集合名称切片
 //	error - 数据库不存在或无集合时返回错误
 func (db *DBContext) getCollectionNames(dbName string) ([]string, error) {
-	dbPath, err := db.getDBFilePath(dbName)
-	if err != nil {
+	if err := db.checkDBName(dbName); err != nil {
 		return nil, err
 	}
 
-	files, err := os.ReadDir(dbPath)
+	nameSlice, err := db.store().ListCollections(dbName)
 	if err != nil {
 		return nil, err
 	}
 
-	nameSlice := make([]string, 0)
-	for _, file := range files {
-		colName := strings.TrimSuffix(file.Name(), ".json") // 去掉 .json 后缀
-		nameSlice = append(nameSlice, colName)
-	}
-
 	if len(nameSlice) == 0 {
 		return nil, errors.New("数据库: " + dbName + "中无集合")
 	}
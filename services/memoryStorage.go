@@ -0,0 +1,266 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// MemoryStorage 纯内存存储后端
+//
+// 适用于单元测试与临时缓存，进程退出后数据丢失。
+// 读写时都会深拷贝文档，调用方修改返回值不会影响已保存的数据。
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	catalog []byte
+	dbs     map[string]*memoryDB
+}
+
+type memoryDB struct {
+	collections map[string]map[string]Document
+	indexes     map[string]map[string][]byte // 集合名 -> 索引名 -> 索引数据
+}
+
+// NewMemoryStorage 创建空的内存存储
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{dbs: make(map[string]*memoryDB)}
+}
+
+func (s *MemoryStorage) getDB(dbName string) (*memoryDB, error) {
+	db, ok := s.dbs[dbName]
+	if !ok {
+		return nil, errors.New("数据库: " + dbName + " 不存在")
+	}
+	return db, nil
+}
+
+// ---------------- 数据库 ----------------
+
+func (s *MemoryStorage) CreateDB(dbName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.dbs[dbName]; !ok {
+		s.dbs[dbName] = &memoryDB{
+			collections: make(map[string]map[string]Document),
+			indexes:     make(map[string]map[string][]byte),
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) DeleteDB(dbName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dbs, dbName)
+	return nil
+}
+
+func (s *MemoryStorage) RenameDB(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.getDB(oldName)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.dbs[newName]; ok {
+		return errors.New("同名数据库已存在: " + newName)
+	}
+	s.dbs[newName] = db
+	delete(s.dbs, oldName)
+	return nil
+}
+
+func (s *MemoryStorage) ListDBs() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.dbs))
+	for name := range s.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *MemoryStorage) DBExists(dbName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.dbs[dbName]
+	return ok
+}
+
+// Recover 内存存储没有需要恢复的内容
+func (s *MemoryStorage) Recover() error {
+	return nil
+}
+
+// ---------------- 集合 ----------------
+
+func (s *MemoryStorage) CreateCollection(dbName, collectionName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.getDB(dbName)
+	if err != nil {
+		return err
+	}
+	db.collections[collectionName] = make(map[string]Document)
+	return nil
+}
+
+func (s *MemoryStorage) DeleteCollection(dbName, collectionName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.getDB(dbName)
+	if err != nil {
+		return err
+	}
+	if _, ok := db.collections[collectionName]; !ok {
+		return errors.New("集合: " + collectionName + " 不存在")
+	}
+	delete(db.collections, collectionName)
+	delete(db.indexes, collectionName)
+	return nil
+}
+
+func (s *MemoryStorage) RenameCollection(dbName, oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.getDB(dbName)
+	if err != nil {
+		return err
+	}
+	data, ok := db.collections[oldName]
+	if !ok {
+		return errors.New("集合: " + oldName + " 不存在")
+	}
+	db.collections[newName] = data
+	delete(db.collections, oldName)
+	if idx, ok := db.indexes[oldName]; ok {
+		db.indexes[newName] = idx
+		delete(db.indexes, oldName)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ListCollections(dbName string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db, err := s.getDB(dbName)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(db.collections))
+	for name := range db.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *MemoryStorage) CollectionExists(dbName, collectionName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db, ok := s.dbs[dbName]
+	if !ok {
+		return false
+	}
+	_, ok = db.collections[collectionName]
+	return ok
+}
+
+func (s *MemoryStorage) LoadCollection(dbName, collectionName string) (map[string]Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := make(map[string]Document)
+	db, ok := s.dbs[dbName]
+	if !ok {
+		return data, nil
+	}
+	for id, doc := range db.collections[collectionName] {
+		data[id] = cloneDocument(doc)
+	}
+	return data, nil
+}
+
+func (s *MemoryStorage) Commit(dbName string, writes ...CollectionWrite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.getDB(dbName)
+	if err != nil {
+		return err
+	}
+	for _, w := range writes {
+		if w.Data != nil {
+			saved := make(map[string]Document, len(w.Data))
+			for id, doc := range w.Data {
+				saved[id] = cloneDocument(doc)
+			}
+			db.collections[w.Collection] = saved
+		}
+		if len(w.Indexes) > 0 && db.indexes[w.Collection] == nil {
+			db.indexes[w.Collection] = make(map[string][]byte)
+		}
+		for name, data := range w.Indexes {
+			db.indexes[w.Collection][name] = append([]byte(nil), data...)
+		}
+	}
+	return nil
+}
+
+// ---------------- 元数据目录 ----------------
+
+func (s *MemoryStorage) ReadCatalog() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]byte(nil), s.catalog...), nil
+}
+
+func (s *MemoryStorage) WriteCatalog(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.catalog = append([]byte(nil), data...)
+	return nil
+}
+
+// ---------------- 索引 ----------------
+
+func (s *MemoryStorage) LoadIndex(dbName, collectionName, name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db, ok := s.dbs[dbName]
+	if !ok {
+		return nil, nil
+	}
+	data, ok := db.indexes[collectionName][name]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *MemoryStorage) SaveIndex(dbName, collectionName, name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.getDB(dbName)
+	if err != nil {
+		return err
+	}
+	if db.indexes[collectionName] == nil {
+		db.indexes[collectionName] = make(map[string][]byte)
+	}
+	db.indexes[collectionName][name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *MemoryStorage) DeleteIndex(dbName, collectionName, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if db, ok := s.dbs[dbName]; ok {
+		delete(db.indexes[collectionName], name)
+	}
+	return nil
+}
+
+// ---------------- 错误日志 ----------------
+
+// LogError 内存存储不落盘错误日志，错误仍会返回给调用方
+func (s *MemoryStorage) LogError(errMsg, funcName, errorLoc string) {}
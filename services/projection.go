@@ -0,0 +1,322 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ==================== 投影 projection ====================
+//
+// 投影语法与 MongoDB 相同，键为字段路径（支持点号），值为：
+//
+//   - 1 / true：包含模式，只返回列出的字段；0 / false：排除模式，返回其余字段。
+//     两种模式不能混用，_id 除外：_id 默认返回，{"_id": 0} 在任一模式下都可去掉 _id；
+//     只有 {"_id": 1} 而没有其他包含或排除的字段时为包含模式，只返回 _id
+//   - {"$slice": n}：数组字段只返回前 n 个元素，n 为负数时返回最后 |n| 个；
+//     {"$slice": [skip, limit]}：跳过 skip 个（负数从末尾计）后返回 limit 个。不影响投影模式
+//   - {"$elemMatch": 条件}：只返回数组字段中第一个满足条件的对象元素，没有满足的元素时不返回该字段。
+//     只能用于顶层字段，属于包含模式
+//
+// 点号路径经过对象数组时对每个对象元素分别投影，包含模式下数组中的非对象元素会被丢弃。
+
+// sliceSpec $slice 投影，skip 为负数时从数组末尾计
+type sliceSpec struct {
+	skip, limit int
+}
+
+// projection 解析后的投影
+type projection struct {
+	include   bool                              // true 为包含模式，false 为排除模式
+	paths     [][]string                        // 包含或排除的字段路径，不含 _id
+	hideID    bool                              // 不返回 _id
+	slices    map[string]sliceSpec              // 字段路径 -> $slice
+	elemMatch map[string]map[string]interface{} // 顶层字段 -> $elemMatch 条件
+}
+
+// findProjection 合并 FindOptions 中的 Fields 与 Projection，未指定投影时返回 nil
+func findProjection(opts *FindOptions) (*projection, error) {
+	if opts == nil || len(opts.Fields) == 0 && len(opts.Projection) == 0 {
+		return nil, nil
+	}
+	spec := make(map[string]interface{}, len(opts.Fields)+len(opts.Projection))
+	for _, field := range opts.Fields {
+		spec[field] = 1
+	}
+	for field, v := range opts.Projection {
+		spec[field] = v
+	}
+	return parseProjection(spec)
+}
+
+// parseProjection 解析并校验投影
+func parseProjection(spec map[string]interface{}) (*projection, error) {
+	p := &projection{slices: make(map[string]sliceSpec), elemMatch: make(map[string]map[string]interface{})}
+	var included, excluded, all []string
+	idIncluded := false // 显式指定了 {"_id": 1}
+	for _, field := range sortedKeys(spec) {
+		if field == "" || strings.HasPrefix(field, "$") || strings.HasPrefix(field, ".") ||
+			strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			return nil, errors.New("投影字段名无效: " + field)
+		}
+		v := spec[field]
+		if opMap := toMap(v); opMap != nil {
+			if len(opMap) != 1 {
+				return nil, errors.New("投影操作符只能有一个: " + field)
+			}
+			switch {
+			case opMap["$slice"] != nil:
+				s, err := parseSlice(opMap["$slice"])
+				if err != nil {
+					return nil, errors.New(err.Error() + ": " + field)
+				}
+				p.slices[field] = s
+			case opMap["$elemMatch"] != nil:
+				cond := toMap(opMap["$elemMatch"])
+				if cond == nil {
+					return nil, errors.New("$elemMatch 条件必须是对象: " + field)
+				}
+				if strings.Contains(field, ".") {
+					return nil, errors.New("$elemMatch 投影只能用于顶层字段: " + field)
+				}
+				p.elemMatch[field] = cond
+				included = append(included, field)
+			default:
+				return nil, errors.New("不支持的投影操作符: " + field)
+			}
+			all = append(all, field)
+			continue
+		}
+
+		include, ok := projectionFlag(v)
+		if !ok {
+			return nil, errors.New("投影值只能是 0、1、true、false 或投影操作符: " + field)
+		}
+		if field == "_id" {
+			p.hideID, idIncluded = !include, include
+			continue
+		}
+		if include {
+			included = append(included, field)
+		} else {
+			excluded = append(excluded, field)
+		}
+		all = append(all, field)
+	}
+
+	if len(included) > 0 && len(excluded) > 0 {
+		return nil, errors.New("投影不能同时包含和排除字段（_id 除外）")
+	}
+	if a, b, conflict := pathConflict(all); conflict {
+		return nil, errors.New("投影路径冲突: " + a + " 与 " + b)
+	}
+	p.include = len(included) > 0 || idIncluded && len(excluded) == 0
+	paths := excluded
+	if p.include {
+		// 包含模式下 $slice 字段同样需要返回
+		paths = append(included, sortedSliceKeys(p.slices)...)
+	}
+	for _, field := range paths {
+		if _, ok := p.elemMatch[field]; ok {
+			continue
+		}
+		p.paths = append(p.paths, strings.Split(field, "."))
+	}
+	return p, nil
+}
+
+// projectionFlag 解析 0/1/true/false
+func projectionFlag(v interface{}) (bool, bool) {
+	if b, ok := v.(bool); ok {
+		return b, true
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0, true
+	}
+	return false, false
+}
+
+// parseSlice 解析 $slice 的参数：n 或 [skip, limit]
+func parseSlice(v interface{}) (sliceSpec, error) {
+	if n, ok := toInt(v); ok {
+		if n < 0 {
+			return sliceSpec{skip: n, limit: -n}, nil
+		}
+		return sliceSpec{limit: n}, nil
+	}
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) != 2 {
+		return sliceSpec{}, errors.New("$slice 参数必须是整数或 [skip, limit]")
+	}
+	skip, ok1 := toInt(arr[0])
+	limit, ok2 := toInt(arr[1])
+	if !ok1 || !ok2 {
+		return sliceSpec{}, errors.New("$slice 参数必须是整数或 [skip, limit]")
+	}
+	if limit <= 0 {
+		return sliceSpec{}, errors.New("$slice 的 limit 必须大于 0")
+	}
+	return sliceSpec{skip: skip, limit: limit}, nil
+}
+
+// toInt 把整数值（含 JSON 读出的整数 float64）转换为 int
+func toInt(v interface{}) (int, bool) {
+	f, ok := toFloat(v)
+	if !ok || f != float64(int(f)) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// pathConflict 找出相同或互为父子的两个字段路径，如 "address" 与 "address.city"
+func pathConflict(fields []string) (string, string, bool) {
+	for i, a := range fields {
+		for _, b := range fields[i+1:] {
+			if a == b || strings.HasPrefix(b, a+".") || strings.HasPrefix(a, b+".") {
+				return a, b, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// sortedSliceKeys $slice 字段路径，按名称排序
+func sortedSliceKeys(m map[string]sliceSpec) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// apply 对文档应用投影，返回新文档，不修改 doc
+func (p *projection) apply(doc Document) Document {
+	var out Document
+	if p.include {
+		out = make(Document)
+		if id, ok := doc["_id"]; ok {
+			out["_id"] = cloneValue(id)
+		}
+		for _, path := range p.paths {
+			includePath(out, doc, path)
+		}
+		for field, cond := range p.elemMatch {
+			if elem, ok := firstElemMatch(doc[field], cond); ok {
+				out[field] = []interface{}{elem}
+			}
+		}
+	} else {
+		out = cloneDocument(doc)
+		for _, path := range p.paths {
+			excludePath(out, path)
+		}
+	}
+	if p.hideID {
+		delete(out, "_id")
+	}
+	for field, s := range p.slices {
+		slicePath(out, strings.Split(field, "."), s)
+	}
+	return out
+}
+
+// includePath 把 src 中 path 对应的值复制到 dst，经过对象数组时对每个对象元素分别复制
+func includePath(dst, src map[string]interface{}, path []string) {
+	val, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = cloneValue(val)
+		return
+	}
+	if sub := toMap(val); sub != nil {
+		target := toMap(dst[path[0]])
+		if target == nil {
+			target = make(map[string]interface{})
+			dst[path[0]] = target
+		}
+		includePath(target, sub, path[1:])
+		return
+	}
+	arr, ok := val.([]interface{})
+	if !ok {
+		return
+	}
+	// 与数组中的对象元素一一对应，多个路径经过同一数组时复用
+	target, _ := dst[path[0]].([]interface{})
+	if target == nil {
+		for _, item := range arr {
+			if toMap(item) != nil {
+				target = append(target, make(map[string]interface{}))
+			}
+		}
+		if target == nil {
+			target = []interface{}{}
+		}
+		dst[path[0]] = target
+	}
+	i := 0
+	for _, item := range arr {
+		if sub := toMap(item); sub != nil {
+			includePath(target[i].(map[string]interface{}), sub, path[1:])
+			i++
+		}
+	}
+}
+
+// excludePath 从 m 中删除 path 对应的字段，经过对象数组时对每个对象元素分别删除
+func excludePath(m map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	val := m[path[0]]
+	if sub := toMap(val); sub != nil {
+		excludePath(sub, path[1:])
+		return
+	}
+	if arr, ok := val.([]interface{}); ok {
+		for _, item := range arr {
+			if sub := toMap(item); sub != nil {
+				excludePath(sub, path[1:])
+			}
+		}
+	}
+}
+
+// slicePath 截取 path 对应的数组字段，字段不存在或不是数组时不做任何操作
+func slicePath(m map[string]interface{}, path []string, s sliceSpec) {
+	for _, p := range path[:len(path)-1] {
+		if m = toMap(m[p]); m == nil {
+			return
+		}
+	}
+	last := path[len(path)-1]
+	arr, ok := m[last].([]interface{})
+	if !ok {
+		return
+	}
+	start := s.skip
+	if start < 0 {
+		start = max(len(arr)+start, 0)
+	}
+	start = min(start, len(arr))
+	end := min(start+s.limit, len(arr))
+	m[last] = arr[start:end]
+}
+
+// firstElemMatch 数组中第一个满足条件的对象元素
+func firstElemMatch(v interface{}, cond map[string]interface{}) (interface{}, bool) {
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	for _, item := range arr {
+		if sub := toMap(item); sub != nil && matchDoc(sub, cond) {
+			return cloneValue(item), true
+		}
+	}
+	return nil, false
+}
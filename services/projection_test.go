@@ -0,0 +1,33 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestProjectionID _id 单独出现时的投影模式
+func TestProjectionID(t *testing.T) {
+	doc := Document{"_id": "1", "a": 1, "b": 2}
+	cases := []struct {
+		name string
+		spec map[string]interface{}
+		want Document
+	}{
+		{"只包含 _id", map[string]interface{}{"_id": 1}, Document{"_id": "1"}},
+		{"只包含 _id（true）", map[string]interface{}{"_id": true}, Document{"_id": "1"}},
+		{"排除 _id", map[string]interface{}{"_id": 0}, Document{"a": 1, "b": 2}},
+		{"_id 与排除字段", map[string]interface{}{"_id": 1, "a": 0}, Document{"_id": "1", "b": 2}},
+		{"_id 与包含字段", map[string]interface{}{"_id": 1, "a": 1}, Document{"_id": "1", "a": 1}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := parseProjection(c.spec)
+			if err != nil {
+				t.Fatalf("parseProjection: %v", err)
+			}
+			if got := p.apply(doc); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("apply = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/StephenChristianW/JsonDB/config"
+	ConfigFile "github.com/StephenChristianW/JsonDB/fileIO/configFileIO"
+)
+
+// ==================== 存储后端 ====================
+
+const (
+	ChangePut    = "put" // 写入（插入或覆盖）文档
+	ChangeDelete = "del" // 删除文档
+)
+
+// Change 单个文档变更，存储后端可据此做增量持久化（如写 WAL）
+type Change struct {
+	Op  string   // ChangePut / ChangeDelete
+	ID  string   // 文档 _id
+	Doc Document // ChangePut 时为文档最终内容
+}
+
+// CollectionWrite 一次写操作对单个集合需要持久化的全部内容
+type CollectionWrite struct {
+	Collection string              // 集合名
+	Data       map[string]Document // 集合写入后的全部文档，提交后存储后端可能继续持有，调用方不能再修改
+	Changes    []Change            // 本次变更，可为空
+	// Indexes 本次需要更新的索引数据（索引名 -> 数据），可为空；数据为 nil 表示索引有变化但由存储后端
+	// 之后再保存（见 metaDeferrer），已保存的旧数据需要先删除
+	Indexes map[string][]byte
+
+	parsed indexSet // 写入后的全部已解析索引，缓存集合的存储后端随集合一起缓存（见 indexCache）
+}
+
+// Storage 存储后端接口
+//
+// 覆盖集合文档、元数据目录（.config）和索引数据三类持久化内容，
+// DBContext 的所有读写都通过它完成，不直接访问文件系统。
+type Storage interface {
+	// ==================== 数据库 ====================
+
+	CreateDB(dbName string) error           // 创建数据库，已存在时不报错
+	DeleteDB(dbName string) error           // 删除数据库及其全部集合、索引
+	RenameDB(oldName, newName string) error // 重命名数据库
+	ListDBs() ([]string, error)             // 列出所有数据库
+	DBExists(dbName string) bool            // 数据库是否存在
+	Recover() error                         // 恢复上次异常退出前已提交但未落盘的写入
+
+	// ==================== 集合文档 ====================
+
+	CreateCollection(dbName, collectionName string) error                      // 创建空集合
+	DeleteCollection(dbName, collectionName string) error                      // 删除集合及其索引
+	RenameCollection(dbName, oldName, newName string) error                    // 重命名集合
+	ListCollections(dbName string) ([]string, error)                           // 列出数据库中的集合
+	CollectionExists(dbName, collectionName string) bool                       // 集合是否存在
+	LoadCollection(dbName, collectionName string) (map[string]Document, error) // 读取集合全部文档，映射归调用方所有，文档只读
+	// Commit 提交同一数据库内一个或多个集合的写入，集合文档与索引数据一起生效；返回错误时全部写入都未生效
+	Commit(dbName string, writes ...CollectionWrite) error
+
+	// ==================== 元数据目录 ====================
+
+	ConfigFile.CatalogStore
+
+	// ==================== 索引数据 ====================
+
+	LoadIndex(dbName, collectionName, name string) ([]byte, error) // 读取索引数据，不存在时返回 nil, nil
+	SaveIndex(dbName, collectionName, name string, data []byte) error
+	DeleteIndex(dbName, collectionName, name string) error
+
+	// ==================== 错误日志 ====================
+
+	LogError(errMsg, funcName, errorLoc string) // 记录一条错误信息
+}
+
+var (
+	defaultStorageOnce sync.Once
+	defaultStorage     Storage
+)
+
+// sharedLoader 能直接返回缓存中集合文档映射的存储后端，FileStorage 实现了该接口
+//
+// LoadCollection 每次复制映射，大集合上的单次写入也要付出与集合大小成正比的代价；
+// 持有集合锁的读写操作改用 loadShared，见 loadCollection。
+type sharedLoader interface {
+	loadShared(dbName, collectionName string) (map[string]Document, error)
+}
+
+// DefaultStorage 默认存储后端：config.DefaultOptions() 指定根目录下的 JSON 目录
+func DefaultStorage() Storage {
+	defaultStorageOnce.Do(func() {
+		defaultStorage = NewFileStorage(config.DefaultOptions())
+	})
+	return defaultStorage
+}
+
+// NewDBContext 基于指定存储后端创建上下文，storage 为 nil 时使用默认存储
+func NewDBContext(dbName, collectionName string, storage Storage) *DBContext {
+	return &DBContext{
+		CurrentDB:         dbName,
+		CurrentCollection: collectionName,
+		storage:           storage,
+	}
+}
+
+// store 当前上下文使用的存储后端
+func (db *DBContext) store() Storage {
+	if db.storage == nil {
+		db.storage = DefaultStorage()
+	}
+	return db.storage
+}
+
+// catalog 当前存储后端上的元数据目录
+func (db *DBContext) catalog() *ConfigFile.Catalog {
+	if db.meta == nil {
+		db.meta = ConfigFile.NewCatalog(db.store())
+	}
+	return db.meta
+}
+
+// cloneValue 深拷贝 JSON 风格的值（map / slice / 标量）
+func cloneValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[k] = cloneValue(item)
+		}
+		return m
+	case Document:
+		return map[string]interface{}(cloneDocument(val))
+	case []interface{}:
+		arr := make([]interface{}, len(val))
+		for i, item := range val {
+			arr[i] = cloneValue(item)
+		}
+		return arr
+	default:
+		return val
+	}
+}
+
+// cloneDocument 深拷贝文档
+func cloneDocument(doc Document) Document {
+	if doc == nil {
+		return nil
+	}
+	out := make(Document, len(doc))
+	for k, v := range doc {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+// storedTimeLayout 文档中 time.Time 的保存格式：UTC、固定 9 位小数的 RFC3339，按字符串比较即为时间先后
+const storedTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// formatTime 把时间转换为保存格式的字符串
+func formatTime(t time.Time) string {
+	return t.UTC().Format(storedTimeLayout)
+}
+
+// normalizeDocument 把文档中（含嵌套对象与数组）的值原地转换为 JSON 解码后的形状
+//
+// 写入前调用：集合文件重新加载后对象一律为 map[string]interface{}、数组为 []interface{}，time.Time 为字符串。
+// 缓存与 MemoryStorage 直接保存写入的文档，不转换时 []string、map[string]string 等类型化的值会让同一操作
+// 因集合是否已缓存而结果不同（如 $push 认为字段不是数组）。time.Time 统一为保存格式，全表扫描与索引看到的是同一个值；
+// 数字保持原类型，比较与索引按数值处理。
+func normalizeDocument(doc Document) {
+	for k, v := range doc {
+		doc[k] = normalizeValue(v)
+	}
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil, string, bool, json.Number,
+		int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case time.Time:
+		return formatTime(val)
+	case *time.Time:
+		if val == nil {
+			return nil
+		}
+		return formatTime(*val)
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = normalizeValue(item)
+		}
+		return val
+	case Document:
+		normalizeDocument(val)
+		return map[string]interface{}(val)
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalizeValue(item)
+		}
+		return val
+	}
+	return normalizeOther(v)
+}
+
+// normalizeOther 其他类型：类型化的切片、数组与字符串为键的映射逐个元素转换，自定义的基础类型转为对应的内置类型，
+// 实现了 JSON 或文本编码的类型、结构体以及其他映射按 JSON 编码后再解码
+func normalizeOther(v interface{}) interface{} {
+	switch v.(type) {
+	case json.Marshaler, encoding.TextMarshaler:
+		return jsonShape(v)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return normalizeValue(rv.Elem().Interface())
+	case reflect.Slice:
+		if rv.IsNil() {
+			return nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte 编码为 base64 字符串
+			return jsonShape(v)
+		}
+		return normalizeElems(rv)
+	case reflect.Array:
+		return normalizeElems(rv)
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		if rv.Type().Key().Kind() != reflect.String {
+			return jsonShape(v)
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = normalizeValue(iter.Value().Interface())
+		}
+		return m
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return jsonShape(v)
+}
+
+// normalizeElems 切片或数组转为 []interface{}
+func normalizeElems(rv reflect.Value) []interface{} {
+	arr := make([]interface{}, rv.Len())
+	for i := range arr {
+		arr[i] = normalizeValue(rv.Index(i).Interface())
+	}
+	return arr
+}
+
+// jsonShape 按 JSON 编码后再解码；无法编码的值（如函数、通道）原样返回，提交时返回编码错误
+func jsonShape(v interface{}) interface{} {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(bytes, &out); err != nil {
+		return v
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/StephenChristianW/JsonDB/config"
+)
+
+// TestTypedValuesNormalized 类型化的切片与映射写入后与从集合文件重新加载的结果相同，
+// 同一操作不因集合是否已缓存而不同
+func TestTypedValuesNormalized(t *testing.T) {
+	root := t.TempDir()
+	cases := []struct {
+		name  string
+		store Storage
+	}{
+		{"memory", NewMemoryStorage()},
+		{"file", NewFileStorage(config.Options{RootDir: root}.Normalize())},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := NewDBContext("", "", c.store)
+			if err := db.DBCreate("typed"); err != nil {
+				t.Fatalf("DBCreate: %v", err)
+			}
+			db.CurrentDB = "typed"
+			if err := db.CollectionCreate("c"); err != nil {
+				t.Fatalf("CollectionCreate: %v", err)
+			}
+			db.CurrentCollection = "c"
+
+			type level int
+			doc := Document{"tags": []string{"x"}, "attrs": map[string]string{"a": "b"}, "level": level(2)}
+			if _, err := db.InsertOne(doc); err != nil {
+				t.Fatalf("InsertOne: %v", err)
+			}
+			update := Document{"$push": map[string]interface{}{"tags": "y"}, "$set": map[string]interface{}{"ids": []int{1, 2}}}
+			if _, err := db.UpdateOne(nil, update); err != nil {
+				t.Fatalf("UpdateOne: %v", err)
+			}
+
+			got, err := db.FindOne(nil)
+			if err != nil {
+				t.Fatalf("FindOne: %v", err)
+			}
+			want := map[string]interface{}{
+				"tags":  []interface{}{"x", "y"},
+				"attrs": map[string]interface{}{"a": "b"},
+				"level": int64(2),
+				"ids":   []interface{}{1, 2},
+			}
+			for k, v := range want {
+				if !reflect.DeepEqual(got[k], v) {
+					t.Errorf("%s = %#v, want %#v", k, got[k], v)
+				}
+			}
+		})
+	}
+
+	// 集合文件中的文档：对象与数组的形状与缓存中相同（数字解码后为 float64）
+	raw, err := os.ReadFile(NewFileStorage(config.Options{RootDir: root}.Normalize()).collectionPath("typed", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var onDisk map[string]map[string]interface{}
+	if err := json.Unmarshal(raw, &onDisk); err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk) != 1 {
+		t.Fatalf("collection file has %d documents, want 1", len(onDisk))
+	}
+	for _, got := range onDisk {
+		if !reflect.DeepEqual(got["tags"], []interface{}{"x", "y"}) || !reflect.DeepEqual(got["attrs"], map[string]interface{}{"a": "b"}) {
+			t.Errorf("collection file = %#v", got)
+		}
+	}
+}
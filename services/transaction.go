@@ -0,0 +1,287 @@
+package services
+
+import (
+	"errors"
+	"github.com/StephenChristianW/JsonDB/fileIO"
+	"sort"
+	"time"
+)
+
+// ==================== 事务 ====================
+//
+// WithTransaction 在整个回调期间持有当前数据库的写锁：事务内读到的是一致的快照，
+// 该数据库上的其他读写等待事务结束，其他数据库不受影响。文件存储下首次访问集合时还会获得该集合的排他文件锁，
+// 直到事务结束：其他进程有延迟落盘的写入时一直持有集合的文件锁（见 collectionCache.go），事务等待其落盘，不会读到过期的集合文件。
+// 事务内的修改直接作用于加载的集合与索引（文件存储下与缓存共用），并记录撤销日志；回调成功返回后
+// 把全部集合的文档、变更与索引作为一次 Storage.Commit 提交（文件存储下为一条 WAL 记录），随后刷新各集合的文档数量。
+// 回调返回错误、panic 或提交失败时按撤销日志恢复全部修改，数据库保持事务开始前的状态。
+//
+// 事务只作用于当前数据库：WAL 按数据库记录，跨数据库的写入无法保证原子性。
+
+// ErrTxDone 事务已结束后仍在使用 Tx
+var ErrTxDone = errors.New("事务已结束")
+
+// Tx 一个进行中的事务，只能在 WithTransaction 的回调中使用
+type Tx struct {
+	db          *DBContext
+	now         time.Time
+	collections map[string]*TxCollection
+	locks       heldLocks // 已访问集合的文件锁，事务结束时释放
+	done        bool
+}
+
+// TxCollection 事务内的集合，提供与 DBContext 相同的读写方法，修改在事务提交时才生效
+type TxCollection struct {
+	tx        *Tx
+	name      string
+	data      map[string]Document
+	indexes   indexSet
+	versioned bool
+	changes   []Change
+	undo      undoLog // 撤销 changes，事务回滚时使用
+}
+
+// WithTransaction 在当前数据库上执行事务
+// - fn: 事务回调，通过 tx.Collection 读写集合；返回错误时回滚
+//
+// 回调中不能调用 DBContext / DBManager 的其他读写方法（数据库写锁已被事务持有，会死锁）。
+// 回调 panic 时回滚后继续向上 panic。返回 fn 的错误或提交时的错误。
+func (db *DBContext) WithTransaction(fn func(tx *Tx) error) error {
+	if db.CurrentDB == "" {
+		return errors.New("未选择数据库")
+	}
+
+	unlock, err := db.lockDatabases(db.CurrentDB)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tx := &Tx{db: db, now: time.Now(), collections: make(map[string]*TxCollection)}
+	committed := false
+	defer func() {
+		tx.done = true
+		// 回调返回错误、panic 或提交失败时撤销已作用于集合与索引的修改
+		if !committed {
+			tx.rollback()
+		}
+		tx.locks.release()
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// rollback 按撤销日志恢复全部集合
+func (tx *Tx) rollback() {
+	for _, c := range tx.collections {
+		c.undo.revert(c.data, c.indexes)
+	}
+}
+
+// Collection 事务内的集合，首次访问时获得集合的文件锁并加载集合与索引
+// 其他进程持有该集合的文件锁超过 Options.LockTimeout 时返回 *fileIO.LockedError
+func (tx *Tx) Collection(name string) (*TxCollection, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if c, ok := tx.collections[name]; ok {
+		return c, nil
+	}
+	ctx := tx.context(name)
+	if err := ctx.checkCollectionName(name); err != nil {
+		return nil, err
+	}
+	if !ctx.store().CollectionExists(ctx.CurrentDB, name) {
+		return nil, errors.New("集合不存在: " + name)
+	}
+	// 数据库锁之后获得集合锁，与 lockCollections 的顺序相同
+	if fl, ok := ctx.store().(fileLocker); ok {
+		release, err := fl.lockCollection(ctx.CurrentDB, name, fileIO.LockExclusive)
+		if err != nil {
+			return nil, err
+		}
+		tx.locks.push(release)
+	}
+	data, err := loadCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := ctx.loadIndexes(name, data)
+	if err != nil {
+		return nil, err
+	}
+	versioned, err := ctx.versioned(name)
+	if err != nil {
+		return nil, err
+	}
+	c := &TxCollection{tx: tx, name: name, data: data, indexes: indexes, versioned: versioned}
+	tx.collections[name] = c
+	return c, nil
+}
+
+// context 指向事务数据库中某个集合的上下文，与 tx.db 共用存储后端与元数据目录
+func (tx *Tx) context(collectionName string) *DBContext {
+	ctx := *tx.db
+	ctx.CurrentCollection = collectionName
+	return &ctx
+}
+
+// commit 把全部有变更的集合作为一次写入提交，然后刷新文档数量
+func (tx *Tx) commit() error {
+	names := make([]string, 0, len(tx.collections))
+	for name, c := range tx.collections {
+		if len(c.changes) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+
+	writes := make([]CollectionWrite, 0, len(names))
+	for _, name := range names {
+		c := tx.collections[name]
+		indexData, err := tx.context(name).indexWrites(name, c.indexes)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, CollectionWrite{Collection: name, Data: c.data, Changes: c.changes, Indexes: indexData, parsed: c.indexes})
+	}
+	if err := tx.db.store().Commit(tx.db.CurrentDB, writes...); err != nil {
+		return err
+	}
+	for _, name := range names {
+		tx.context(name).committed(tx.collections[name].indexes)
+	}
+
+	// 文档数量不在同一次写入中，异常退出后由 Recover 重放 WAL 时一并刷新
+	for _, name := range names {
+		_ = tx.context(name).setDocCount(name, len(tx.collections[name].data))
+	}
+	return nil
+}
+
+// ---------------- TxCollection 读写 ----------------
+
+// Find 查询文档，能看到本事务之前的修改，opts 与 DBContext.Find 相同
+func (c *TxCollection) Find(filter map[string]interface{}, opts *FindOptions) (DocumentList, error) {
+	if c.tx.done {
+		return nil, ErrTxDone
+	}
+	proj, err := findProjection(opts)
+	if err != nil {
+		return nil, err
+	}
+	result := find(c.data, c.indexes, filter, opts, proj)
+	if proj == nil {
+		// 返回副本，调用方修改结果不影响事务中的数据
+		for i, doc := range result {
+			result[i] = cloneDocument(doc)
+		}
+	}
+	return result, nil
+}
+
+// FindOne 返回第一个满足过滤条件的文档，没有时返回 ErrNotFound
+func (c *TxCollection) FindOne(filter map[string]interface{}) (Document, error) {
+	res, err := c.Find(filter, &FindOptions{Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, ErrNotFound
+	}
+	return res[0], nil
+}
+
+// InsertOne 插入一篇文档并生成新的 _id，返回插入后的文档
+func (c *TxCollection) InsertOne(doc Document) (Document, error) {
+	w, err := InsertOneModel{Document: doc}.writeOp()
+	if err != nil {
+		return nil, err
+	}
+	_, after, err := c.apply(w)
+	if err != nil {
+		return nil, err
+	}
+	return cloneDocument(after), nil
+}
+
+// UpdateOne 更新一个满足过滤条件的文档，见 DBContext.UpdateOne
+func (c *TxCollection) UpdateOne(filter map[string]interface{}, update Document, opts ...UpdateOptions) (*WriteResult, error) {
+	return c.update(filter, update, opts, true)
+}
+
+// UpdateMany 更新全部满足过滤条件的文档，见 DBContext.UpdateMany
+func (c *TxCollection) UpdateMany(filter map[string]interface{}, update Document, opts ...UpdateOptions) (*WriteResult, error) {
+	return c.update(filter, update, opts, false)
+}
+
+func (c *TxCollection) update(filter map[string]interface{}, update Document, opts []UpdateOptions, one bool) (*WriteResult, error) {
+	var opt UpdateOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	w, err := updateWrite(filter, update, opt, one)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := c.apply(w)
+	return res, err
+}
+
+// ReplaceOne 整篇替换一个满足过滤条件的文档，见 DBContext.ReplaceOne
+func (c *TxCollection) ReplaceOne(filter map[string]interface{}, replacement Document, opts ...UpdateOptions) (*WriteResult, error) {
+	var opt UpdateOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	w, err := replaceWrite(filter, replacement, opt)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := c.apply(w)
+	return res, err
+}
+
+// DeleteOne 删除一个满足过滤条件的文档，见 DBContext.DeleteOne
+func (c *TxCollection) DeleteOne(filter map[string]interface{}, opts ...DeleteOptions) (*WriteResult, error) {
+	var opt DeleteOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	res, _, err := c.apply(deleteOneWrite(filter, opt))
+	return res, err
+}
+
+// Delete 删除全部满足过滤条件的文档
+func (c *TxCollection) Delete(filter map[string]interface{}) (*WriteResult, error) {
+	res, _, err := c.apply(writeOp{filter: filter})
+	return res, err
+}
+
+// apply 在事务的集合上执行写操作并记录变更与撤销日志，出错时该操作不留下任何修改
+func (c *TxCollection) apply(w writeOp) (*WriteResult, Document, error) {
+	if c.tx.done {
+		return nil, nil, ErrTxDone
+	}
+	w, err := w.withVersion(c.versioned)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := applyWrite(c.data, c.indexes, w, c.tx.now)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.changes = append(c.changes, out.changes...)
+	c.undo = append(c.undo, out.undo...)
+	return out.res, out.after, nil
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"sort"
+	"time"
+)
+
+const ttlSweeperPath = "JsonDB/services/ttlSweeper.go"
+
+// ==================== TTL 过期清理 ====================
+//
+// TTL 索引是带 ExpireAfterSeconds 的单字段索引。字段值可以是 time.Time、时间字符串
+// （RFC3339，包括 time.Time 与 $currentDate 写入的保存格式；或 "2006-01-02 15:04:05.000" 本地时间格式）或 Unix 秒数；
+// 无法解析为时间、字段缺失或不被索引收录（稀疏、部分索引）的文档永不过期。
+// 清理通过 deleteWhere 完成，与 Delete 走同一条路径，索引和文档数量随之更新。
+
+// timeLayouts 可识别的时间字符串格式
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseTime 把字段值解析为时间
+func parseTime(v interface{}) (time.Time, bool) {
+	switch val := v.(type) {
+	case time.Time:
+		return val, true
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.ParseInLocation(layout, val, time.Local); err == nil {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+	if f, ok := toFloat(v); ok {
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// expired 文档在 now 时刻是否已过期
+func (idx *collectionIndex) expired(doc Document, now time.Time) bool {
+	if !idx.includes(doc) {
+		return false
+	}
+	val, ok := getNestedValue(doc, idx.def.Fields[0])
+	if !ok {
+		return false
+	}
+	t, ok := parseTime(val)
+	if !ok {
+		return false
+	}
+	expireAt := t.Add(time.Duration(*idx.def.ExpireAfterSeconds) * time.Second)
+	return !expireAt.After(now)
+}
+
+// ttlIndexes 集合的全部 TTL 索引，按名称排序；没有 TTL 索引时返回 nil
+func (db *DBContext) ttlIndexes(collectionName string) (indexSet, error) {
+	defs, err := db.catalog().GetIndexes(db.CurrentDB, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(defs))
+	for name, def := range defs {
+		if def.ExpireAfterSeconds != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var set indexSet
+	for _, name := range names {
+		set = append(set, newCollectionIndex(name, defs[name]))
+	}
+	return set, nil
+}
+
+// deleteExpired 删除集合中在 now 时刻已过期的文档
+//
+// 先在不加锁的情况下读取元数据目录，没有 TTL 索引的集合不获取写锁（文件存储下为跨进程的排他锁）；
+// 拿到写锁后重新读取索引定义，期间被删除的 TTL 索引不再生效。
+func (db *DBContext) deleteExpired(collectionName string, now time.Time) (int, error) {
+	ttl, err := db.ttlIndexes(collectionName)
+	if err != nil || len(ttl) == 0 {
+		return 0, err
+	}
+
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if ttl, err = db.ttlIndexes(collectionName); err != nil || len(ttl) == 0 {
+		return 0, err
+	}
+	db.CurrentCollection = collectionName
+	return db.deleteWhere(func(doc Document) bool {
+		for _, idx := range ttl {
+			if idx.expired(doc, now) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// SweepExpired 清理存储中所有集合里已过期的文档，返回删除的文档数
+//
+// DBManager 在后台按固定间隔调用；单个集合清理失败时记录错误并继续处理其他集合，返回第一个错误。
+func SweepExpired(storage Storage) (int, error) {
+	dbNames, err := storage.ListDBs()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	total := 0
+	var firstErr error
+	for _, dbName := range dbNames {
+		collections, err := storage.ListCollections(dbName)
+		if err != nil {
+			continue
+		}
+		for _, collectionName := range collections {
+			db := NewDBContext(dbName, collectionName, storage)
+			n, err := db.deleteExpired(collectionName, now)
+			if err != nil && !storage.CollectionExists(dbName, collectionName) {
+				// 列出集合之后被删除
+				continue
+			}
+			if err != nil {
+				storage.LogError(err.Error()+" | msg: "+dbName+"."+collectionName, "SweepExpired", ttlSweeperPath)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			total += n
+		}
+	}
+	return total, firstErr
+}
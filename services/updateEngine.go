@@ -0,0 +1,579 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== 更新操作符 ====================
+//
+// 更新文档有两种形式：
+//
+//   - 操作符文档，如 {"$set": {"profile.age": 30}, "$inc": {"visits": 1}}，顶层只能是操作符
+//   - 普通文档，如 {"name": "Alice"}，等价于 {"$set": {"name": "Alice"}}，其中的 _id 被忽略
+//
+// 支持的操作符：
+//
+//   - $set / $unset：设置 / 删除字段
+//   - $inc / $mul：数值加 / 乘，字段缺失时分别视为设置为给定值 / 0
+//   - $min / $max：给定值小于 / 大于当前值时替换，比较规则见 compareValues
+//   - $rename：{"旧字段": "新字段"}
+//   - $currentDate：true 或 {"$type": "date"} 写入当前时间（与写入 time.Time 相同的保存格式，见 formatTime），
+//     {"$type": "timestamp"} 写入 Unix 秒数
+//   - $push：追加元素，{"$each": [...], "$sort": 1 | -1 | {"字段": 1}, "$slice": n} 可批量追加、排序并截取
+//   - $addToSet：元素不存在时追加，支持 $each
+//   - $pull：删除等于给定值或满足条件的元素；$pullAll：删除等于列表中任意值的元素
+//   - $pop：1 删除最后一个元素，-1 删除第一个元素
+//
+// 字段路径支持点号，数组元素可用下标访问，如 "items.0.qty"。同一次更新中的字段路径不能相同或互为父子，
+// 也不能修改 _id。任意一个操作失败时整篇文档保持不变。
+
+// updateOperators 支持的更新操作符，按应用顺序排列
+var updateOperators = []string{
+	"$set", "$unset", "$inc", "$mul", "$min", "$max", "$rename", "$currentDate",
+	"$push", "$addToSet", "$pull", "$pullAll", "$pop",
+}
+
+// updateOp 一个字段上的更新操作，参数已校验
+type updateOp struct {
+	op    string
+	field string
+	path  []string
+	arg   interface{}
+	to    []string      // $rename 的目标路径
+	each  []interface{} // $push / $addToSet 追加的元素
+	sort  []SortField   // $push 的 $sort，字段为空表示按元素本身排序
+	slice *int          // $push 的 $slice
+}
+
+// updateSpec 解析后的更新文档
+type updateSpec struct {
+	ops []updateOp
+}
+
+// parseUpdate 校验并解析更新文档
+func parseUpdate(update Document) (*updateSpec, error) {
+	if len(update) == 0 {
+		return nil, errors.New("更新内容不能为空")
+	}
+	operatorCount := 0
+	for k := range update {
+		if strings.HasPrefix(k, "$") {
+			if !slices.Contains(updateOperators, k) {
+				return nil, errors.New("不支持的更新操作符: " + k)
+			}
+			operatorCount++
+		}
+	}
+	if operatorCount == 0 {
+		// 普通文档：按 $set 处理顶层字段
+		fields := make(map[string]interface{}, len(update))
+		for k, v := range update {
+			if k != "_id" {
+				fields[k] = v
+			}
+		}
+		update = Document{"$set": fields}
+	} else if operatorCount != len(update) {
+		return nil, errors.New("更新文档不能同时包含操作符和普通字段")
+	}
+
+	spec := &updateSpec{}
+	var paths []string
+	for _, op := range updateOperators {
+		v, ok := update[op]
+		if !ok {
+			continue
+		}
+		fields := toMap(v)
+		if fields == nil {
+			return nil, errors.New(op + " 的参数必须是对象")
+		}
+		for _, field := range sortedKeys(fields) {
+			u, err := parseUpdateOp(op, field, fields[field])
+			if err != nil {
+				return nil, err
+			}
+			spec.ops = append(spec.ops, u)
+			paths = append(paths, field)
+			if u.to != nil {
+				paths = append(paths, strings.Join(u.to, "."))
+			}
+		}
+	}
+	if a, b, conflict := pathConflict(paths); conflict {
+		return nil, errors.New("更新路径冲突: " + a + " 与 " + b)
+	}
+	return spec, nil
+}
+
+// parseUpdatePath 校验字段路径，不允许修改 _id
+func parseUpdatePath(op, field string) ([]string, error) {
+	path := strings.Split(field, ".")
+	for _, p := range path {
+		if p == "" || strings.HasPrefix(p, "$") {
+			return nil, errors.New(op + " 字段名无效: " + field)
+		}
+	}
+	if path[0] == "_id" {
+		return nil, errors.New("不能修改 _id")
+	}
+	return path, nil
+}
+
+// parseUpdateOp 校验单个字段上的操作参数
+func parseUpdateOp(op, field string, arg interface{}) (updateOp, error) {
+	u := updateOp{op: op, field: field, arg: arg}
+	path, err := parseUpdatePath(op, field)
+	if err != nil {
+		return u, err
+	}
+	u.path = path
+	invalid := func(msg string) (updateOp, error) {
+		return u, errors.New(op + " " + msg + ": " + field)
+	}
+
+	switch op {
+	case "$inc", "$mul":
+		if _, ok := toFloat(arg); !ok {
+			return invalid("的参数必须是数值")
+		}
+	case "$rename":
+		target, ok := arg.(string)
+		if !ok {
+			return invalid("的目标必须是字段名")
+		}
+		if u.to, err = parseUpdatePath(op, target); err != nil {
+			return u, err
+		}
+	case "$currentDate":
+		if b, ok := arg.(bool); ok && b {
+			u.arg = "date"
+			break
+		}
+		t, _ := toMap(arg)["$type"].(string)
+		if t != "date" && t != "timestamp" {
+			return invalid(`的参数必须是 true 或 {"$type": "date" | "timestamp"}`)
+		}
+		u.arg = t
+	case "$push", "$addToSet":
+		u.each = []interface{}{arg}
+		modifiers := toMap(arg)
+		if !hasOperatorKey(modifiers) {
+			break
+		}
+		each, ok := modifiers["$each"].([]interface{})
+		if !ok {
+			return invalid("的修饰符需要 $each 数组")
+		}
+		u.each = each
+		for key, v := range modifiers {
+			switch {
+			case key == "$each":
+			case key == "$slice" && op == "$push":
+				n, ok := toInt(v)
+				if !ok {
+					return invalid("的 $slice 必须是整数")
+				}
+				u.slice = &n
+			case key == "$sort" && op == "$push":
+				if u.sort, ok = parsePushSort(v); !ok {
+					return invalid(`的 $sort 必须是 1、-1 或 {"字段": 1 | -1}`)
+				}
+			default:
+				return invalid("不支持的修饰符 " + key)
+			}
+		}
+	case "$pullAll":
+		if _, ok := arg.([]interface{}); !ok {
+			return invalid("的参数必须是数组")
+		}
+	case "$pop":
+		if n, ok := toInt(arg); !ok || n != 1 && n != -1 {
+			return invalid("的参数必须是 1 或 -1")
+		}
+	}
+	return u, nil
+}
+
+// hasOperatorKey 对象中是否有以 $ 开头的键
+func hasOperatorKey(m map[string]interface{}) bool {
+	for k := range m {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePushSort 解析 $push 的 $sort：1 / -1 按元素本身排序，{"字段": 1 | -1} 按对象元素的字段排序
+func parsePushSort(v interface{}) ([]SortField, bool) {
+	if n, ok := toInt(v); ok {
+		if n != 1 && n != -1 {
+			return nil, false
+		}
+		return []SortField{{Order: n}}, true
+	}
+	fields := toMap(v)
+	if len(fields) == 0 {
+		return nil, false
+	}
+	var keys []SortField
+	for _, field := range sortedKeys(fields) {
+		n, ok := toInt(fields[field])
+		if !ok || n != 1 && n != -1 {
+			return nil, false
+		}
+		keys = append(keys, SortField{Field: field, Order: n})
+	}
+	return keys, true
+}
+
+// apply 把更新应用到 doc 的副本上并返回，doc 本身不变
+func (s *updateSpec) apply(doc Document, now time.Time) (Document, error) {
+	out := cloneDocument(doc)
+	for _, u := range s.ops {
+		if err := u.apply(out, now); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// apply 在 doc 上执行单个操作
+func (u updateOp) apply(doc Document, now time.Time) error {
+	cur, exists := getPath(doc, u.path)
+	switch u.op {
+	case "$set":
+		return setPath(doc, u.path, cloneValue(u.arg))
+	case "$unset":
+		unsetPath(doc, u.path)
+		return nil
+	case "$inc", "$mul":
+		if !exists {
+			if u.op == "$inc" {
+				return setPath(doc, u.path, u.arg)
+			}
+			return setPath(doc, u.path, mulNumbers(u.arg, 0))
+		}
+		if _, ok := toFloat(cur); !ok {
+			return errors.New(u.op + " 只能用于数值字段: " + u.field)
+		}
+		if u.op == "$inc" {
+			return setPath(doc, u.path, addNumbers(cur, u.arg))
+		}
+		return setPath(doc, u.path, mulNumbers(cur, u.arg))
+	case "$min", "$max":
+		c := compareValues(u.arg, cur)
+		if !exists || u.op == "$min" && c < 0 || u.op == "$max" && c > 0 {
+			return setPath(doc, u.path, cloneValue(u.arg))
+		}
+		return nil
+	case "$rename":
+		if !exists {
+			return nil
+		}
+		unsetPath(doc, u.path)
+		return setPath(doc, u.to, cur)
+	case "$currentDate":
+		if u.arg == "timestamp" {
+			return setPath(doc, u.path, now.Unix())
+		}
+		return setPath(doc, u.path, formatTime(now))
+	}
+
+	// 其余为数组操作：字段缺失视为空数组
+	var arr []interface{}
+	if exists {
+		var ok bool
+		if arr, ok = cur.([]interface{}); !ok {
+			return errors.New(u.op + " 只能用于数组字段: " + u.field)
+		}
+	}
+	switch u.op {
+	case "$push":
+		arr = append(slices.Clone(arr), cloneValue(u.each).([]interface{})...)
+		if u.sort != nil {
+			sortElements(arr, u.sort)
+		}
+		if u.slice != nil {
+			arr = sliceElements(arr, *u.slice)
+		}
+	case "$addToSet":
+		arr = slices.Clone(arr)
+		for _, item := range u.each {
+			if !slices.ContainsFunc(arr, func(e interface{}) bool { return valuesEqual(e, item) }) {
+				arr = append(arr, cloneValue(item))
+			}
+		}
+	case "$pull":
+		if !exists {
+			return nil
+		}
+		arr = slices.DeleteFunc(slices.Clone(arr), func(e interface{}) bool { return pullMatches(e, u.arg) })
+	case "$pullAll":
+		if !exists {
+			return nil
+		}
+		values := u.arg.([]interface{})
+		arr = slices.DeleteFunc(slices.Clone(arr), func(e interface{}) bool {
+			return slices.ContainsFunc(values, func(v interface{}) bool { return valuesEqual(e, v) })
+		})
+	case "$pop":
+		if len(arr) == 0 {
+			return nil
+		}
+		if n, _ := toInt(u.arg); n == 1 {
+			arr = arr[:len(arr)-1]
+		} else {
+			arr = arr[1:]
+		}
+	}
+	return setPath(doc, u.path, arr)
+}
+
+// pullMatches 数组元素是否满足 $pull 条件：条件为操作符对象时按操作符比较元素本身，
+// 为普通对象时作为过滤条件匹配对象元素，否则比较是否相等
+func pullMatches(elem, cond interface{}) bool {
+	condMap := toMap(cond)
+	if condMap == nil {
+		return valuesEqual(elem, cond)
+	}
+	if hasOperatorKey(condMap) {
+		for op, c := range condMap {
+			if !matchOperator(elem, op, c) {
+				return false
+			}
+		}
+		return true
+	}
+	elemMap := toMap(elem)
+	return elemMap != nil && matchDoc(elemMap, condMap)
+}
+
+// sortElements $push 的 $sort：字段为空时按元素本身排序，否则按对象元素的字段排序
+func sortElements(arr []interface{}, keys []SortField) {
+	sort.SliceStable(arr, func(i, j int) bool {
+		if keys[0].Field == "" {
+			return compareValues(arr[i], arr[j])*keys[0].Order < 0
+		}
+		return compareDocs(toMap(arr[i]), toMap(arr[j]), keys) < 0
+	})
+}
+
+// sliceElements $push 的 $slice：n 为非负数时保留前 n 个，负数时保留最后 |n| 个
+func sliceElements(arr []interface{}, n int) []interface{} {
+	if n >= 0 {
+		return arr[:min(n, len(arr))]
+	}
+	return arr[max(len(arr)+n, 0):]
+}
+
+// ---------------- 数值运算 ----------------
+
+// integerValue 整数类型的值，浮点数返回 false
+func integerValue(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// addNumbers 两个数值相加，都是整数类型时结果为 int，否则为 float64
+func addNumbers(a, b interface{}) interface{} {
+	ia, okA := integerValue(a)
+	ib, okB := integerValue(b)
+	if okA && okB {
+		return int(ia + ib)
+	}
+	fa, _ := toFloat(a)
+	fb, _ := toFloat(b)
+	return fa + fb
+}
+
+// mulNumbers 两个数值相乘，都是整数类型时结果为 int，否则为 float64
+func mulNumbers(a, b interface{}) interface{} {
+	ia, okA := integerValue(a)
+	ib, okB := integerValue(b)
+	if okA && okB {
+		return int(ia * ib)
+	}
+	fa, _ := toFloat(a)
+	fb, _ := toFloat(b)
+	return fa * fb
+}
+
+// ---------------- 字段路径 ----------------
+
+// getPath 读取路径上的值，支持对象字段与数组下标
+func getPath(doc Document, path []string) (interface{}, bool) {
+	var cur interface{} = map[string]interface{}(doc)
+	for _, p := range path {
+		if m := toMap(cur); m != nil {
+			v, ok := m[p]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+			continue
+		}
+		arr, ok := cur.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		i, err := strconv.Atoi(p)
+		if err != nil || i < 0 || i >= len(arr) {
+			return nil, false
+		}
+		cur = arr[i]
+	}
+	return cur, true
+}
+
+// setPath 设置路径上的值，缺失的中间字段创建为对象；中间字段不是对象或数组、数组下标越界时返回错误
+func setPath(doc Document, path []string, v interface{}) error {
+	var cur interface{} = map[string]interface{}(doc)
+	for i, p := range path {
+		last := i == len(path)-1
+		if m := toMap(cur); m != nil {
+			if last {
+				m[p] = v
+				return nil
+			}
+			next, ok := m[p]
+			if !ok || next == nil {
+				next = make(map[string]interface{})
+				m[p] = next
+			}
+			cur = next
+			continue
+		}
+		arr, ok := cur.([]interface{})
+		if !ok {
+			return errors.New("无法在非对象字段上设置: " + strings.Join(path[:i+1], "."))
+		}
+		idx, err := strconv.Atoi(p)
+		if err != nil || idx < 0 || idx >= len(arr) {
+			return errors.New("数组下标无效: " + strings.Join(path[:i+1], "."))
+		}
+		if last {
+			arr[idx] = v
+			return nil
+		}
+		if arr[idx] == nil {
+			arr[idx] = make(map[string]interface{})
+		}
+		cur = arr[idx]
+	}
+	return nil
+}
+
+// unsetPath 删除路径上的字段，数组元素被置为 null；路径不存在时不做任何操作
+func unsetPath(doc Document, path []string) {
+	parent, ok := getPath(doc, path[:len(path)-1])
+	if !ok {
+		return
+	}
+	last := path[len(path)-1]
+	if m := toMap(parent); m != nil {
+		delete(m, last)
+		return
+	}
+	if arr, ok := parent.([]interface{}); ok {
+		if i, err := strconv.Atoi(last); err == nil && i >= 0 && i < len(arr) {
+			arr[i] = nil
+		}
+	}
+}
+
+// ---------------- upsert 与整篇替换 ----------------
+
+// upsertBase 由过滤条件中的等值条件（含 $and 中的）生成 upsert 新文档的初始内容
+func upsertBase(filter map[string]interface{}) (Document, error) {
+	doc := make(Document)
+	var collect func(f map[string]interface{}) error
+	collect = func(f map[string]interface{}) error {
+		for _, k := range sortedKeys(f) {
+			if k == "$and" {
+				conds, _ := f[k].([]interface{})
+				for _, cond := range conds {
+					if condMap := toMap(cond); condMap != nil {
+						if err := collect(condMap); err != nil {
+							return err
+						}
+					}
+				}
+				continue
+			}
+			if strings.HasPrefix(k, "$") {
+				continue
+			}
+			val, ok := equalityValue(f[k])
+			if !ok {
+				continue
+			}
+			if err := setPath(doc, strings.Split(k, "."), cloneValue(val)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := collect(filter); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// checkReplacement 替换文档必须是普通文档，不能包含更新操作符
+func checkReplacement(replacement Document) error {
+	if hasOperatorKey(replacement) {
+		return errors.New("替换文档不能包含更新操作符")
+	}
+	return nil
+}
+
+// replaceDoc 用 replacement 整篇替换 old，保留 old 的 _id；replacement 带有不同的 _id 时返回错误
+func replaceDoc(old, replacement Document) (Document, error) {
+	if id, ok := replacement["_id"]; ok && !valuesEqual(id, old["_id"]) {
+		return nil, errors.New("不能修改 _id")
+	}
+	doc := cloneDocument(replacement)
+	doc["_id"] = old["_id"]
+	return doc, nil
+}
+
+// upsertReplacement 生成替换式 upsert 插入的新文档：只沿用过滤条件中的 _id 等值条件
+func upsertReplacement(filter map[string]interface{}, replacement Document) (Document, error) {
+	base := make(Document)
+	if cond, ok := filter["_id"]; ok {
+		if id, isEq := equalityValue(cond); isEq {
+			base["_id"] = id
+		}
+	}
+	if id, ok := replacement["_id"]; ok {
+		if filterID, exists := base["_id"]; exists && !valuesEqual(id, filterID) {
+			return nil, errors.New("不能修改 _id")
+		}
+		base["_id"] = id
+	}
+	return withUpsertID(cloneDocument(replacement), base)
+}
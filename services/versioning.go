@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ==================== 文档版本 _version ====================
+//
+// 集合启用文档版本后，每篇文档都带有整数字段 _version：插入时为 1，内容每变化一次加 1，
+// 内容没有变化的更新不改变版本。_version 由数据库维护，更新或替换中给出的值会被忽略。
+//
+// UpdateOne / ReplaceOne / DeleteOne 可以通过 ExpectedVersion 指定期望的版本：
+// 选中的文档版本不一致时不做任何修改并返回 *VersionConflictError，实现 compare-and-swap。
+
+// VersionField 文档版本字段名
+const VersionField = "_version"
+
+// VersionConflictError 文档的当前版本与期望版本不一致
+type VersionConflictError struct {
+	ID       string // 文档 _id
+	Expected int    // 期望的版本
+	Actual   int    // 文档当前的版本
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("文档版本冲突: %s 期望版本 %d，当前版本 %d", e.ID, e.Expected, e.Actual)
+}
+
+// SetVersioning 设置集合是否维护文档版本
+// - collectionName: 集合名
+// - enabled: true 时启用，已有文档中没有 _version 的补为 1；false 时停止维护，已有的 _version 保留
+func (db *DBContext) SetVersioning(collectionName string, enabled bool) error {
+	unlock, err := db.writeLock(collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = db.setVersioning(collectionName, enabled)
+	return db.writeSettingsError("SetVersioning", err, collectionName)
+}
+
+func (db *DBContext) setVersioning(collectionName string, enabled bool) error {
+	if err := db.checkCollectionName(collectionName); err != nil {
+		return err
+	}
+	if enabled {
+		ctx := *db
+		ctx.CurrentCollection = collectionName
+		w := writeOp{filter: map[string]interface{}{}}
+		w.modify = func(old Document, _ time.Time) (Document, error) {
+			if docVersion(old) > 0 {
+				return old, nil
+			}
+			doc := cloneDocument(old)
+			doc[VersionField] = 1
+			return doc, nil
+		}
+		if _, _, _, err := ctx.write(w); err != nil {
+			return err
+		}
+	}
+	return db.catalog().SetVersioned(db.CurrentDB, collectionName, enabled)
+}
+
+// versioned 集合是否维护文档版本
+func (db *DBContext) versioned(collectionName string) (bool, error) {
+	return db.catalog().GetVersioned(db.CurrentDB, collectionName)
+}
+
+// docVersion 文档的版本，没有 _version 或不是正整数时为 0
+func docVersion(doc Document) int {
+	v, ok := toInt(doc[VersionField])
+	if !ok || v < 0 {
+		return 0
+	}
+	return v
+}
+
+// checkVersion 检查写操作的期望版本
+func (w writeOp) checkVersion(id string, old Document) error {
+	if w.version == 0 {
+		return nil
+	}
+	if actual := docVersion(old); actual != w.version {
+		return &VersionConflictError{ID: id, Expected: w.version, Actual: actual}
+	}
+	return nil
+}
+
+// withVersion 为期望版本的写操作做准备：集合必须启用文档版本
+func (w writeOp) withVersion(versioned bool) (writeOp, error) {
+	if w.version != 0 && !versioned {
+		return w, errors.New("集合未启用文档版本，不能指定 ExpectedVersion")
+	}
+	w.versioned = versioned
+	return w, nil
+}
+
+// bumpVersion 设置修改后文档的版本：内容与 old 相同时保持原版本，否则为原版本加 1
+func bumpVersion(old, doc Document) {
+	if v, ok := old[VersionField]; ok {
+		doc[VersionField] = v
+	} else {
+		delete(doc, VersionField)
+	}
+	if compareValues(old, doc) != 0 {
+		doc[VersionField] = docVersion(old) + 1
+	}
+}